@@ -5,7 +5,9 @@ import (
 	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
+	"math/big"
 	"os"
 	"strconv"
 	"sync"
@@ -18,7 +20,10 @@ import (
 
 var jsoniterCfg = jsoniter.ConfigCompatibleWithStandardLibrary
 
-type marshalFunc func(interface{}) ([]byte, error)
+type (
+	marshalFunc   func(interface{}) ([]byte, error)
+	unmarshalFunc func([]byte, interface{}) error
+)
 
 type codeResponse struct {
 	Tree     *codeNode `json:"tree"`
@@ -47,8 +52,8 @@ type simplePayload struct {
 	V    bool   `json:"v"`
 }
 
-func BenchmarkSimple(b *testing.B) {
-	sp := &simplePayload{
+func newSimplePayload() *simplePayload {
+	return &simplePayload{
 		St:   1,
 		Sid:  2,
 		Tt:   "TestString",
@@ -59,7 +64,13 @@ func BenchmarkSimple(b *testing.B) {
 		Tz:   8,
 		V:    true,
 	}
+}
+
+func BenchmarkSimple(b *testing.B) {
+	sp := newSimplePayload()
 	benchMarshal(b, sp)
+	benchEncoder(b, "jettison-encoder", sp, NoHTMLEscaping())
+	benchTypedEncoder(b, "jettison-typed", sp, NoHTMLEscaping())
 }
 
 func BenchmarkComplex(b *testing.B) {
@@ -72,6 +83,96 @@ func BenchmarkCodeMarshal(b *testing.B) {
 	benchMarshal(b, x)
 }
 
+func BenchmarkSimpleUnmarshal(b *testing.B) {
+	data, err := Marshal(newSimplePayload())
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchUnmarshal(b, data, func() interface{} { return new(simplePayload) })
+}
+
+func BenchmarkCodeUnmarshal(b *testing.B) {
+	data, err := Marshal(codeInit(b))
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchUnmarshal(b, data, func() interface{} { return new(codeResponse) })
+}
+
+func BenchmarkInterfaceUnmarshal(b *testing.B) {
+	data, err := Marshal(codeInit(b))
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchUnmarshal(b, data, func() interface{} { return new(interface{}) })
+}
+
+func BenchmarkCodeValid(b *testing.B) {
+	data, err := Marshal(codeInit(b))
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchFormat(b, data, map[string]func([]byte) ([]byte, error){
+		"standard": func(dst []byte) ([]byte, error) {
+			return dst, validError(json.Valid(data))
+		},
+		"jettison": func(dst []byte) ([]byte, error) {
+			return dst, validError(Valid(data))
+		},
+	})
+}
+
+func BenchmarkCodeCompact(b *testing.B) {
+	data, err := MarshalOpts(codeInit(b), Indent("", "  "))
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchFormat(b, data, map[string]func([]byte) ([]byte, error){
+		"standard": func(dst []byte) ([]byte, error) {
+			buf := bytes.NewBuffer(dst)
+			err := json.Compact(buf, data)
+			return buf.Bytes(), err
+		},
+		"jettison": func(dst []byte) ([]byte, error) {
+			return AppendCompact(dst, data, NoHTMLEscaping(), NoUTF8Coercion())
+		},
+	})
+}
+
+func BenchmarkCodeIndent(b *testing.B) {
+	data, err := Marshal(codeInit(b))
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchFormat(b, data, map[string]func([]byte) ([]byte, error){
+		"standard": func(dst []byte) ([]byte, error) {
+			buf := bytes.NewBuffer(dst)
+			err := json.Indent(buf, data, "", "  ")
+			return buf.Bytes(), err
+		},
+		"jettison": func(dst []byte) ([]byte, error) {
+			return AppendIndent(dst, data, "", "  ", NoHTMLEscaping(), NoUTF8Coercion())
+		},
+	})
+}
+
+func BenchmarkCodeRawMessage(b *testing.B) {
+	// The output of the marshalers is validated
+	// and compacted, with the strings escaped.
+	data, err := MarshalOpts(codeInit(b), Indent("", "  "))
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchFormat(b, data, map[string]func([]byte) ([]byte, error){
+		"standard": func(dst []byte) ([]byte, error) {
+			return json.Marshal(json.RawMessage(data))
+		},
+		"jettison": func(dst []byte) ([]byte, error) {
+			return Append(dst, json.RawMessage(data))
+		},
+	})
+}
+
 func BenchmarkMap(b *testing.B) {
 	m := map[string]int{
 		"Cassianus": 1,
@@ -133,6 +234,14 @@ func BenchmarkTime(b *testing.B) {
 	benchMarshal(b, t)
 }
 
+func BenchmarkBigInt(b *testing.B) {
+	if testing.Short() {
+		b.SkipNow()
+	}
+	i, _ := new(big.Int).SetString("170141183460469231731687303715884105727", 10)
+	benchMarshal(b, i)
+}
+
 func BenchmarkStringEscaping(b *testing.B) {
 	if testing.Short() {
 		b.SkipNow()
@@ -236,6 +345,40 @@ func benchMarshalOpts(b *testing.B, name string, x interface{}, opts ...Option)
 	})
 }
 
+func benchEncoder(b *testing.B, name string, x interface{}, opts ...Option) {
+	enc, err := NewEncoder(opts...)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Run(name, func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			bts, err := enc.Marshal(x)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(int64(len(bts)))
+		}
+	})
+}
+
+func benchTypedEncoder[T any](b *testing.B, name string, x T, opts ...Option) {
+	enc, err := NewTypedEncoder[T](opts...)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Run(name, func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			bts, err := enc.Marshal(x)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(int64(len(bts)))
+		}
+	})
+}
+
 func benchMarshal(b *testing.B, x interface{}) {
 	for _, bb := range []struct {
 		name string
@@ -259,3 +402,50 @@ func benchMarshal(b *testing.B, x interface{}) {
 		})
 	}
 }
+
+func benchUnmarshal(b *testing.B, data []byte, newValue func() interface{}) {
+	for _, bb := range []struct {
+		name string
+		fn   unmarshalFunc
+	}{
+		{"standard", json.Unmarshal},
+		{"jsoniter", jsoniterCfg.Unmarshal},
+		{"segmentj", segmentj.Unmarshal},
+		{"jettison", Unmarshal},
+	} {
+		bb := bb
+		b.Run(bb.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				if err := bb.fn(data, newValue()); err != nil {
+					b.Error(err)
+				}
+			}
+		})
+	}
+}
+
+func benchFormat(b *testing.B, data []byte, fns map[string]func([]byte) ([]byte, error)) {
+	for _, name := range []string{"standard", "jettison"} {
+		fn := fns[name]
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(data)))
+			var dst []byte
+			for i := 0; i < b.N; i++ {
+				var err error
+				if dst, err = fn(dst[:0]); err != nil {
+					b.Error(err)
+				}
+			}
+		})
+	}
+}
+
+func validError(valid bool) error {
+	if !valid {
+		return errors.New("invalid JSON")
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package jettison
+
+import (
+	"math"
+	"math/big"
+	"reflect"
+	"unsafe"
+)
+
+// RatFmt represents the format used to encode
+// the big.Rat values that have no finite decimal
+// representation, such as 1/3. The others are
+// always encoded as exact decimal numbers.
+type RatFmt int
+
+// RatFmt constants.
+const (
+	RatError    RatFmt = iota // default
+	RatFraction               // "1/3"
+	RatFloat                  // 0.3333333333333333
+)
+
+// String implements the fmt.Stringer
+// interface for RatFmt.
+func (f RatFmt) String() string {
+	if !f.valid() {
+		return "unknown"
+	}
+	return ratFmtStr[f]
+}
+
+func (f RatFmt) valid() bool {
+	return f >= RatError && f <= RatFloat
+}
+
+var ratFmtStr = []string{"error", "fraction", "float"}
+
+func isBigNumber(t reflect.Type) bool {
+	return t == bigIntType || t == bigFloatType || t == bigRatType
+}
+
+// encodeBigInt appends the big.Int value
+// pointed by p to dst as a JSON number. In the
+// canonical form, the values are quoted with the
+// Int64Format option like the 64-bit integers.
+func encodeBigInt(p unsafe.Pointer, dst []byte, opts encOpts) ([]byte, error) {
+	i := (*big.Int)(p)
+
+	quoted := opts.flags.has(bigNumberAsString)
+	if !quoted && opts.flags.has(canonical) {
+		safe := i.BitLen() <= 53 // magnitude up to 2^53-1
+
+		switch opts.int64Fmt {
+		case Int64String:
+			quoted = true
+		case Int64StringIfUnsafe:
+			quoted = !safe
+		}
+		if !quoted && !safe {
+			return dst, &UnsupportedValueError{
+				Value: reflect.ValueOf(new(big.Int).Set(i)),
+				Str:   i.String(),
+			}
+		}
+	}
+	if quoted {
+		dst = append(dst, '"')
+	}
+	dst = i.Append(dst, 10)
+	if quoted {
+		dst = append(dst, '"')
+	}
+	return dst, nil
+}
+
+// encodeBigFloat appends the big.Float value
+// pointed by p to dst as a JSON number, with
+// the smallest number of digits necessary to
+// represent it uniquely with its precision.
+func encodeBigFloat(p unsafe.Pointer, dst []byte, opts encOpts) ([]byte, error) {
+	f := (*big.Float)(p)
+	if f.IsInf() {
+		return appendNonFiniteFloat(dst, math.Inf(f.Sign()), 64, opts)
+	}
+	quoted := opts.flags.has(bigNumberAsString)
+	if !quoted && opts.flags.has(canonical) {
+		f64, _ := f.Float64()
+		return appendCanonicalFloat(dst, f64, opts)
+	}
+	if quoted {
+		dst = append(dst, '"')
+	}
+	dst = f.Append(dst, 'g', -1)
+	if quoted {
+		dst = append(dst, '"')
+	}
+	return dst, nil
+}
+
+// encodeBigRat appends the big.Rat value pointed
+// by p to dst as an exact JSON number, or based on
+// the rat format configured in opts if it has no
+// finite decimal representation.
+func encodeBigRat(p unsafe.Pointer, dst []byte, opts encOpts) ([]byte, error) {
+	r := (*big.Rat)(p)
+
+	prec, exact := ratDecimals(r)
+	if !exact {
+		switch opts.ratFmt {
+		default: // RatError
+			return dst, &UnsupportedValueError{
+				Value: reflect.ValueOf(new(big.Rat).Set(r)),
+				Str:   r.String(),
+			}
+		case RatFraction:
+			dst = append(dst, '"')
+			dst = append(dst, r.String()...)
+			return append(dst, '"'), nil
+		case RatFloat:
+			f, _ := r.Float64()
+			if opts.flags.has(bigNumberAsString) {
+				return appendQuotedFloat(dst, f, 64, opts)
+			}
+			return appendFloat(dst, f, 64, opts)
+		}
+	}
+	quoted := opts.flags.has(bigNumberAsString)
+	if !quoted && opts.flags.has(canonical) {
+		f, _ := r.Float64()
+		return appendCanonicalFloat(dst, f, opts)
+	}
+	if quoted {
+		dst = append(dst, '"')
+	}
+	if prec == 0 {
+		dst = r.Num().Append(dst, 10)
+	} else {
+		dst = append(dst, r.FloatString(prec)...)
+	}
+	if quoted {
+		dst = append(dst, '"')
+	}
+	return dst, nil
+}
+
+// ratDecimals returns the number of decimals of the
+// exact decimal representation of r, and false if it
+// has none, because the denominator of the reduced
+// fraction has a prime factor other than 2 and 5.
+func ratDecimals(r *big.Rat) (int, bool) {
+	if r.IsInt() {
+		return 0, true
+	}
+	d := new(big.Int).Set(r.Denom())
+
+	twos := int(d.TrailingZeroBits())
+	d.Rsh(d, uint(twos))
+
+	var (
+		fives int
+		five  = big.NewInt(5)
+		q, m  big.Int
+	)
+	for {
+		q.QuoRem(d, five, &m)
+		if m.Sign() != 0 {
+			break
+		}
+		d.Set(&q)
+		fives++
+	}
+	if d.Cmp(big.NewInt(1)) != 0 {
+		return 0, false
+	}
+	if twos > fives {
+		return twos, true
+	}
+	return fives, true
+}
@@ -0,0 +1,144 @@
+package jettison
+
+import (
+	"encoding/json"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestRatDecimals(t *testing.T) {
+	for _, tt := range []struct {
+		rat   string
+		prec  int
+		exact bool
+	}{
+		{"0", 0, true},
+		{"-42", 0, true},
+		{"1/2", 1, true},
+		{"1/8", 3, true},
+		{"3/25", 2, true},
+		{"1/40", 3, true},
+		{"7/1000000", 6, true},
+		{"1/3", 0, false},
+		{"1/6", 0, false},
+		{"5/15", 0, false},
+	} {
+		r, ok := new(big.Rat).SetString(tt.rat)
+		if !ok {
+			t.Fatalf("invalid rat %s", tt.rat)
+		}
+		prec, exact := ratDecimals(r)
+		if prec != tt.prec || exact != tt.exact {
+			t.Errorf("%s: got (%d, %t), want (%d, %t)", tt.rat, prec, exact, tt.prec, tt.exact)
+		}
+	}
+}
+
+type bigNumbers struct {
+	I  big.Int    `json:"i"`
+	IP *big.Int   `json:"ip"`
+	F  big.Float  `json:"f"`
+	FP *big.Float `json:"fp"`
+	R  big.Rat    `json:"r"`
+	RP *big.Rat   `json:"rp"`
+	N  *big.Int   `json:"n"`
+}
+
+// TestBigNumbers tests that the big numbers are
+// encoded as JSON numbers, whether they are
+// addressable or not.
+func TestBigNumbers(t *testing.T) {
+	i128, _ := new(big.Int).SetString("-170141183460469231731687303715884105728", 10)
+
+	v := bigNumbers{
+		I:  *i128,
+		IP: big.NewInt(math.MaxInt64),
+		F:  *big.NewFloat(1.5),
+		FP: new(big.Float).SetPrec(200).Quo(big.NewFloat(1), big.NewFloat(3)),
+		R:  *big.NewRat(-1, 8),
+		RP: big.NewRat(10, 2),
+	}
+	const (
+		want = `{"i":-170141183460469231731687303715884105728,"ip":9223372036854775807,"f":1.5,"fp":0.3333333333333333333333333333333333333333333333333333333333334,"r":-0.125,"rp":5,"n":null}`
+		str  = `{"i":"-170141183460469231731687303715884105728","ip":"9223372036854775807","f":"1.5","fp":"0.3333333333333333333333333333333333333333333333333333333333334","r":"-0.125","rp":"5","n":null}`
+	)
+	for _, tt := range []struct {
+		v    interface{}
+		opts []Option
+		want string
+	}{
+		{v, nil, want},
+		{&v, nil, want},
+		{[]interface{}{v.IP, &v.F, v.R}, nil, `[9223372036854775807,1.5,-0.125]`},
+		{v, []Option{BigNumberAsString()}, str},
+		{&v, []Option{BigNumberAsString()}, str},
+	} {
+		b, err := MarshalOpts(tt.v, tt.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := string(b); s != tt.want {
+			t.Errorf("got %s, want %s", s, tt.want)
+		}
+		if !json.Valid(b) {
+			t.Errorf("invalid JSON: %s", b)
+		}
+	}
+}
+
+func TestBigFloatInf(t *testing.T) {
+	f := new(big.Float).SetInf(true)
+
+	if _, err := Marshal(f); err == nil {
+		t.Error("expected non-nil error")
+	} else if _, ok := err.(*UnsupportedValueError); !ok {
+		t.Errorf("got %T, want UnsupportedValueError", err)
+	}
+	b, err := MarshalOpts(f, NonFiniteFormat(NonFiniteString))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `"-Infinity"`; string(b) != want {
+		t.Errorf("got %s, want %s", b, want)
+	}
+}
+
+// TestRatFormat tests the encoding of the big.Rat
+// values with no finite decimal representation.
+func TestRatFormat(t *testing.T) {
+	v := map[string]*big.Rat{
+		"r": big.NewRat(-2, 3),
+	}
+	_, err := Marshal(v)
+	if err == nil {
+		t.Fatal("expected non-nil error")
+	}
+	uve, ok := err.(*UnsupportedValueError)
+	if !ok {
+		t.Fatalf("got %T, want UnsupportedValueError", err)
+	}
+	if want := "json: unsupported value: -2/3 at $.r"; uve.Error() != want {
+		t.Errorf("got %q, want %q", uve.Error(), want)
+	}
+	for _, tt := range []struct {
+		opts []Option
+		want string
+	}{
+		{[]Option{RatFormat(RatFraction)}, `{"r":"-2/3"}`},
+		{[]Option{RatFormat(RatFraction), BigNumberAsString()}, `{"r":"-2/3"}`},
+		{[]Option{RatFormat(RatFloat)}, `{"r":-0.6666666666666666}`},
+		{[]Option{RatFormat(RatFloat), BigNumberAsString()}, `{"r":"-0.6666666666666666"}`},
+	} {
+		b, err := MarshalOpts(v, tt.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := string(b); s != tt.want {
+			t.Errorf("got %s, want %s", s, tt.want)
+		}
+	}
+	if _, err := MarshalOpts(v, RatFormat(RatFmt(3))); err == nil {
+		t.Error("expected non-nil error")
+	}
+}
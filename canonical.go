@@ -0,0 +1,445 @@
+package jettison
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// canonicalOpts are the options used to escape the
+// strings of the JSON values that are canonicalized.
+var canonicalOpts = encOpts{encConfig: &defaultEncConfig, flags: canonical | noHTMLEscaping}
+
+// compareUTF16 compares the UTF-8 encoded strings a and
+// b by their UTF-16 code units, which is the order of
+// the object members defined by RFC 8785, Section 3.2.3.
+// It differs from the order of the bytes for the runes
+// above U+FFFF, represented by a surrogate pair, which
+// sort before the runes between U+E000 and U+FFFF.
+func compareUTF16(a, b []byte) int {
+	for len(a) != 0 && len(b) != 0 {
+		ra, na := utf8.DecodeRune(a)
+		rb, nb := utf8.DecodeRune(b)
+		if ra != rb {
+			if (ra > 0xFFFF) != (rb > 0xFFFF) {
+				ra, rb = firstUTF16(ra), firstUTF16(rb)
+			}
+			if ra < rb {
+				return -1
+			}
+			return 1
+		}
+		a, b = a[na:], b[nb:]
+	}
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+	return 0
+}
+
+// firstUTF16 returns the first UTF-16 code unit of r.
+func firstUTF16(r rune) rune {
+	if r > 0xFFFF {
+		r1, _ := utf16.EncodeRune(r)
+		return r1
+	}
+	return r
+}
+
+// compareKeys is similar to compareUTF16, for keys
+// that may contain escape sequences, which must be
+// compared by the characters they represent.
+func compareKeys(a, b []byte) int {
+	if bytes.IndexByte(a, '\\') != -1 {
+		a, _ = unquoteBytes(a, nil)
+	}
+	if bytes.IndexByte(b, '\\') != -1 {
+		b, _ = unquoteBytes(b, nil)
+	}
+	return compareUTF16(a, b)
+}
+
+// sortCanonical sorts the fields of a struct
+// by name in the order defined by RFC 8785.
+func sortCanonical(flds []field) {
+	sort.SliceStable(flds, func(i, j int) bool {
+		return compareUTF16([]byte(flds[i].name), []byte(flds[j].name)) < 0
+	})
+}
+
+// appendCanonicalFloat appends f to dst with the
+// serialization of the ECMAScript numbers, defined
+// by RFC 8785, Section 3.2.2.3.
+func appendCanonicalFloat(dst []byte, f float64, opts encOpts) ([]byte, error) {
+	if f == 0 {
+		// Negative zero is serialized as 0.
+		return append(dst, '0'), nil
+	}
+	return appendFloat(dst, f, 64, opts)
+}
+
+// appendCanonicalNumber appends the number literal
+// num to dst with the serialization of RFC 8785, that
+// of the ECMAScript numbers, once converted to the
+// nearest 64-bit floating-point number. num may share
+// its memory with the end of dst.
+func appendCanonicalNumber(dst, num []byte, opts encOpts) ([]byte, error) {
+	f, err := strconv.ParseFloat(string(num), 64)
+	if err != nil {
+		return dst, &UnsupportedValueError{
+			Value: reflect.ValueOf(string(num)),
+			Str:   string(num),
+		}
+	}
+	return appendCanonicalFloat(dst, f, opts)
+}
+
+// wrapCanonicalNumberInstr wraps an instruction that
+// appends a number literal, or a quoted string, and
+// converts the number to its canonical serialization.
+func wrapCanonicalNumberInstr(ins instruction) instruction {
+	return func(p unsafe.Pointer, dst []byte, opts encOpts) ([]byte, error) {
+		off := len(dst)
+		dst, err := ins(p, dst, opts)
+		if err != nil || len(dst) == off || dst[off] == '"' || dst[off] == 'n' {
+			return dst, err
+		}
+		return appendCanonicalNumber(dst[:off], dst[off:], opts)
+	}
+}
+
+// newCanonicalFloatInstr returns an instruction that
+// encodes the floats of type t as ECMAScript numbers,
+// which are 64-bit floating-point numbers.
+func newCanonicalFloatInstr(t reflect.Type, quoted bool) instruction {
+	load := func(p unsafe.Pointer) float64 { return *(*float64)(p) }
+	if t.Kind() == reflect.Float32 {
+		load = func(p unsafe.Pointer) float64 { return float64(*(*float32)(p)) }
+	}
+	return func(p unsafe.Pointer, dst []byte, opts encOpts) ([]byte, error) {
+		f := load(p)
+		if !quoted || math.IsInf(f, 0) || math.IsNaN(f) {
+			return appendCanonicalFloat(dst, f, opts)
+		}
+		dst = append(dst, '"')
+		dst, _ = appendCanonicalFloat(dst, f, opts)
+		return append(dst, '"'), nil
+	}
+}
+
+// newCanonicalInt64Instr returns an instruction that
+// encodes the 64-bit integers of type t, and returns
+// an UnsupportedValueError for the integers that can't
+// be represented exactly by an ECMAScript number.
+func newCanonicalInt64Instr(t reflect.Type) instruction {
+	if t.Kind() >= reflect.Int && t.Kind() <= reflect.Int64 {
+		return func(p unsafe.Pointer, dst []byte, _ encOpts) ([]byte, error) {
+			i := *(*int64)(p)
+			if i > maxSafeInteger || i < -maxSafeInteger {
+				return dst, &UnsupportedValueError{
+					Value: reflect.ValueOf(i),
+					Str:   strconv.FormatInt(i, 10),
+				}
+			}
+			return strconv.AppendInt(dst, i, 10), nil
+		}
+	}
+	return func(p unsafe.Pointer, dst []byte, _ encOpts) ([]byte, error) {
+		u := *(*uint64)(p)
+		if u > maxSafeInteger {
+			return dst, &UnsupportedValueError{
+				Value: reflect.ValueOf(u),
+				Str:   strconv.FormatUint(u, 10),
+			}
+		}
+		return strconv.AppendUint(dst, u, 10), nil
+	}
+}
+
+// canonicalizeAppended replaces the JSON value that
+// a marshaler appended to dst, starting at offset off,
+// with its canonical form.
+func canonicalizeAppended(dst []byte, off int) ([]byte, error) {
+	buf := cachedBuffer()
+	buf.B = append(buf.B, dst[off:]...)
+
+	var err error
+	dst, err = appendCanonicalJSON(dst[:off], buf.B)
+	bufferPool.Put(buf)
+
+	return dst, err
+}
+
+// appendCanonicalJSON appends to dst the canonical form
+// of the JSON value src, defined by RFC 8785: the members
+// of the objects are sorted, and the strings and numbers
+// serialized like ECMAScript does, without whitespaces.
+func appendCanonicalJSON(dst, src []byte) ([]byte, error) {
+	if err := checkValid(src); err != nil {
+		return dst, err
+	}
+	c := canonicalizer{src: src}
+	c.skipSpace()
+
+	return c.value(dst)
+}
+
+// A canonicalizer parses a valid JSON value and
+// appends its canonical form. The values that have
+// no canonical form are reported as syntax errors.
+type canonicalizer struct {
+	src []byte
+	off int
+}
+
+func (c *canonicalizer) syntaxError(msg string, pos int) error {
+	return newSyntaxError(msg, c.src, pos)
+}
+
+func (c *canonicalizer) skipSpace() {
+	c.off = skipSpace(c.src, c.off)
+}
+
+func (c *canonicalizer) value(dst []byte) ([]byte, error) {
+	switch b := c.src[c.off]; {
+	case b == '{':
+		return c.object(dst)
+	case b == '[':
+		return c.array(dst)
+	case b == '"':
+		s, err := c.string()
+		if err != nil {
+			return dst, err
+		}
+		dst = append(dst, '"')
+		dst = appendEscapedBytes(dst, s, canonicalOpts)
+		return append(dst, '"'), nil
+	case isNumberStart(b):
+		return c.number(dst)
+	default:
+		start := c.off
+		c.off = skipValue(c.src, c.off)
+		return append(dst, c.src[start:c.off]...), nil
+	}
+}
+
+func (c *canonicalizer) object(dst []byte) ([]byte, error) {
+	type member struct {
+		key []byte
+		val []byte
+		pos int
+	}
+	buf := cachedBuffer()
+	defer bufferPool.Put(buf)
+
+	c.off++ // '{'
+	c.skipSpace()
+	if c.src[c.off] == '}' {
+		c.off++
+		return append(dst, "{}"...), nil
+	}
+	// The keys and canonical values of the members
+	// are stored in a buffer, to be sorted before
+	// they are appended to dst, along with the
+	// offsets of the keys in the source.
+	var (
+		offsets []int
+		err     error
+	)
+	for {
+		pos := c.off
+		var key []byte
+		if key, err = c.string(); err != nil {
+			return dst, err
+		}
+		koff := len(buf.B)
+		buf.B = append(buf.B, key...)
+		kend := len(buf.B)
+
+		c.skipSpace()
+		c.off++ // ':'
+		c.skipSpace()
+		if buf.B, err = c.value(buf.B); err != nil {
+			return dst, err
+		}
+		offsets = append(offsets, koff, kend, len(buf.B), pos)
+
+		c.skipSpace()
+		if c.src[c.off] == '}' {
+			c.off++
+			break
+		}
+		c.off++ // ','
+		c.skipSpace()
+	}
+	// The slices of the buffer are taken once all
+	// the members are parsed, since the buffer may
+	// be reallocated while it grows.
+	members := make([]member, 0, len(offsets)/4)
+	for i := 0; i < len(offsets); i += 4 {
+		members = append(members, member{
+			key: buf.B[offsets[i]:offsets[i+1]],
+			val: buf.B[offsets[i+1]:offsets[i+2]],
+			pos: offsets[i+3],
+		})
+	}
+	sort.SliceStable(members, func(i, j int) bool {
+		return compareUTF16(members[i].key, members[j].key) < 0
+	})
+	dst = append(dst, '{')
+	for i, m := range members {
+		if i != 0 {
+			// Objects with duplicate names aren't
+			// I-JSON, and have no canonical form.
+			if bytes.Equal(m.key, members[i-1].key) {
+				return dst, c.syntaxError("duplicate key "+strconv.Quote(string(m.key)), m.pos)
+			}
+			dst = append(dst, ',')
+		}
+		dst = append(dst, '"')
+		dst = appendEscapedBytes(dst, m.key, canonicalOpts)
+		dst = append(dst, '"', ':')
+		dst = append(dst, m.val...)
+	}
+	return append(dst, '}'), nil
+}
+
+func (c *canonicalizer) array(dst []byte) ([]byte, error) {
+	c.off++ // '['
+	c.skipSpace()
+	if c.src[c.off] == ']' {
+		c.off++
+		return append(dst, "[]"...), nil
+	}
+	dst = append(dst, '[')
+	for {
+		var err error
+		if dst, err = c.value(dst); err != nil {
+			return dst, err
+		}
+		c.skipSpace()
+		if c.src[c.off] == ']' {
+			c.off++
+			return append(dst, ']'), nil
+		}
+		dst = append(dst, ',')
+		c.off++ // ','
+		c.skipSpace()
+	}
+}
+
+func (c *canonicalizer) number(dst []byte) ([]byte, error) {
+	start := c.off
+	c.off, _ = scanNumber(c.src, c.off)
+	num := c.src[start:c.off]
+
+	f, err := strconv.ParseFloat(string(num), 64)
+	if err != nil || math.IsInf(f, 0) {
+		return dst, c.syntaxError("number "+string(num)+" out of range", start)
+	}
+	return appendCanonicalFloat(dst, f, canonicalOpts)
+}
+
+// string parses the JSON string at the current offset
+// and returns its unescaped content.
+func (c *canonicalizer) string() ([]byte, error) {
+	start := c.off
+	c.off = skipString(c.src, c.off)
+
+	s, ok := unquoteBytes(c.src[start+1:c.off-1], nil)
+	if !ok {
+		return nil, c.syntaxError("invalid string literal", start)
+	}
+	return s, nil
+}
+
+// unquoteBytes appends to dst the content of the JSON
+// string s, without its quotes, with the escape sequences
+// replaced by the characters they represent. It reports
+// whether s is valid, which excludes the lone surrogates
+// that RFC 8785 forbids. If s has no escape sequence, it
+// is returned as is.
+func unquoteBytes(s, dst []byte) ([]byte, bool) {
+	if bytes.IndexByte(s, '\\') == -1 {
+		return s, true
+	}
+	for i := 0; i < len(s); {
+		if s[i] != '\\' {
+			dst = append(dst, s[i])
+			i++
+			continue
+		}
+		if i+1 == len(s) {
+			return dst, false
+		}
+		switch s[i+1] {
+		case '"', '\\', '/':
+			dst = append(dst, s[i+1])
+		case 'b':
+			dst = append(dst, '\b')
+		case 'f':
+			dst = append(dst, '\f')
+		case 'n':
+			dst = append(dst, '\n')
+		case 'r':
+			dst = append(dst, '\r')
+		case 't':
+			dst = append(dst, '\t')
+		case 'u':
+			r, ok := unquoteRune(s[i:])
+			if !ok {
+				return dst, false
+			}
+			if utf16.IsSurrogate(r) {
+				// The rune must be followed
+				// by the low surrogate.
+				r2, ok := unquoteRune(s[i+6:])
+				if !ok {
+					return dst, false
+				}
+				if r = utf16.DecodeRune(r, r2); r == utf8.RuneError {
+					return dst, false
+				}
+				i += 6
+			}
+			dst = utf8.AppendRune(dst, r)
+			i += 6
+			continue
+		default:
+			return dst, false
+		}
+		i += 2
+	}
+	return dst, true
+}
+
+// unquoteRune decodes the \uXXXX escape
+// sequence at the beginning of s.
+func unquoteRune(s []byte) (rune, bool) {
+	if len(s) < 6 || s[0] != '\\' || s[1] != 'u' {
+		return 0, false
+	}
+	var r rune
+	for _, c := range s[2:6] {
+		switch {
+		case c >= '0' && c <= '9':
+			c -= '0'
+		case c >= 'a' && c <= 'f':
+			c = c - 'a' + 10
+		case c >= 'A' && c <= 'F':
+			c = c - 'A' + 10
+		default:
+			return 0, false
+		}
+		r = r*16 + rune(c)
+	}
+	return r, true
+}
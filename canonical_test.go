@@ -0,0 +1,256 @@
+package jettison
+
+import (
+	"encoding/json"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestCompareUTF16(t *testing.T) {
+	// Sorted keys of the example of
+	// RFC 8785, Section 3.2.3.
+	keys := []string{
+		"\r",
+		"1",
+		"\u0080",
+		"\u00f6",
+		"\u20ac",
+		"\U0001f600",
+		"\ufb33",
+	}
+	for i := 0; i < len(keys)-1; i++ {
+		a, b := []byte(keys[i]), []byte(keys[i+1])
+		if c := compareUTF16(a, b); c != -1 {
+			t.Errorf("compare(%q, %q): got %d, want -1", a, b, c)
+		}
+		if c := compareUTF16(b, a); c != 1 {
+			t.Errorf("compare(%q, %q): got %d, want 1", b, a, c)
+		}
+	}
+	for _, tt := range []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"", "a", -1},
+		{"ab", "a", 1},
+		{"abc", "abc", 0},
+		{"\U00010000", "\ue000", -1},
+		{"\U00010000", "\U00010001", -1},
+	} {
+		if c := compareUTF16([]byte(tt.a), []byte(tt.b)); c != tt.want {
+			t.Errorf("compare(%q, %q): got %d, want %d", tt.a, tt.b, c, tt.want)
+		}
+	}
+}
+
+func TestCanonicalJSON(t *testing.T) {
+	for _, tt := range []struct {
+		src  string
+		want string
+	}{
+		// RFC 8785, Section 3.2.2.
+		{
+			`{
+			  "numbers": [333333333.33333329, 1E30, 4.50, 2e-3, 0.000000000000000000000000001],
+			  "string": "\u20ac$\u000F\u000aA'\u0042\u0022\u005c\\\"\/",
+			  "literals": [null, true, false]
+			}`,
+			`{"literals":[null,true,false],"numbers":[333333333.3333333,1e+30,4.5,0.002,1e-27],"string":"€$\u000f\nA'B\"\\\\\"/"}`,
+		},
+		// RFC 8785, Section 3.2.3.
+		{
+			`{
+			  "\u20ac": "Euro Sign",
+			  "\r": "Carriage Return",
+			  "\ufb33": "Hebrew Letter Dalet With Dagesh",
+			  "1": "One",
+			  "\ud83d\ude00": "Emoji: Grinning Face",
+			  "\u0080": "Control",
+			  "\u00f6": "Latin Small Letter O With Diaeresis"
+			}`,
+			`{"\r":"Carriage Return","1":"One","` + "\u0080" + `":"Control","` + "\u00f6" + `":"Latin Small Letter O With Diaeresis","` + "\u20ac" + `":"Euro Sign","` + "\U0001f600" + `":"Emoji: Grinning Face","` + "\ufb33" + `":"Hebrew Letter Dalet With Dagesh"}`,
+		},
+		{`-0`, `0`},
+		{`-0.0e10`, `0`},
+		{`1e21`, `1e+21`},
+		{`1e20`, `100000000000000000000`},
+		{`[ ]`, `[]`},
+		{`{ }`, `{}`},
+		{` "\b\f\t\u2028<>&" `, "\"\\b\\f\\t\u2028<>&\""},
+		{`{"b":{"d":1,"c":2},"a":[{"z":0,"y":-1.5}]}`, `{"a":[{"y":-1.5,"z":0}],"b":{"c":2,"d":1}}`},
+	} {
+		b, err := appendCanonicalJSON(nil, []byte(tt.src))
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		if s := string(b); s != tt.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", tt.src, s, tt.want)
+		}
+	}
+}
+
+func TestCanonicalJSONErrors(t *testing.T) {
+	for _, src := range []string{
+		``,
+		`{`,
+		`[1,]`,
+		`{"a":1,}`,
+		`{"a" 1}`,
+		`01`,
+		`1e400`,
+		`"\ud800"`,
+		`"\x"`,
+		`"abc`,
+		`nul`,
+		`{"a":1,"a":2}`,
+		`{"a":1,"\u0061":2}`,
+		`1 2`,
+	} {
+		_, err := appendCanonicalJSON(nil, []byte(src))
+		if _, ok := err.(*SyntaxError); !ok {
+			t.Errorf("%q: got %T, want SyntaxError", src, err)
+		}
+	}
+}
+
+type canonicalMarshaler struct{}
+
+func (canonicalMarshaler) MarshalJSON() ([]byte, error) {
+	return []byte(`{ "b": 1.0, "a": "\u0041" }`), nil
+}
+
+type canonicalAppender struct{}
+
+func (canonicalAppender) AppendJSON(dst []byte) ([]byte, error) {
+	return append(dst, `[1E2, {"y":true,"x":null}]`...), nil
+}
+
+type canonicalText struct{}
+
+func (canonicalText) MarshalText() ([]byte, error) {
+	return []byte("a\"b\n"), nil
+}
+
+func TestCanonical(t *testing.T) {
+	type inner struct {
+		Z int `json:"z"`
+		A int `json:"a"`
+	}
+	type x struct {
+		B     string              `json:"b"`
+		A     float32             `json:"a"`
+		C     float64             `json:"c,string"`
+		E     inner               `json:"e"`
+		D     map[string]int      `json:"d"`
+		F     json.RawMessage     `json:"f"`
+		G     canonicalMarshaler  `json:"g"`
+		H     canonicalAppender   `json:"h"`
+		I     canonicalText       `json:"i"`
+		J     json.Number         `json:"j"`
+		K     float64             `json:"k"`
+		L     *big.Int            `json:"l"`
+		M     *big.Float          `json:"m"`
+		N     int64               `json:"n,scale=2"`
+		O     float64             `json:"o,precision=1"`
+		P     []interface{}       `json:"p"`
+		Deser string              `json:"\U00010400"`
+		Dalet string              `json:"\ufb33"`
+		Q     map[string]struct{} `json:"q,omitempty"`
+	}
+	v := x{
+		B: "<\u2028>",
+		A: 0.1,
+		C: 4.50,
+		E: inner{Z: 1, A: 2},
+		D: map[string]int{"\ufb33": 1, "\U0001f600": 2, "1": 3},
+		F: json.RawMessage(`{"b":[],"a":-0}`),
+		J: "1.0E3",
+		K: math.Copysign(0, -1),
+		L: big.NewInt(1<<53 - 1),
+		M: big.NewFloat(1e21),
+		N: 12345,
+		O: 1.26,
+		P: []interface{}{1e-7, "\x00", uint8(1)},
+	}
+	b, err := MarshalOpts(v, Canonical())
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `{"a":0.10000000149011612,"b":"<` + "\u2028" + `>","c":"4.5","d":{"1":3,"` + "\U0001f600" + `":2,"` + "\ufb33" + `":1},` +
+		`"e":{"a":2,"z":1},"f":{"a":0,"b":[]},"g":{"a":"A","b":1},"h":[100,{"x":null,"y":true}],"i":"a\"b\n",` +
+		`"j":1000,"k":0,"l":9007199254740991,"m":1e+21,"n":123.45,"o":1.3,"p":[1e-7,"\u0000",1],` +
+		`"` + "\U00010400" + `":"","` + "\ufb33" + `":""}`
+	if s := string(b); s != want {
+		t.Errorf("\ngot  %s\nwant %s", s, want)
+	}
+}
+
+func TestCanonicalUnsupportedValues(t *testing.T) {
+	for _, v := range []interface{}{
+		int64(1 << 53),
+		int64(-1 << 53),
+		uint64(1 << 63),
+		[]int{1, 1 << 60},
+		new(big.Int).Lsh(big.NewInt(1), 53),
+		json.Number("1e400"),
+		math.NaN(),
+	} {
+		_, err := MarshalOpts(v, Canonical())
+		if _, ok := err.(*UnsupportedValueError); !ok {
+			t.Errorf("%v: got %T, want UnsupportedValueError", v, err)
+		}
+	}
+	// The 64-bit integers quoted by the Int64Format
+	// option are encoded regardless of their value.
+	b, err := MarshalOpts([]int64{1, 1 << 53}, Canonical(), Int64Format(Int64StringIfUnsafe))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, want := string(b), `[1,"9007199254740992"]`; s != want {
+		t.Errorf("got %s, want %s", s, want)
+	}
+	// And so are the big.Int values.
+	for _, tt := range []struct {
+		f    Int64Fmt
+		want string
+	}{
+		{Int64StringIfUnsafe, `[1,"9007199254740992"]`},
+		{Int64String, `["1","9007199254740992"]`},
+	} {
+		b, err = MarshalOpts([]*big.Int{big.NewInt(1), new(big.Int).Lsh(big.NewInt(1), 53)}, Canonical(), Int64Format(tt.f))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := string(b); s != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.f, s, tt.want)
+		}
+	}
+	// Duplicate keys in the output of a marshaler.
+	_, err = MarshalOpts(json.RawMessage(`{"a":1,"a":2}`), Canonical())
+	if _, ok := err.(*SyntaxError); !ok {
+		t.Errorf("got %T, want SyntaxError", err)
+	}
+}
+
+func TestCanonicalInvalidOpts(t *testing.T) {
+	for _, opt := range []Option{
+		UnsortedMap(),
+		NoStringEscaping(),
+		NoUTF8Coercion(),
+		NoCompact(),
+		NoNumberValidation(),
+		Indent("", "  "),
+		FloatPrecision(2),
+		NonFiniteFormat(NonFiniteNull),
+		NonFiniteFormat(NonFiniteString),
+		NonFiniteFormat(NonFiniteOmit),
+	} {
+		_, err := MarshalOpts(struct{}{}, Canonical(), opt)
+		if _, ok := err.(*InvalidOptionError); !ok {
+			t.Errorf("got %T, want InvalidOptionError", err)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+// Command jettison reformats JSON documents with the
+// options of the jettison package, so that the output
+// is identical to the one of a Go program that marshals
+// the same values with these options.
+//
+// Usage:
+//
+//	jettison [options] [file ...]
+//
+// Each file, or the standard input if there are none
+// or if a file is "-", must hold a single JSON value,
+// which is written to the standard output followed by
+// a newline. The objects are encoded like maps, whose
+// members are sorted by key unless the -unsorted flag
+// is given. The output is indented if the -indent or
+// -prefix flags are not empty, or if the -pretty flag
+// is given, which places each element on its own line
+// even without indentation.
+//
+// The exit status is 0 if all the inputs are valid,
+// 1 if an input is invalid or exceeds a limit, 2 if
+// the flags are invalid, and 3 if an input can't be
+// read or the output can't be written. The last one
+// has precedence if several errors occur.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/wI2L/jettison"
+)
+
+// The exit codes of the command.
+const (
+	exitOK      = 0
+	exitInvalid = 1
+	exitUsage   = 2
+	exitIO      = 3
+)
+
+const stdinName = "<stdin>"
+
+// listFlag is a flag that can be repeated,
+// whose values are collected in a list.
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, ",") }
+
+func (l *listFlag) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// command holds the flags and the streams
+// of an execution of the command.
+type command struct {
+	stdin  io.Reader
+	stdout *bufio.Writer
+	stderr io.Writer
+	opts   []jettison.Option
+	check  bool
+	buf    []byte
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes the command with the arguments args,
+// and returns its exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("jettison", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: jettison [options] [file ...]\n")
+		fmt.Fprintf(stderr, "options:\n")
+		fs.PrintDefaults()
+	}
+	var (
+		allowPaths listFlag
+		denyPaths  listFlag
+
+		indent    = fs.String("indent", "", "indent the output with `string`")
+		prefix    = fs.String("prefix", "", "start the indented lines with `string`")
+		pretty    = fs.Bool("pretty", false, "indent the output, even if the -indent and -prefix strings are empty")
+		canonical = fs.Bool("canonical", false, "write the canonical form of RFC 8785")
+		unsorted  = fs.Bool("unsorted", false, "keep the members of the objects in the input order")
+		noHTML    = fs.Bool("no-html-escaping", false, "don't escape the HTML characters of the strings")
+		noEscape  = fs.Bool("no-string-escaping", false, "don't escape the strings")
+		noCoerce  = fs.Bool("no-utf8-coercion", false, "don't replace the invalid UTF-8 bytes of the strings")
+		rejectDup = fs.Bool("reject-duplicate-keys", false, "reject the objects with duplicate keys")
+		maxDepth  = fs.Int("max-depth", 0, "maximum nesting depth of the objects and arrays, if not zero")
+		maxBytes  = fs.Int("max-bytes", 0, "maximum size in bytes of the output of an input, if not zero")
+		check     = fs.Bool("check", false, "only validate the inputs, without writing them")
+	)
+	fs.Var(&allowPaths, "allow-path", "encode only the members at `path` (repeatable)")
+	fs.Var(&denyPaths, "deny-path", "omit the members at `path` (repeatable)")
+
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		return exitUsage
+	}
+	opts := []jettison.Option{
+		jettison.MaxDepth(*maxDepth),
+		jettison.MaxBytes(*maxBytes),
+	}
+	if *pretty || *indent != "" || *prefix != "" {
+		opts = append(opts, jettison.Indent(*prefix, *indent))
+	}
+	if *canonical {
+		opts = append(opts, jettison.Canonical())
+	}
+	if *unsorted {
+		opts = append(opts, jettison.UnsortedMap())
+	}
+	if *noHTML {
+		opts = append(opts, jettison.NoHTMLEscaping())
+	}
+	if *noEscape {
+		opts = append(opts, jettison.NoStringEscaping())
+	}
+	if *noCoerce {
+		opts = append(opts, jettison.NoUTF8Coercion())
+	}
+	if *rejectDup {
+		opts = append(opts, jettison.RejectDuplicateKeys())
+	}
+	if allowPaths != nil {
+		opts = append(opts, jettison.AllowPaths(allowPaths))
+	}
+	if denyPaths != nil {
+		opts = append(opts, jettison.DenyPaths(denyPaths))
+	}
+	// The options are validated once,
+	// before any input is read.
+	if _, err := jettison.NewEncoder(opts...); err != nil {
+		fmt.Fprintf(stderr, "jettison: %s\n", err)
+		return exitUsage
+	}
+	cmd := &command{
+		stdin:  stdin,
+		stdout: bufio.NewWriter(stdout),
+		stderr: stderr,
+		opts:   opts,
+		check:  *check,
+	}
+	files := fs.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	code := exitOK
+	for _, name := range files {
+		if c := cmd.process(name); c > code {
+			code = c
+		}
+		if code == exitIO {
+			break
+		}
+	}
+	if err := cmd.stdout.Flush(); err != nil {
+		fmt.Fprintf(stderr, "jettison: %s\n", err)
+		return exitIO
+	}
+	return code
+}
+
+// process reformats the input named name, and
+// returns the exit code that corresponds to the
+// error that occurred, if any.
+func (c *command) process(name string) int {
+	var (
+		src []byte
+		err error
+	)
+	if name == "-" {
+		name = stdinName
+		src, err = io.ReadAll(c.stdin)
+	} else {
+		src, err = os.ReadFile(name)
+	}
+	if err != nil {
+		fmt.Fprintf(c.stderr, "jettison: %s\n", err)
+		return exitIO
+	}
+	b, err := jettison.Reformat(c.buf[:0], src, c.opts...)
+	c.buf = b
+	if err != nil {
+		var serr *jettison.SyntaxError
+		if errors.As(err, &serr) {
+			fmt.Fprintf(c.stderr, "jettison: %s:%d:%d: %s\n", name, serr.Line, serr.Column, serr)
+		} else {
+			fmt.Fprintf(c.stderr, "jettison: %s: %s\n", name, err)
+		}
+		return exitInvalid
+	}
+	if c.check {
+		return exitOK
+	}
+	if _, err := c.stdout.Write(append(b, '\n')); err != nil {
+		fmt.Fprintf(c.stderr, "jettison: %s\n", err)
+		return exitIO
+	}
+	return exitOK
+}
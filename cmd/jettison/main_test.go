@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	const input = `{"b": "<x>", "a": [1, 2.50], "c": {"d": null}}`
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{nil, `{"a":[1,2.50],"b":"\u003cx\u003e","c":{"d":null}}` + "\n"},
+		{[]string{"-"}, `{"a":[1,2.50],"b":"\u003cx\u003e","c":{"d":null}}` + "\n"},
+		{[]string{"-unsorted", "-no-html-escaping"}, `{"b":"<x>","a":[1,2.50],"c":{"d":null}}` + "\n"},
+		{[]string{"-canonical"}, `{"a":[1,2.5],"b":"<x>","c":{"d":null}}` + "\n"},
+		{[]string{"-allow-path", "a", "-allow-path", "c.d"}, `{"a":[1,2.50],"c":{"d":null}}` + "\n"},
+		{[]string{"-deny-path", "c.*", "-no-html-escaping"}, `{"a":[1,2.50],"b":"<x>","c":{}}` + "\n"},
+		{[]string{"-indent", "\t", "-deny-path", "a", "-deny-path", "c"}, "{\n\t\"b\": \"\\u003cx\\u003e\"\n}\n"},
+		{[]string{"-pretty", "-deny-path", "a", "-deny-path", "c"}, "{\n\"b\": \"\\u003cx\\u003e\"\n}\n"},
+		{[]string{"-pretty", "-prefix", ">", "-deny-path", "a", "-deny-path", "c"}, "{\n>\"b\": \"\\u003cx\\u003e\"\n>}\n"},
+		{[]string{"-check"}, ""},
+	} {
+		var stdout, stderr bytes.Buffer
+
+		code := run(tt.args, strings.NewReader(input), &stdout, &stderr)
+		if code != exitOK {
+			t.Errorf("%q: got exit code %d, want %d: %s", tt.args, code, exitOK, stderr.String())
+			continue
+		}
+		if stdout.String() != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.args, stdout.String(), tt.want)
+		}
+	}
+}
+
+func TestRunFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"valid.json":   `[3, {"b":1,"a":2}]`,
+		"invalid.json": "{\"a\":\n1,}",
+		"dup.json":     `{"a":1,"a":2}`,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	for _, tt := range []struct {
+		args   []string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{[]string{path("valid.json"), path("valid.json")}, exitOK, "[3,{\"a\":2,\"b\":1}]\n[3,{\"a\":2,\"b\":1}]\n", ""},
+		{[]string{path("invalid.json"), path("valid.json")}, exitInvalid, "[3,{\"a\":2,\"b\":1}]\n", "invalid.json:2:3: invalid character '}'"},
+		{[]string{"-reject-duplicate-keys", path("dup.json")}, exitInvalid, "", `dup.json:1:8: duplicate key "a"`},
+		{[]string{"-max-depth", "1", path("valid.json")}, exitInvalid, "", "exceeded max depth of 1 at $[1]"},
+		{[]string{path("invalid.json"), path("missing.json")}, exitIO, "", "missing.json"},
+		{[]string{"-canonical", "-indent", "  ", path("valid.json")}, exitUsage, "", "invalid option"},
+		{[]string{"-canonical", "-pretty", path("valid.json")}, exitUsage, "", "invalid option"},
+		{[]string{"-max-bytes", "-1"}, exitUsage, "", "invalid option"},
+		{[]string{"-unknown"}, exitUsage, "", "usage: jettison"},
+	} {
+		var stdout, stderr bytes.Buffer
+
+		code := run(tt.args, strings.NewReader(""), &stdout, &stderr)
+		if code != tt.code {
+			t.Errorf("%q: got exit code %d, want %d: %s", tt.args, code, tt.code, stderr.String())
+		}
+		if stdout.String() != tt.stdout {
+			t.Errorf("%q: got output %q, want %q", tt.args, stdout.String(), tt.stdout)
+		}
+		if !strings.Contains(stderr.String(), tt.stderr) {
+			t.Errorf("%q: got error %q, want it to contain %q", tt.args, stderr.String(), tt.stderr)
+		}
+	}
+}
@@ -0,0 +1,1052 @@
+package jettison
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// A decInstr decodes the JSON value at the current
+// offset of the state into the value pointed by the
+// unsafe.Pointer p, and skips it.
+type decInstr func(d *decodeState, p unsafe.Pointer) error
+
+// cachedDecInstr returns an instruction to decode
+// the JSON values into the Go type t, from the cache
+// of the set or generated on the fly. The instructions
+// depend on the naming policy and the int64 format of
+// the set, but not on the type encoders registered,
+// which is why they are cached by the set rather than
+// by its compiler.
+func (s *instrSet) cachedDecInstr(t reflect.Type) decInstr {
+	if ins, ok := s.decInstrs.Load(t); ok {
+		return ins.(decInstr)
+	}
+	// To deal with recursive types, populate the
+	// cache with an indirect func that waits on the
+	// real instruction, like for the encoding.
+	var (
+		wg  sync.WaitGroup
+		ins decInstr
+	)
+	wg.Add(1)
+	i, loaded := s.decInstrs.LoadOrStore(t,
+		decInstr(func(d *decodeState, p unsafe.Pointer) error {
+			wg.Wait()
+			return ins(d, p)
+		}),
+	)
+	if loaded {
+		return i.(decInstr)
+	}
+	ins = s.newDecInstr(t)
+	wg.Done()
+	s.decInstrs.Store(t, ins)
+
+	return ins
+}
+
+func (s *instrSet) newDecInstr(t reflect.Type) decInstr {
+	if t.Kind() == reflect.Ptr {
+		return newPtrDecInstr(t, s.cachedDecInstr(t.Elem()))
+	}
+	if ins := newGoTypeDecInstr(t); ins != nil {
+		return ins
+	}
+	if ins := newUnmarshalerTypeDecInstr(t); ins != nil {
+		return ins
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return newBoolDecInstr(t)
+	case reflect.String:
+		return newStringDecInstr(t)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return s.newIntDecInstr(t)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return s.newUintDecInstr(t)
+	case reflect.Float32, reflect.Float64:
+		return newFloatDecInstr(t)
+	case reflect.Interface:
+		return s.newInterfaceDecInstr(t)
+	case reflect.Struct:
+		return s.newStructDecInstr(t)
+	case reflect.Map:
+		return s.newMapDecInstr(t)
+	case reflect.Slice:
+		return s.newSliceDecInstr(t)
+	case reflect.Array:
+		return s.newArrayDecInstr(t)
+	}
+	return newUnsupportedTypeDecInstr(t)
+}
+
+// newUnsupportedTypeDecInstr returns an instruction
+// for the types that cannot hold a JSON value, such
+// as channels or functions, which rejects any value
+// but null.
+func newUnsupportedTypeDecInstr(t reflect.Type) decInstr {
+	return func(d *decodeState, _ unsafe.Pointer) error {
+		if d.consumeNull() {
+			return nil
+		}
+		return d.typeError(t)
+	}
+}
+
+func newPtrDecInstr(t reflect.Type, ins decInstr) decInstr {
+	e := t.Elem()
+
+	return func(d *decodeState, p unsafe.Pointer) error {
+		pp := (*unsafe.Pointer)(p)
+		if d.consumeNull() {
+			*pp = nil
+			return nil
+		}
+		if *pp == nil {
+			*pp = reflect.New(e).UnsafePointer()
+		}
+		return ins(d, *pp)
+	}
+}
+
+func newGoTypeDecInstr(t reflect.Type) decInstr {
+	switch t {
+	case syncMapType:
+		return decodeSyncMap
+	case timeTimeType:
+		return decodeTime
+	case timeDurationType:
+		return decodeDuration
+	case jsonNumberType:
+		return decodeNumber
+	case jsonRawMessageType:
+		return decodeRawMessage
+	case bigIntType:
+		return decodeBigInt
+	case bigFloatType:
+		return decodeBigFloat
+	case bigRatType:
+		return decodeBigRat
+	default:
+		return nil
+	}
+}
+
+// newUnmarshalerTypeDecInstr returns an instruction
+// to handle a type whose pointer implements one of the
+// json.Unmarshaler or encoding.TextUnmarshaler interfaces,
+// or nil otherwise.
+func newUnmarshalerTypeDecInstr(t reflect.Type) decInstr {
+	if t.Kind() == reflect.Interface {
+		return nil
+	}
+	ptrTo := reflect.PtrTo(t)
+
+	switch {
+	case ptrTo.Implements(jsonUnmarshalerType):
+		return func(d *decodeState, p unsafe.Pointer) error {
+			u := packEface(p, ptrTo, false).(json.Unmarshaler)
+			return u.UnmarshalJSON(d.value())
+		}
+	case ptrTo.Implements(textUnmarshalerType):
+		return func(d *decodeState, p unsafe.Pointer) error {
+			switch d.next() {
+			case 'n':
+				d.off += len("null")
+				return nil
+			case '"':
+				u := packEface(p, ptrTo, false).(encoding.TextUnmarshaler)
+				return u.UnmarshalText(d.string())
+			}
+			return d.typeError(t)
+		}
+	}
+	return nil
+}
+
+func newBoolDecInstr(t reflect.Type) decInstr {
+	return func(d *decodeState, p unsafe.Pointer) error {
+		switch d.next() {
+		case 't':
+			*(*bool)(p) = true
+			d.off += len("true")
+		case 'f':
+			*(*bool)(p) = false
+			d.off += len("false")
+		case 'n':
+			d.off += len("null")
+		default:
+			return d.typeError(t)
+		}
+		return nil
+	}
+}
+
+func newStringDecInstr(t reflect.Type) decInstr {
+	return func(d *decodeState, p unsafe.Pointer) error {
+		switch d.next() {
+		case '"':
+			*(*string)(p) = d.internString(d.string())
+		case 'n':
+			d.off += len("null")
+		default:
+			return d.typeError(t)
+		}
+		return nil
+	}
+}
+
+// isNumberStart returns whether c is
+// the first byte of a number literal.
+func isNumberStart(c byte) bool {
+	return c == '-' || c >= '0' && c <= '9'
+}
+
+// int64Quotable returns whether the integers of type t
+// may be quoted, which is the case of the 64-bit ones
+// encoded with the Int64Format option.
+func (s *instrSet) int64Quotable(t reflect.Type) bool {
+	return s.int64Fmt != Int64Number && t.Size() == 8
+}
+
+// integer returns the number at the current offset of
+// the state, or the content of the string if quotable
+// is true, and whether the value is one of them.
+func (d *decodeState) integer(quotable bool) ([]byte, bool) {
+	switch c := d.next(); {
+	case isNumberStart(c):
+		return d.number(), true
+	case c == '"' && quotable:
+		return d.string(), true
+	}
+	return nil, false
+}
+
+func (s *instrSet) newIntDecInstr(t reflect.Type) decInstr {
+	var store func(unsafe.Pointer, int64)
+
+	switch t.Kind() {
+	case reflect.Int:
+		store = func(p unsafe.Pointer, i int64) { *(*int)(p) = int(i) }
+	case reflect.Int8:
+		store = func(p unsafe.Pointer, i int64) { *(*int8)(p) = int8(i) }
+	case reflect.Int16:
+		store = func(p unsafe.Pointer, i int64) { *(*int16)(p) = int16(i) }
+	case reflect.Int32:
+		store = func(p unsafe.Pointer, i int64) { *(*int32)(p) = int32(i) }
+	default:
+		store = func(p unsafe.Pointer, i int64) { *(*int64)(p) = i }
+	}
+	bits := t.Bits()
+	quotable := s.int64Quotable(t)
+
+	return func(d *decodeState, p unsafe.Pointer) error {
+		if d.consumeNull() {
+			return nil
+		}
+		num, ok := d.integer(quotable)
+		if !ok {
+			return d.typeError(t)
+		}
+		i, err := strconv.ParseInt(b2s(num), 10, bits)
+		if err != nil {
+			return d.numberError(num, t)
+		}
+		store(p, i)
+		return nil
+	}
+}
+
+func (s *instrSet) newUintDecInstr(t reflect.Type) decInstr {
+	var store func(unsafe.Pointer, uint64)
+
+	switch t.Kind() {
+	case reflect.Uint:
+		store = func(p unsafe.Pointer, u uint64) { *(*uint)(p) = uint(u) }
+	case reflect.Uint8:
+		store = func(p unsafe.Pointer, u uint64) { *(*uint8)(p) = uint8(u) }
+	case reflect.Uint16:
+		store = func(p unsafe.Pointer, u uint64) { *(*uint16)(p) = uint16(u) }
+	case reflect.Uint32:
+		store = func(p unsafe.Pointer, u uint64) { *(*uint32)(p) = uint32(u) }
+	case reflect.Uintptr:
+		store = func(p unsafe.Pointer, u uint64) { *(*uintptr)(p) = uintptr(u) }
+	default:
+		store = func(p unsafe.Pointer, u uint64) { *(*uint64)(p) = u }
+	}
+	bits := t.Bits()
+	quotable := s.int64Quotable(t)
+
+	return func(d *decodeState, p unsafe.Pointer) error {
+		if d.consumeNull() {
+			return nil
+		}
+		num, ok := d.integer(quotable)
+		if !ok {
+			return d.typeError(t)
+		}
+		u, err := strconv.ParseUint(b2s(num), 10, bits)
+		if err != nil {
+			return d.numberError(num, t)
+		}
+		store(p, u)
+		return nil
+	}
+}
+
+func newFloatDecInstr(t reflect.Type) decInstr {
+	bits := t.Bits()
+
+	return func(d *decodeState, p unsafe.Pointer) error {
+		if d.consumeNull() {
+			return nil
+		}
+		if !isNumberStart(d.next()) {
+			return d.typeError(t)
+		}
+		num := d.number()
+		f, err := strconv.ParseFloat(b2s(num), bits)
+		if err != nil {
+			return d.numberError(num, t)
+		}
+		if bits == 32 {
+			*(*float32)(p) = float32(f)
+		} else {
+			*(*float64)(p) = f
+		}
+		return nil
+	}
+}
+
+// newScaledIntDecInstr returns an instruction that
+// decodes the numbers encoded by the scale option of
+// an integer field of type t, which are divided by
+// ten to the power of scale.
+func (s *instrSet) newScaledIntDecInstr(t reflect.Type, scale int) decInstr {
+	ins := s.cachedDecInstr(t)
+	pow := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)
+
+	return func(d *decodeState, p unsafe.Pointer) error {
+		if d.consumeNull() {
+			return nil
+		}
+		if !isNumberStart(d.next()) {
+			return d.typeError(t)
+		}
+		num := d.number()
+
+		r, ok := new(big.Rat).SetString(b2s(num))
+		if ok {
+			r.Mul(r, new(big.Rat).SetInt(pow))
+		}
+		if !ok || !r.IsInt() {
+			return d.numberError(num, t)
+		}
+		// Decode the unscaled integer with the
+		// instruction of the type, which checks
+		// that it fits.
+		s := decodeState{data: r.Num().Append(nil, 10)}
+		if err := ins(&s, p); err != nil {
+			return d.numberError(num, t)
+		}
+		return nil
+	}
+}
+
+// newQuotedDecInstr returns an instruction that decodes
+// the values encoded with the string tag option into the
+// type t, whose JSON representation is quoted.
+func newQuotedDecInstr(t reflect.Type, ins decInstr) decInstr {
+	return func(d *decodeState, p unsafe.Pointer) error {
+		switch d.next() {
+		case 'n':
+			d.off += len("null")
+			return nil
+		case '"':
+		default:
+			return d.typeError(t)
+		}
+		s := d.string()
+
+		// The content of the string must be a single
+		// JSON value, decoded by a state of its own.
+		qs := decodeState{data: s, opts: d.opts, strs: d.strs, useNumber: d.useNumber}
+		qs.skipSpace()
+
+		if checkValid(s) != nil || t.Kind() == reflect.String && qs.next() != '"' && qs.next() != 'n' {
+			return fmt.Errorf("json: invalid use of ,string struct tag, trying to unmarshal %q into %v", s, t)
+		}
+
+		if err := ins(&qs, p); err != nil {
+			if e, ok := err.(*UnmarshalTypeError); ok {
+				e.Offset = int64(d.off)
+			}
+			return err
+		}
+		return nil
+	}
+}
+
+func (s *instrSet) newInterfaceDecInstr(t reflect.Type) decInstr {
+	if t.NumMethod() != 0 {
+		// The dynamic type of the values of a
+		// non-empty interface cannot be chosen,
+		// but a pointer it holds can be decoded.
+		return func(d *decodeState, p unsafe.Pointer) error {
+			v := reflect.NewAt(t, p).Elem()
+			if d.consumeNull() {
+				v.Set(reflect.Zero(t))
+				return nil
+			}
+			if e := v.Elem(); e.Kind() == reflect.Ptr && !e.IsNil() {
+				return s.cachedDecInstr(e.Type().Elem())(d, e.UnsafePointer())
+			}
+			return d.typeError(t)
+		}
+	}
+	return func(d *decodeState, p unsafe.Pointer) error {
+		i := (*interface{})(p)
+
+		if *i != nil && d.next() != 'n' {
+			if v := reflect.ValueOf(*i); v.Kind() == reflect.Ptr && !v.IsNil() {
+				return s.cachedDecInstr(v.Type().Elem())(d, v.UnsafePointer())
+			}
+		}
+		v, err := d.interfaceValue()
+		if err != nil {
+			return err
+		}
+		*i = v
+		return nil
+	}
+}
+
+// A decField represents a field of a struct
+// to decode, and how to reach it.
+type decField struct {
+	name  []byte
+	instr decInstr
+
+	// seq is the sequence of offsets and indirections
+	// to follow to reach the field from the struct.
+	seq []decSeq
+}
+
+// A decSeq is an element of the sequence to follow
+// to reach a field. The nil pointers to embedded
+// structs are allocated with the type elem, unless
+// the struct is unexported, in which case err is
+// returned, like the encoding/json package does.
+type decSeq struct {
+	seq
+	elem reflect.Type
+	err  error
+}
+
+// pointer returns a pointer to the field of
+// the struct pointed by p.
+func (f *decField) pointer(p unsafe.Pointer) (unsafe.Pointer, error) {
+	for _, s := range f.seq {
+		p = unsafe.Pointer(uintptr(p) + s.offset)
+		if s.indir {
+			pp := (*unsafe.Pointer)(p)
+			if *pp == nil {
+				if s.err != nil {
+					return nil, s.err
+				}
+				*pp = reflect.New(s.elem).UnsafePointer()
+			}
+			p = *pp
+		}
+	}
+	return p, nil
+}
+
+// decode decodes the value at the current offset
+// of the state into the field of the struct
+// pointed by p.
+func (f *decField) decode(d *decodeState, p unsafe.Pointer) error {
+	fp, err := f.pointer(p)
+	if err != nil {
+		d.skip()
+		return err
+	}
+	return f.instr(d, fp)
+}
+
+// A decStruct holds the fields of a struct to
+// decode, indexed by name, and its inline map.
+type decStruct struct {
+	fields []decField
+	byName map[string]int
+	inline *decField
+}
+
+// field returns the field of the struct for the
+// given key, matched exactly, or case-insensitively
+// like the encoding/json package, or nil if none.
+func (s *decStruct) field(key []byte) *decField {
+	if i, ok := s.byName[string(key)]; ok {
+		return &s.fields[i]
+	}
+	for i := range s.fields {
+		if bytes.EqualFold(s.fields[i].name, key) {
+			return &s.fields[i]
+		}
+	}
+	return nil
+}
+
+func (s *instrSet) newStructDecInstr(t reflect.Type) decInstr {
+	flds := s.cachedFields(t)
+
+	if err := fieldsTagError(flds); err != nil {
+		return func(_ *decodeState, _ unsafe.Pointer) error {
+			return err
+		}
+	}
+
+	st := &decStruct{
+		fields: make([]decField, 0, len(flds)),
+		byName: make(map[string]int, len(flds)),
+	}
+	for i := range flds {
+		f := &flds[i]
+		ftyp := typeByIndex(t, f.index)
+
+		df := decField{
+			name: []byte(f.name),
+			seq:  make([]decSeq, len(f.embedSeq)),
+		}
+		for k, es := range f.embedSeq {
+			df.seq[k].seq = es
+			if es.indir {
+				sf := t.FieldByIndex(f.index[:k+1])
+				df.seq[k].elem = sf.Type.Elem()
+				if sf.PkgPath != "" {
+					df.seq[k].err = fmt.Errorf("json: cannot set embedded pointer to unexported struct: %v", sf.Type.Elem())
+				}
+			}
+		}
+		if f.inline {
+			df.instr = s.newInlineMapDecInstr(ftyp)
+			st.inline = &df
+			continue
+		}
+		df.instr = s.newFieldDecInstr(ftyp, f)
+
+		st.byName[f.name] = len(st.fields)
+		st.fields = append(st.fields, df)
+	}
+	return func(d *decodeState, p unsafe.Pointer) error {
+		switch d.next() {
+		case 'n':
+			d.off += len("null")
+			return nil
+		case '{':
+		default:
+			return d.typeError(t)
+		}
+		var terr error
+
+		for more := d.enter(); more; more = d.more() {
+			key := d.objectKey()
+
+			var err error
+			if f := st.field(key); f != nil {
+				err = f.decode(d, p)
+			} else if st.inline != nil {
+				// The key is passed to the instruction
+				// of the inline map through the state.
+				d.inlineKey = key
+				err = st.inline.decode(d, p)
+			} else if d.disallowUnknownFields {
+				return fmt.Errorf("json: unknown field %q", key)
+			} else {
+				d.skip()
+			}
+			if err != nil {
+				if err = keepTypeError(withKeyPath(err, string(key)), &terr); err != nil {
+					return err
+				}
+			}
+		}
+		return terr
+	}
+}
+
+// newFieldDecInstr returns an instruction to decode
+// the struct field f of type t, with the string and
+// scale options of its tag.
+func (s *instrSet) newFieldDecInstr(t reflect.Type, f *field) decInstr {
+	etyp := t
+	if etyp.Kind() == reflect.Ptr {
+		etyp = etyp.Elem()
+	}
+	quoted := f.quoted && isBasicType(etyp)
+	scaled := f.scale > 0 && isInteger(etyp) &&
+		newGoTypeDecInstr(etyp) == nil && newUnmarshalerTypeDecInstr(etyp) == nil
+
+	if !quoted && !scaled {
+		return s.cachedDecInstr(t)
+	}
+	var ins decInstr
+	if scaled {
+		ins = s.newScaledIntDecInstr(etyp, f.scale)
+	} else {
+		ins = s.cachedDecInstr(etyp)
+	}
+	if quoted {
+		ins = newQuotedDecInstr(etyp, ins)
+	}
+	if t != etyp {
+		ins = newPtrDecInstr(t, ins)
+	}
+	return ins
+}
+
+// newInlineMapDecInstr returns an instruction that
+// stores the value of a member that matches none of
+// the fields of a struct in its inline map of type t,
+// with the key held by the state.
+func (s *instrSet) newInlineMapDecInstr(t reflect.Type) decInstr {
+	if t == syncMapType {
+		return func(d *decodeState, p unsafe.Pointer) error {
+			k := string(d.inlineKey)
+			v, err := d.interfaceValue()
+			if err != nil {
+				return err
+			}
+			(*sync.Map)(p).Store(k, v)
+			return nil
+		}
+	}
+	var (
+		kt = t.Key()
+		et = t.Elem()
+		vi = s.cachedDecInstr(et)
+	)
+	return func(d *decodeState, p unsafe.Pointer) error {
+		m := reflect.NewAt(t, p).Elem()
+		if m.IsNil() {
+			m.Set(reflect.MakeMap(t))
+		}
+		k := reflect.ValueOf(string(d.inlineKey)).Convert(kt)
+		v := reflect.New(et)
+		if err := vi(d, v.UnsafePointer()); err != nil {
+			return err
+		}
+		m.SetMapIndex(k, v.Elem())
+		return nil
+	}
+}
+
+// newMapKeyFunc returns a function that converts the
+// keys of the members of an object to values of the
+// type t, or nil if the type isn't supported.
+func newMapKeyFunc(t reflect.Type) func(d *decodeState, key []byte) (reflect.Value, error) {
+	switch {
+	case reflect.PtrTo(t).Implements(textUnmarshalerType):
+		return func(_ *decodeState, key []byte) (reflect.Value, error) {
+			v := reflect.New(t)
+			err := v.Interface().(encoding.TextUnmarshaler).UnmarshalText(key)
+			return v.Elem(), err
+		}
+	case t.Kind() == reflect.String:
+		return func(d *decodeState, key []byte) (reflect.Value, error) {
+			return reflect.ValueOf(d.internString(key)).Convert(t), nil
+		}
+	case isInteger(t):
+		return func(d *decodeState, key []byte) (reflect.Value, error) {
+			v := reflect.New(t).Elem()
+
+			switch t.Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				i, err := strconv.ParseInt(b2s(key), 10, t.Bits())
+				if err != nil {
+					return v, d.numberError(key, t)
+				}
+				v.SetInt(i)
+			default:
+				u, err := strconv.ParseUint(b2s(key), 10, t.Bits())
+				if err != nil {
+					return v, d.numberError(key, t)
+				}
+				v.SetUint(u)
+			}
+			return v, nil
+		}
+	}
+	return nil
+}
+
+func (s *instrSet) newMapDecInstr(t reflect.Type) decInstr {
+	key := newMapKeyFunc(t.Key())
+	if key == nil {
+		return newUnsupportedTypeDecInstr(t)
+	}
+	var (
+		et   = t.Elem()
+		vi   = s.cachedDecInstr(et)
+		zero = reflect.Zero(et)
+	)
+	return func(d *decodeState, p unsafe.Pointer) error {
+		switch d.next() {
+		case 'n':
+			d.off += len("null")
+			*(*unsafe.Pointer)(p) = nil
+			return nil
+		case '{':
+		default:
+			return d.typeError(t)
+		}
+		m := reflect.NewAt(t, p).Elem()
+		if m.IsNil() {
+			m.Set(reflect.MakeMap(t))
+		}
+		var (
+			terr error
+			v    = reflect.New(et) // reused, the map copies the values
+		)
+		for more := d.enter(); more; more = d.more() {
+			k := d.objectKey()
+
+			kv, err := key(d, k)
+			if err != nil {
+				d.skip()
+			} else {
+				v.Elem().Set(zero)
+				err = vi(d, v.UnsafePointer())
+
+				// The entries whose value has the wrong
+				// type are kept, like the encoding/json
+				// package does.
+				m.SetMapIndex(kv, v.Elem())
+			}
+			if err != nil {
+				if err = keepTypeError(withKeyPath(err, string(k)), &terr); err != nil {
+					return err
+				}
+			}
+		}
+		return terr
+	}
+}
+
+func (s *instrSet) newSliceDecInstr(t reflect.Type) decInstr {
+	var (
+		et   = t.Elem()
+		es   = et.Size()
+		vi   = s.cachedDecInstr(et)
+		zero = reflect.Zero(et)
+	)
+	ins := func(d *decodeState, p unsafe.Pointer) error {
+		switch d.next() {
+		case 'n':
+			d.off += len("null")
+			*(*sliceHeader)(p) = sliceHeader{}
+			return nil
+		case '[':
+		default:
+			return d.typeError(t)
+		}
+		var (
+			terr error
+			s    = reflect.NewAt(t, p).Elem()
+			i    = 0
+		)
+		for more := d.enter(); more; more = d.more() {
+			if i == s.Cap() {
+				ns := reflect.MakeSlice(t, i+1, 2*i+4)
+				reflect.Copy(ns, s)
+				s.Set(ns)
+			} else if i >= s.Len() {
+				s.SetLen(i + 1)
+				s.Index(i).Set(zero)
+			}
+			ep := unsafe.Pointer(uintptr((*sliceHeader)(p).Data) + uintptr(i)*es)
+
+			if err := vi(d, ep); err != nil {
+				if err = keepTypeError(withIndexPath(err, i), &terr); err != nil {
+					return err
+				}
+			}
+			i++
+		}
+		if i < s.Len() {
+			s.SetLen(i)
+		}
+		if s.IsNil() {
+			// An empty array is decoded as
+			// an empty slice, not a nil one.
+			s.Set(reflect.MakeSlice(t, 0, 0))
+		}
+		return terr
+	}
+	if et.Kind() == reflect.Uint8 && newUnmarshalerTypeDecInstr(et) == nil {
+		// Byte slices are decoded from the base64
+		// encoding of their content, but also from
+		// arrays of numbers.
+		return func(d *decodeState, p unsafe.Pointer) error {
+			if d.next() != '"' {
+				return ins(d, p)
+			}
+			s := d.string()
+			b := make([]byte, base64.StdEncoding.DecodedLen(len(s)))
+
+			n, err := base64.StdEncoding.Decode(b, s)
+			if err != nil {
+				return err
+			}
+			*(*[]byte)(p) = b[:n]
+
+			return nil
+		}
+	}
+	return ins
+}
+
+func (s *instrSet) newArrayDecInstr(t reflect.Type) decInstr {
+	var (
+		et   = t.Elem()
+		es   = et.Size()
+		n    = t.Len()
+		vi   = s.cachedDecInstr(et)
+		zero = reflect.Zero(et)
+	)
+	return func(d *decodeState, p unsafe.Pointer) error {
+		switch d.next() {
+		case 'n':
+			d.off += len("null")
+			return nil
+		case '[':
+		default:
+			return d.typeError(t)
+		}
+		var (
+			terr error
+			i    = 0
+		)
+		for more := d.enter(); more; more = d.more() {
+			if i >= n {
+				// The extra elements are ignored.
+				d.skip()
+				continue
+			}
+			ep := unsafe.Pointer(uintptr(p) + uintptr(i)*es)
+
+			if err := vi(d, ep); err != nil {
+				if err = keepTypeError(withIndexPath(err, i), &terr); err != nil {
+					return err
+				}
+			}
+			i++
+		}
+		if i < n {
+			// Zero the elements left.
+			a := reflect.NewAt(t, p).Elem()
+			for ; i < n; i++ {
+				a.Index(i).Set(zero)
+			}
+		}
+		return terr
+	}
+}
+
+func decodeSyncMap(d *decodeState, p unsafe.Pointer) error {
+	switch d.next() {
+	case 'n':
+		d.off += len("null")
+		return nil
+	case '{':
+	default:
+		return d.typeError(syncMapType)
+	}
+	var (
+		terr error
+		m    = (*sync.Map)(p)
+	)
+	for more := d.enter(); more; more = d.more() {
+		k := d.objectKey()
+
+		v, err := d.interfaceValue()
+		if err != nil {
+			if err = keepTypeError(withKeyPath(err, string(k)), &terr); err != nil {
+				return err
+			}
+			continue
+		}
+		m.Store(string(k), v)
+	}
+	return terr
+}
+
+func decodeTime(d *decodeState, p unsafe.Pointer) error {
+	switch c := d.next(); {
+	case c == 'n':
+		d.off += len("null")
+	case c == '"':
+		t, err := time.Parse(d.opts.timeLayout, string(d.string()))
+		if err != nil {
+			return err
+		}
+		*(*time.Time)(p) = t
+	case isNumberStart(c):
+		num := d.number()
+		sec, err := strconv.ParseInt(b2s(num), 10, 64)
+		if err != nil {
+			return d.numberError(num, timeTimeType)
+		}
+		*(*time.Time)(p) = time.Unix(sec, 0).UTC()
+	default:
+		return d.typeError(timeTimeType)
+	}
+	return nil
+}
+
+func decodeDuration(d *decodeState, p unsafe.Pointer) error {
+	switch c := d.next(); {
+	case c == 'n':
+		d.off += len("null")
+	case c == '"':
+		dur, err := time.ParseDuration(b2s(d.string()))
+		if err != nil {
+			return err
+		}
+		*(*time.Duration)(p) = dur
+	case isNumberStart(c):
+		num := d.number()
+		dur, err := parseDuration(b2s(num), d.opts.durationFmt)
+		if err != nil {
+			return d.numberError(num, timeDurationType)
+		}
+		*(*time.Duration)(p) = dur
+	default:
+		return d.typeError(timeDurationType)
+	}
+	return nil
+}
+
+// parseDuration parses the number s as a duration in the
+// unit of the format f. The minutes and the seconds may
+// have a fractional part, which is rounded to the nearest
+// nanosecond.
+func parseDuration(s string, f DurationFmt) (time.Duration, error) {
+	var unit time.Duration
+
+	switch f {
+	case DurationMinutes:
+		unit = time.Minute
+	case DurationSeconds:
+		unit = time.Second
+	case DurationMilliseconds:
+		unit = time.Millisecond
+	case DurationMicroseconds:
+		unit = time.Microsecond
+	default: // DurationNanoseconds, DurationString
+		unit = time.Nanosecond
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if i > math.MaxInt64/int64(unit) || i < math.MinInt64/int64(unit) {
+			return 0, strconv.ErrRange
+		}
+		return time.Duration(i) * unit, nil
+	}
+	if unit < time.Second {
+		return 0, strconv.ErrSyntax
+	}
+	fl, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	fl = math.Round(fl * float64(unit))
+	if fl >= math.MaxInt64 || fl < math.MinInt64 {
+		return 0, strconv.ErrRange
+	}
+	return time.Duration(fl), nil
+}
+
+func decodeNumber(d *decodeState, p unsafe.Pointer) error {
+	switch c := d.next(); {
+	case c == 'n':
+		d.off += len("null")
+	case c == '"':
+		s := d.string()
+		if !isValidNumber(b2s(s)) {
+			return fmt.Errorf("json: invalid number literal, trying to unmarshal %q into Number", s)
+		}
+		*(*json.Number)(p) = json.Number(s)
+	case isNumberStart(c):
+		*(*json.Number)(p) = json.Number(d.number())
+	default:
+		return d.typeError(jsonNumberType)
+	}
+	return nil
+}
+
+func decodeRawMessage(d *decodeState, p unsafe.Pointer) error {
+	m := (*json.RawMessage)(p)
+	*m = append((*m)[:0], d.value()...)
+
+	return nil
+}
+
+// bigNumber returns the number at the current offset,
+// or the content of the string, to decode into a big
+// number of type t. The returned slice is nil if the
+// value is null.
+func (d *decodeState) bigNumber(t reflect.Type) ([]byte, error) {
+	switch c := d.next(); {
+	case c == 'n':
+		d.off += len("null")
+		return nil, nil
+	case c == '"':
+		return d.string(), nil
+	case isNumberStart(c):
+		return d.number(), nil
+	}
+	return nil, d.typeError(t)
+}
+
+func decodeBigInt(d *decodeState, p unsafe.Pointer) error {
+	num, err := d.bigNumber(bigIntType)
+	if num == nil {
+		return err
+	}
+	if _, ok := (*big.Int)(p).SetString(b2s(num), 10); !ok {
+		return d.numberError(num, bigIntType)
+	}
+	return nil
+}
+
+func decodeBigFloat(d *decodeState, p unsafe.Pointer) error {
+	num, err := d.bigNumber(bigFloatType)
+	if num == nil {
+		return err
+	}
+	if _, ok := (*big.Float)(p).SetString(b2s(num)); !ok {
+		return d.numberError(num, bigFloatType)
+	}
+	return nil
+}
+
+func decodeBigRat(d *decodeState, p unsafe.Pointer) error {
+	num, err := d.bigNumber(bigRatType)
+	if num == nil {
+		return err
+	}
+	if _, ok := (*big.Rat)(p).SetString(b2s(num)); !ok {
+		return d.numberError(num, bigRatType)
+	}
+	return nil
+}
@@ -0,0 +1,755 @@
+package jettison
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math/bits"
+	"reflect"
+	"strconv"
+	"sync"
+	"unicode/utf16"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// maxNestingDepth is the maximum nesting depth of
+// the values of the JSON documents to decode, the
+// same as the one of the encoding/json package.
+const maxNestingDepth = 10000
+
+// An InvalidUnmarshalError describes an invalid
+// argument passed to Unmarshal or Decode, which
+// must be a non-nil pointer.
+type InvalidUnmarshalError struct {
+	Type reflect.Type
+}
+
+// Error implements the builtin error interface.
+func (e *InvalidUnmarshalError) Error() string {
+	if e.Type == nil {
+		return "json: Unmarshal(nil)"
+	}
+	if e.Type.Kind() != reflect.Ptr {
+		return "json: Unmarshal(non-pointer " + e.Type.String() + ")"
+	}
+	return "json: Unmarshal(nil " + e.Type.String() + ")"
+}
+
+// An UnmarshalTypeError describes a JSON value that
+// is not appropriate for the Go type of the value
+// it is decoded into, at the location returned by
+// its Path method.
+type UnmarshalTypeError struct {
+	Value  string       // description of the JSON value, such as "number -5"
+	Type   reflect.Type // type of the Go value it could not be assigned to
+	Offset int64        // error occurred after reading Offset bytes
+
+	errorPath
+}
+
+// Error implements the builtin error interface.
+// The path of the value is included in the message
+// if it isn't the root value.
+func (e *UnmarshalTypeError) Error() string {
+	s := "json: cannot unmarshal " + e.Value + " into Go value of type " + e.Type.String()
+	if !e.atRoot() {
+		s += " at " + e.Path()
+	}
+	return s
+}
+
+// Unmarshal parses the JSON-encoded data and stores
+// the result in the value pointed to by v, which must
+// be a non-nil pointer, with the same rules as the
+// encoding/json package.
+//
+// The fields of the structs are resolved like during
+// the encoding, with the same tag options, dominance
+// rules and inline fields. The entries of an object
+// that match no field are stored in the inline map of
+// the struct, if it has one, and ignored otherwise.
+//
+// The Go types that the encoder handles specifically
+// are decoded symmetrically: the big numbers from a
+// JSON number or string, a time.Time from a string in
+// the RFC 3339 format or from a number of seconds since
+// the Unix epoch, a time.Duration from a string or from
+// a number of nanoseconds, and a sync.Map from an object.
+//
+// The data is validated before the decoding starts,
+// and v isn't modified if it is not valid JSON. If a
+// JSON value is not appropriate for the Go type of the
+// value it is decoded into, it is skipped, the decoding
+// continues, and the first UnmarshalTypeError is returned.
+func Unmarshal(data []byte, v interface{}) error {
+	return unmarshal(data, v, defaultEncOpts())
+}
+
+// UnmarshalOpts is similar to Unmarshal, but decodes
+// the data produced by MarshalOpts with the same options.
+// The options that apply are:
+//   - FieldNaming, to match the keys with the names of
+//     the struct fields derived by the policy;
+//   - TimeLayout, to parse the time.Time strings;
+//   - DurationFormat, to convert the numbers to durations
+//     with the unit of the format;
+//   - Int64Format, to decode the 64-bit integers from
+//     strings too.
+//
+// The other options are ignored, and UnixTime too, since
+// the numbers are always decoded as Unix timestamps. An
+// InvalidOptionError is returned if the options are invalid.
+func UnmarshalOpts(data []byte, v interface{}, opts ...Option) error {
+	eo, err := decodeOpts(opts)
+	if err != nil {
+		return err
+	}
+	return unmarshal(data, v, eo)
+}
+
+func unmarshal(data []byte, v interface{}, opts encOpts) error {
+	if err := checkValid(data); err != nil {
+		return err
+	}
+	d := newDecodeState(data, opts)
+	err := d.unmarshal(v)
+	d.release()
+
+	return err
+}
+
+// decodeOpts returns the options of a decoding, or an
+// InvalidOptionError if they are invalid. The instruction
+// set matches only the settings that affect the decoding,
+// which spares the generation of identical instructions
+// for the options that only affect the encoding.
+func decodeOpts(opts []Option) (encOpts, error) {
+	eo := defaultEncOpts()
+	if len(opts) == 0 {
+		return eo, nil
+	}
+	eo.apply(opts...)
+	if err := eo.validate(); err != nil {
+		return eo, &InvalidOptionError{err}
+	}
+	key := instrSetKey{
+		naming:    eo.naming,
+		floatPrec: -1,
+		int64Fmt:  eo.int64Fmt,
+	}
+	if key != eo.iset.instrSetKey {
+		eo.config().iset = loadInstrSet(key)
+	}
+	return eo, nil
+}
+
+// decodeState represents the state of the decoding
+// of a valid JSON document.
+type decodeState struct {
+	data []byte
+	off  int
+	buf  []byte // scratch buffer for unquoted strings
+
+	// inlineKey is the key of the member to store
+	// in the inline map of the struct being decoded.
+	inlineKey []byte
+
+	// opts are the options of the decoding, whose
+	// instruction set generates the instructions.
+	opts encOpts
+
+	// strs caches the strings decoded, if not nil.
+	strs *stringCache
+
+	useNumber             bool
+	disallowUnknownFields bool
+}
+
+// decodeStatePool reuses the states of the decodings,
+// along with their scratch buffer and string cache.
+var decodeStatePool = sync.Pool{
+	New: func() interface{} {
+		return &decodeState{strs: new(stringCache)}
+	},
+}
+
+// newDecodeState returns a state from the pool to
+// decode data with opts. It must be released after
+// the decoding.
+func newDecodeState(data []byte, opts encOpts) *decodeState {
+	d := decodeStatePool.Get().(*decodeState)
+	d.data = data
+	d.opts = opts
+
+	return d
+}
+
+// release resets the state, which must not be used
+// after, and puts it back into the pool.
+func (d *decodeState) release() {
+	*d = decodeState{buf: d.buf[:0], strs: d.strs}
+	decodeStatePool.Put(d)
+}
+
+func (d *decodeState) unmarshal(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return &InvalidUnmarshalError{Type: reflect.TypeOf(v)}
+	}
+	d.skipSpace()
+
+	ins := d.opts.iset.cachedDecInstr(rv.Type().Elem())
+
+	return ins(d, rv.UnsafePointer())
+}
+
+func (d *decodeState) skipSpace() {
+	for d.off < len(d.data) {
+		switch d.data[d.off] {
+		case ' ', '\t', '\n', '\r':
+			d.off++
+		default:
+			return
+		}
+	}
+}
+
+// next returns the first byte of the value
+// at the current offset.
+func (d *decodeState) next() byte {
+	return d.data[d.off]
+}
+
+// consumeNull reports whether the value at the
+// current offset is null, which is skipped.
+func (d *decodeState) consumeNull() bool {
+	if d.data[d.off] == 'n' {
+		d.off += len("null")
+		return true
+	}
+	return false
+}
+
+// skip skips the value at the current offset.
+func (d *decodeState) skip() {
+	d.off = skipValue(d.data, d.off)
+}
+
+// value returns the value at the current offset,
+// and skips it.
+func (d *decodeState) value() []byte {
+	start := d.off
+	d.skip()
+	return d.data[start:d.off]
+}
+
+// number returns the number literal at
+// the current offset, and skips it.
+func (d *decodeState) number() []byte {
+	start := d.off
+	d.off = skipValue(d.data, d.off)
+	return d.data[start:d.off]
+}
+
+// string returns the unescaped content of the string
+// at the current offset, and skips it. The content is
+// only valid until the next call, since it may be held
+// by the scratch buffer of the state.
+func (d *decodeState) string() []byte {
+	s, _ := d.unquote()
+	return s
+}
+
+// key is similar to string, but the content remains
+// valid while the value of the member is decoded.
+func (d *decodeState) key() []byte {
+	s, scratch := d.unquote()
+	if scratch {
+		return append([]byte(nil), s...)
+	}
+	return s
+}
+
+// unquote returns the unescaped content of the string
+// at the current offset, and whether it is held by the
+// scratch buffer.
+func (d *decodeState) unquote() ([]byte, bool) {
+	start := d.off + 1
+	d.off = skipString(d.data, d.off)
+	s := d.data[start : d.off-1]
+
+	if bytes.IndexByte(s, '\\') == -1 && utf8.Valid(s) {
+		return s, false
+	}
+	d.buf = unquoteInvalid(s, d.buf[:0])
+	return d.buf, true
+}
+
+// typeError skips the value at the current offset and
+// returns an UnmarshalTypeError for the type t.
+func (d *decodeState) typeError(t reflect.Type) error {
+	var what string
+	switch d.next() {
+	case '{':
+		what = "object"
+	case '[':
+		what = "array"
+	case '"':
+		what = "string"
+	case 't', 'f':
+		what = "bool"
+	default:
+		what = "number"
+	}
+	d.skip()
+	return &UnmarshalTypeError{Value: what, Type: t, Offset: int64(d.off)}
+}
+
+// numberError returns an UnmarshalTypeError for a
+// number that doesn't fit in a value of type t.
+func (d *decodeState) numberError(num []byte, t reflect.Type) error {
+	return &UnmarshalTypeError{
+		Value:  "number " + string(num),
+		Type:   t,
+		Offset: int64(d.off),
+	}
+}
+
+// enter skips the opening brace or bracket of the
+// object or array at the current offset, and reports
+// whether it has members or elements.
+func (d *decodeState) enter() bool {
+	d.off++
+	d.skipSpace()
+	if c := d.next(); c == '}' || c == ']' {
+		d.off++
+		return false
+	}
+	return true
+}
+
+// more skips the delimiter that follows a member or
+// an element, and reports whether another follows.
+func (d *decodeState) more() bool {
+	d.skipSpace()
+	c := d.next()
+	d.off++
+	if c == ',' {
+		d.skipSpace()
+		return true
+	}
+	return false
+}
+
+// objectKey returns the key of the member at the
+// current offset, and skips the colon after it.
+func (d *decodeState) objectKey() []byte {
+	k := d.key()
+	d.skipSpace()
+	d.off++ // ':'
+	d.skipSpace()
+	return k
+}
+
+// keepTypeError returns err, unless it is an
+// UnmarshalTypeError, in which case it is stored
+// in terr if it is the first one, and nil is
+// returned for the decoding to continue.
+func keepTypeError(err error, terr *error) error {
+	if _, ok := err.(*UnmarshalTypeError); !ok {
+		return err
+	}
+	if *terr == nil {
+		*terr = err
+	}
+	return nil
+}
+
+// interfaceValue returns the value at the current
+// offset as a Go value of the types that are used
+// for the empty interface.
+func (d *decodeState) interfaceValue() (interface{}, error) {
+	var terr error
+
+	switch d.next() {
+	case '{':
+		m := make(map[string]interface{})
+		for more := d.enter(); more; more = d.more() {
+			k := d.objectKey()
+			v, err := d.interfaceValue()
+			if err != nil {
+				if err = keepTypeError(withKeyPath(err, string(k)), &terr); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			m[d.internString(k)] = v
+		}
+		return m, terr
+	case '[':
+		s := make([]interface{}, 0)
+		for more := d.enter(); more; more = d.more() {
+			v, err := d.interfaceValue()
+			if err != nil {
+				if err = keepTypeError(withIndexPath(err, len(s)), &terr); err != nil {
+					return nil, err
+				}
+			}
+			s = append(s, v)
+		}
+		return s, terr
+	case '"':
+		return d.internString(d.string()), nil
+	case 'n':
+		d.off += len("null")
+		return nil, nil
+	case 't':
+		d.off += len("true")
+		return true, nil
+	case 'f':
+		d.off += len("false")
+		return false, nil
+	}
+	num := d.number()
+	if d.useNumber {
+		return json.Number(num), nil
+	}
+	f, err := strconv.ParseFloat(b2s(num), 64)
+	if err != nil {
+		return nil, d.numberError(num, reflect.TypeOf(f))
+	}
+	return f, nil
+}
+
+// stringCache holds the strings decoded, indexed by
+// a hash of their content, to spare the allocation of
+// those that are repeated, such as the keys of the
+// objects decoded into maps, or enumerated values.
+type stringCache [256]string
+
+// maxInternedLen is the maximum length of the strings
+// kept by a stringCache. It is large enough for UUIDs
+// or IPv6 addresses.
+const maxInternedLen = 64
+
+// internString returns the string form of b, from the
+// cache of the state if it holds it, or allocated and
+// added to the cache otherwise. Adapted from the cache
+// of the encoding/json/v2 package.
+func (d *decodeState) internString(b []byte) string {
+	if d.strs == nil || len(b) < 2 || len(b) > maxInternedLen {
+		return string(b)
+	}
+	// The hash is computed from the prefix and the
+	// suffix of the string, in constant time.
+	var h uint64
+	switch n := len(b); {
+	case n >= 8:
+		h = binary.LittleEndian.Uint64(b) ^ bits.RotateLeft64(binary.LittleEndian.Uint64(b[n-8:]), 31)
+	case n >= 4:
+		h = uint64(binary.LittleEndian.Uint32(b)) | uint64(binary.LittleEndian.Uint32(b[n-4:]))<<32
+	default:
+		h = uint64(binary.LittleEndian.Uint16(b)) | uint64(binary.LittleEndian.Uint16(b[n-2:]))<<16
+	}
+	h = (h ^ uint64(len(b))) * 0x9e3779b97f4a7c15
+	i := h >> 56
+
+	if s := d.strs[i]; s == string(b) {
+		return s
+	}
+	s := string(b)
+	d.strs[i] = s
+
+	return s
+}
+
+// unquoteInvalid is similar to unquoteBytes, but it
+// replaces the invalid UTF-8 sequences and the lone
+// surrogates by the Unicode replacement character,
+// like the encoding/json package, instead of failing.
+func unquoteInvalid(s, dst []byte) []byte {
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf && c != '\\' {
+			dst = append(dst, c)
+			i++
+			continue
+		}
+		if s[i] != '\\' {
+			r, n := utf8.DecodeRune(s[i:])
+			dst = utf8.AppendRune(dst, r)
+			i += n
+			continue
+		}
+		switch s[i+1] {
+		case 'b':
+			dst = append(dst, '\b')
+		case 'f':
+			dst = append(dst, '\f')
+		case 'n':
+			dst = append(dst, '\n')
+		case 'r':
+			dst = append(dst, '\r')
+		case 't':
+			dst = append(dst, '\t')
+		case 'u':
+			r, _ := unquoteRune(s[i:])
+			i += 6
+			if utf16.IsSurrogate(r) {
+				r2, ok := unquoteRune(s[i:])
+				if r = utf16.DecodeRune(r, r2); ok && r != utf8.RuneError {
+					i += 6
+				}
+			}
+			dst = utf8.AppendRune(dst, r)
+			continue
+		default: // '"', '\\', '/'
+			dst = append(dst, s[i+1])
+		}
+		i += 2
+	}
+	return dst
+}
+
+// checkValid returns a SyntaxError if data is
+// not a single valid JSON value.
+func checkValid(data []byte) error {
+	if _, ok := formatJSON(nil, data, formatValid, false, false, nil); ok {
+		return nil
+	}
+	return scanSyntaxError(data)
+}
+
+// scanSyntaxError returns the SyntaxError of data,
+// which is not a single valid JSON value, found by
+// a Scanner, or nil if it is valid.
+func scanSyntaxError(data []byte) error {
+	s := newBytesScanner(data)
+
+	if _, _, err := s.skipValue(); err != nil {
+		if err == io.EOF {
+			return errUnexpectedEnd(data)
+		}
+		return err
+	}
+	if _, ok := s.peek(); ok {
+		return s.invalidChar("after top-level value")
+	}
+	return nil
+}
+
+// newSyntaxError returns a SyntaxError with the given
+// message, for an error found at the offset pos of data,
+// which is the length of data at the end of the input.
+func newSyntaxError(msg string, data []byte, pos int) error {
+	off := pos
+	if pos < len(data) {
+		// Like the encoding/json package, the offset
+		// includes the byte that causes the error.
+		off++
+	}
+	line := 1 + bytes.Count(data[:pos], []byte{'\n'})
+	col := pos - bytes.LastIndexByte(data[:pos], '\n')
+
+	return &SyntaxError{msg: msg, Offset: int64(off), Line: line, Column: col}
+}
+
+// errUnexpectedEnd returns the SyntaxError of an
+// input that ends in the middle of a value.
+func errUnexpectedEnd(data []byte) error {
+	return newSyntaxError("unexpected end of JSON input", data, len(data))
+}
+
+// errInvalidChar returns the SyntaxError of the invalid
+// character at the offset off of data, described by the
+// given context, or the one of the unexpected end of the
+// input if off is the length of data, such as when the
+// input ends in the middle of a literal.
+func errInvalidChar(data []byte, off int, context string) error {
+	if off == len(data) {
+		return errUnexpectedEnd(data)
+	}
+	return newSyntaxError("invalid character "+quoteChar(data[off])+" "+context, data, off)
+}
+
+// errMaxDepth returns the SyntaxError of the object
+// or array that starts at the offset off of data and
+// exceeds the maximum nesting depth.
+func errMaxDepth(data []byte, off int) error {
+	return newSyntaxError("exceeded max nesting depth of "+strconv.Itoa(maxNestingDepth), data, off)
+}
+
+// quoteChar formats c as a quoted character
+// literal, like the encoding/json package.
+func quoteChar(c byte) string {
+	if c == '\'' {
+		return `'\''`
+	}
+	if c == '"' {
+		return `'"'`
+	}
+	s := strconv.Quote(string(c))
+	return "'" + s[1:len(s)-1] + "'"
+}
+
+func skipSpace(data []byte, off int) int {
+	for off < len(data) {
+		switch data[off] {
+		case ' ', '\t', '\n', '\r':
+			off++
+		default:
+			return off
+		}
+	}
+	return off
+}
+
+// scanString validates the string literal that
+// starts at the offset off of data, and returns
+// the offset of its end. If the literal is invalid,
+// the offset of the error is returned, which is the
+// length of data if the input ends too early. The
+// other scan functions follow the same rules.
+func scanString(data []byte, off int) (int, error) {
+	for off++; off < len(data); {
+		switch c := data[off]; {
+		case c == '"':
+			return off + 1, nil
+		case c == '\\':
+			off++
+			if off == len(data) {
+				return off, errInvalidChar(data, off, "in string escape code")
+			}
+			switch data[off] {
+			case '"', '\\', '/', 'b', 'f', 'n', 'r', 't':
+				off++
+			case 'u':
+				for i := 0; i < 4; i++ {
+					if off++; off == len(data) || !isHexDigit(data[off]) {
+						return off, errInvalidChar(data, off, "in \\u hexadecimal character escape")
+					}
+				}
+				off++
+			default:
+				return off, errInvalidChar(data, off, "in string escape code")
+			}
+		case c < ' ':
+			return off, errInvalidChar(data, off, "in string literal")
+		default:
+			off++
+		}
+	}
+	return off, errUnexpectedEnd(data)
+}
+
+func isHexDigit(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// scanNumber validates the number literal that
+// starts at the offset off of data, which ends
+// with the longest valid number.
+func scanNumber(data []byte, off int) (int, error) {
+	if data[off] == '-' {
+		if off++; off == len(data) || !isDigit(data[off]) {
+			return off, errInvalidChar(data, off, "in numeric literal")
+		}
+	}
+	if data[off] == '0' {
+		off++
+	} else {
+		for off < len(data) && isDigit(data[off]) {
+			off++
+		}
+	}
+	if off < len(data) && data[off] == '.' {
+		if off++; off == len(data) || !isDigit(data[off]) {
+			return off, errInvalidChar(data, off, "after decimal point in numeric literal")
+		}
+		for off < len(data) && isDigit(data[off]) {
+			off++
+		}
+	}
+	if off < len(data) && (data[off] == 'e' || data[off] == 'E') {
+		if off++; off < len(data) && (data[off] == '+' || data[off] == '-') {
+			off++
+		}
+		if off == len(data) || !isDigit(data[off]) {
+			return off, errInvalidChar(data, off, "in exponent of numeric literal")
+		}
+		for off < len(data) && isDigit(data[off]) {
+			off++
+		}
+	}
+	return off, nil
+}
+
+func scanLiteral(data []byte, off int, lit string) (int, error) {
+	for i := 1; i < len(lit); i++ {
+		if off+i == len(data) || data[off+i] != lit[i] {
+			return off + i, errInvalidChar(data, off+i, "in literal "+lit+" (expecting "+quoteChar(lit[i])+")")
+		}
+	}
+	return off + len(lit), nil
+}
+
+// skipValue returns the offset of the end of the
+// valid JSON value that starts at the offset off
+// of data.
+func skipValue(data []byte, off int) int {
+	switch data[off] {
+	case '"':
+		return skipString(data, off)
+	case '{', '[':
+		depth := 0
+		for ; ; off++ {
+			switch data[off] {
+			case '{', '[':
+				depth++
+			case '}', ']':
+				if depth--; depth == 0 {
+					return off + 1
+				}
+			case '"':
+				off = skipString(data, off) - 1
+			}
+		}
+	case 't', 'n':
+		return off + 4
+	case 'f':
+		return off + 5
+	}
+	for off++; off < len(data); off++ {
+		switch c := data[off]; {
+		case c >= '0' && c <= '9', c == '-', c == '+', c == '.', c == 'e', c == 'E':
+			continue
+		}
+		break
+	}
+	return off
+}
+
+// skipString returns the offset of the end of the
+// valid JSON string that starts at the offset off
+// of data.
+func skipString(data []byte, off int) int {
+	for off++; ; off++ {
+		switch data[off] {
+		case '"':
+			return off + 1
+		case '\\':
+			off++
+		}
+	}
+}
+
+// b2s converts a byte slice to a string without
+// copying, which must not outlive the slice.
+func b2s(b []byte) string {
+	return *(*string)(unsafe.Pointer(&b))
+}
@@ -0,0 +1,567 @@
+package jettison
+
+import (
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type (
+	textKey string
+	textVal struct{ S string }
+	jsonVal struct{ B []byte }
+)
+
+func (k *textKey) UnmarshalText(b []byte) error {
+	*k = textKey(strings.ToUpper(string(b)))
+	return nil
+}
+
+func (v *textVal) UnmarshalText(b []byte) error {
+	v.S = "text:" + string(b)
+	return nil
+}
+
+func (v *jsonVal) UnmarshalJSON(b []byte) error {
+	v.B = append(v.B[:0], b...)
+	return nil
+}
+
+type (
+	DecEmbedded struct {
+		E int `json:"e"`
+	}
+	decInner struct {
+		X int    `json:"x"`
+		Y string `json:"y,omitempty"`
+	}
+	decOuter struct {
+		A  bool                   `json:"a"`
+		B  string                 `json:"b"`
+		C  int8                   `json:"c"`
+		D  uint16                 `json:"d"`
+		F  float32                `json:"f"`
+		G  *decInner              `json:"g"`
+		H  []decInner             `json:"h"`
+		I  [2]int                 `json:"i"`
+		J  map[string]int         `json:"j"`
+		K  map[int]string         `json:"k"`
+		L  map[textKey]int        `json:"l"`
+		M  interface{}            `json:"m"`
+		N  []byte                 `json:"n"`
+		O  int64                  `json:"o,string"`
+		P  *bool                  `json:"p,string"`
+		Q  string                 `json:"q,string"`
+		R  textVal                `json:"r"`
+		S  jsonVal                `json:"s"`
+		T  json.RawMessage        `json:"t"`
+		U  json.Number            `json:"u"`
+		V  []interface{}          `json:"v"`
+		W  map[string]interface{} `json:"w"`
+		X  **int                  `json:"x"`
+		Z  uint8                  `json:"-"`
+		CI int                    `json:"CaseInsensitive"`
+		*DecEmbedded
+	}
+)
+
+func TestUnmarshal(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		data string
+		new  func() interface{}
+	}{
+		{"bool", `true`, func() interface{} { return new(bool) }},
+		{"string", `"aé\n😀"`, func() interface{} { return new(string) }},
+		{"invalid utf8", "\"a\xffb\\ud800c\"", func() interface{} { return new(string) }},
+		{"int", ` -42 `, func() interface{} { return new(int) }},
+		{"uint", `42`, func() interface{} { return new(uint64) }},
+		{"float", `1.5e3`, func() interface{} { return new(float64) }},
+		{"null", `null`, func() interface{} { i := 1; return &i }},
+		{"null pointer", `null`, func() interface{} { p := new(int); return &p }},
+		{"interface", `{"a":[1,"b",true,null,{"c":1.5}]}`, func() interface{} { return new(interface{}) }},
+		{"slice", `[1,2,3]`, func() interface{} { return new([]int) }},
+		{"empty slice", `[]`, func() interface{} { return new([]int) }},
+		{"shrunk slice", `[1]`, func() interface{} { s := []int{4, 5, 6}; return &s }},
+		{"short array", `[1]`, func() interface{} { a := [3]int{4, 5, 6}; return &a }},
+		{"long array", `[1,2,3]`, func() interface{} { return new([2]int) }},
+		{"bytes", `"aGVsbG8="`, func() interface{} { return new([]byte) }},
+		{"bytes array", `[104,105]`, func() interface{} { return new([]byte) }},
+		{"existing map", `{"b":2}`, func() interface{} { m := map[string]int{"a": 1}; return &m }},
+		{
+			"struct",
+			`{
+			  "a": true, "b": "b", "c": -8, "d": 16, "f": 0.5,
+			  "g": {"x": 1, "y": "y", "z": 0},
+			  "h": [{"x": 2}, {"y": "y"}],
+			  "i": [3, 4],
+			  "j": {"a": 1}, "k": {"-1": "a"}, "l": {"a": 1},
+			  "m": {"a": [1, null]},
+			  "n": "AQID",
+			  "o": "-64", "p": "true", "q": "\"q\"",
+			  "r": "r", "s": {"a": [ 1, 2 ]},
+			  "t": [ 1, {"b": 2} ], "u": 1.0E3,
+			  "v": [1, "a"], "w": {"a": {}},
+			  "x": 5, "Z": 8, "caseinsensitive": 9,
+			  "e": 10, "unknown": [{}]
+			}`,
+			func() interface{} { return new(decOuter) },
+		},
+		{"existing struct", `{"x":1}`, func() interface{} { return &decInner{X: 2, Y: "y"} }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			v1, v2 := tt.new(), tt.new()
+
+			err1 := Unmarshal([]byte(tt.data), v1)
+			err2 := json.Unmarshal([]byte(tt.data), v2)
+			if err1 != nil || err2 != nil {
+				t.Fatalf("got errors %v, %v", err1, err2)
+			}
+			if !reflect.DeepEqual(v1, v2) {
+				t.Errorf("got %#v, want %#v", v1, v2)
+			}
+		})
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	for _, tt := range []struct {
+		data   string
+		msg    string
+		offset int64
+	}{
+		{``, "unexpected end of JSON input", 0},
+		{` `, "unexpected end of JSON input", 1},
+		{`{`, "unexpected end of JSON input", 1},
+		{`[1,]`, "invalid character ']' looking for beginning of value", 4},
+		{`[1 2]`, "invalid character '2' after array element", 4},
+		{`{"a":1,}`, "invalid character '}' looking for beginning of object key string", 8},
+		{`{"a" 1}`, "invalid character '1' after object key", 6},
+		{`{1:1}`, "invalid character '1' looking for beginning of object key string", 2},
+		{`01`, "invalid character '1' after top-level value", 2},
+		{`"\x"`, "invalid character 'x' in string escape code", 3},
+		{`"\u12"`, `invalid character '"' in \u hexadecimal character escape`, 6},
+		{"\"\x01\"", `invalid character '\x01' in string literal`, 2},
+		{`"abc`, "unexpected end of JSON input", 4},
+		{`nul`, "unexpected end of JSON input", 3},
+		{`tru e`, "invalid character ' ' in literal true (expecting 'e')", 4},
+		{`1 2`, "invalid character '2' after top-level value", 3},
+		{`}`, "invalid character '}' looking for beginning of value", 1},
+		{`-`, "unexpected end of JSON input", 1},
+		{`1.`, "unexpected end of JSON input", 2},
+		{`1e+`, "unexpected end of JSON input", 3},
+		{`[01]`, "invalid character '1' after array element", 3},
+		{`"\`, "unexpected end of JSON input", 2},
+		{`"\u12`, "unexpected end of JSON input", 5},
+		{strings.Repeat(`[`, maxNestingDepth+1), "exceeded max nesting depth of 10000", maxNestingDepth + 1},
+		{strings.Repeat(`{"a":`, maxNestingDepth+1), "exceeded max nesting depth of 10000", 5*maxNestingDepth + 1},
+	} {
+		var v interface{}
+
+		err := Unmarshal([]byte(tt.data), &v)
+		e, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("%.20q: got %T, want SyntaxError", tt.data, err)
+			continue
+		}
+		if e.Error() != tt.msg {
+			t.Errorf("%.20q: got message %q, want %q", tt.data, e.Error(), tt.msg)
+		}
+		if e.Offset != tt.offset {
+			t.Errorf("%.20q: got offset %d, want %d", tt.data, e.Offset, tt.offset)
+		}
+	}
+}
+
+func TestUnmarshalTypeErrors(t *testing.T) {
+	type x struct {
+		A int              `json:"a"`
+		B []uint8          `json:"b"`
+		C map[string]bool  `json:"c"`
+		D string           `json:"d"`
+		E map[int]struct{} `json:"e"`
+	}
+	for _, tt := range []struct {
+		data  string
+		value string
+		path  string
+	}{
+		{`{"a":"1"}`, "string", "$.a"},
+		{`{"a":1.5}`, "number 1.5", "$.a"},
+		{`{"b":[1,256]}`, "number 256", "$.b[1]"},
+		{`{"c":{"k":0}}`, "number", "$.c.k"},
+		{`{"d":{},"a":true}`, "object", "$.d"},
+		{`{"e":{"a":{}}}`, "number a", "$.e.a"},
+	} {
+		var v1, v2 x
+		err1 := Unmarshal([]byte(tt.data), &v1)
+		err2 := json.Unmarshal([]byte(tt.data), &v2)
+
+		e, ok := err1.(*UnmarshalTypeError)
+		if !ok {
+			t.Errorf("%s: got %T, want UnmarshalTypeError", tt.data, err1)
+			continue
+		}
+		if e.Value != tt.value {
+			t.Errorf("%s: got value %q, want %q", tt.data, e.Value, tt.value)
+		}
+		if p := e.Path(); p != tt.path {
+			t.Errorf("%s: got path %s, want %s", tt.data, p, tt.path)
+		}
+		// The decoding continues after a type error,
+		// like with the encoding/json package.
+		if err2 != nil && !reflect.DeepEqual(v1, v2) {
+			t.Errorf("%s: got %#v, want %#v", tt.data, v1, v2)
+		}
+	}
+	if err := Unmarshal([]byte(`{"d":1}`), &x{}); !strings.HasSuffix(err.Error(), " at $.d") {
+		t.Errorf("got message %q, want path suffix", err)
+	}
+}
+
+func TestInvalidUnmarshal(t *testing.T) {
+	var i int
+	for _, v := range []interface{}{nil, i, (*int)(nil)} {
+		err := Unmarshal([]byte(`1`), v)
+		if _, ok := err.(*InvalidUnmarshalError); !ok {
+			t.Errorf("%T: got %T, want InvalidUnmarshalError", v, err)
+		}
+		if s, want := err.Error(), json.Unmarshal([]byte(`1`), v).Error(); s != want {
+			t.Errorf("got %q, want %q", s, want)
+		}
+	}
+}
+
+func TestUnmarshalGoTypes(t *testing.T) {
+	type x struct {
+		T1 time.Time     `json:"t1"`
+		T2 time.Time     `json:"t2"`
+		D1 time.Duration `json:"d1"`
+		D2 time.Duration `json:"d2"`
+		I  *big.Int      `json:"i"`
+		F  *big.Float    `json:"f"`
+		R  *big.Rat      `json:"r"`
+		S  big.Int       `json:"s"`
+	}
+	const data = `{
+	  "t1": "2020-01-02T03:04:05.6+01:00", "t2": 1577934245,
+	  "d1": "1m30s", "d2": 1500,
+	  "i": 123456789012345678901234567890, "f": 1.5e100, "r": "1/3",
+	  "s": "-42"
+	}`
+	var v x
+	if err := Unmarshal([]byte(data), &v); err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2020, 1, 2, 3, 4, 5, 6e8, time.FixedZone("", 3600)); !v.T1.Equal(want) {
+		t.Errorf("got %v, want %v", v.T1, want)
+	}
+	if want := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC); v.T2 != want {
+		t.Errorf("got %v, want %v", v.T2, want)
+	}
+	if v.D1 != 90*time.Second || v.D2 != 1500 {
+		t.Errorf("got durations %v, %v", v.D1, v.D2)
+	}
+	if s := v.I.String(); s != "123456789012345678901234567890" {
+		t.Errorf("got %s", s)
+	}
+	if f, _ := v.F.Float64(); f != 1.5e100 {
+		t.Errorf("got %v", f)
+	}
+	if s := v.R.String(); s != "1/3" {
+		t.Errorf("got %s", s)
+	}
+	if s := v.S.String(); s != "-42" {
+		t.Errorf("got %s", s)
+	}
+	var m sync.Map
+	if err := Unmarshal([]byte(`{"a":1,"b":[true]}`), &m); err != nil {
+		t.Fatal(err)
+	}
+	if a, _ := m.Load("a"); a != 1.0 {
+		t.Errorf("got %v, want 1", a)
+	}
+	if b, _ := m.Load("b"); !reflect.DeepEqual(b, []interface{}{true}) {
+		t.Errorf("got %v, want [true]", b)
+	}
+}
+
+func TestUnmarshalRoundTrip(t *testing.T) {
+	type (
+		address struct {
+			Street string `json:"street"`
+			City   string `json:"city,omitempty"`
+		}
+		x struct {
+			Price  int64           `json:"price,scale=2"`
+			Qty    *uint8          `json:"qty,string,scale=1"`
+			Home   address         `json:",inline,prefix=home_"`
+			Work   *address        `json:",inline,prefix=work_"`
+			Extra  map[string]int  `json:",inline"`
+			Nested []int           `json:"nested"`
+			Raw    json.RawMessage `json:"raw"`
+		}
+	)
+	qty := uint8(25)
+	v1 := x{
+		Price:  -12345,
+		Qty:    &qty,
+		Home:   address{Street: "s", City: "c"},
+		Work:   &address{Street: "w"},
+		Extra:  map[string]int{"a": 1, "b": 2},
+		Nested: []int{1, 2},
+		Raw:    json.RawMessage(`{"a":[]}`),
+	}
+	b, err := Marshal(v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v2 x
+	if err := Unmarshal(b, &v2); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v1, v2) {
+		t.Errorf("got %+v, want %+v", v2, v1)
+	}
+	// The scaled numbers must fit in the integer
+	// type once unscaled, without decimals left.
+	for _, data := range []string{`{"price":0.001}`, `{"qty":"25.6"}`, `{"qty":"1e10"}`} {
+		err := Unmarshal([]byte(data), &v2)
+		if _, ok := err.(*UnmarshalTypeError); !ok {
+			t.Errorf("%s: got %T, want UnmarshalTypeError", data, err)
+		}
+	}
+}
+
+func TestUnmarshalOptsRoundTrip(t *testing.T) {
+	type (
+		inner struct {
+			UserID int64 `json:",omitempty"`
+		}
+		x struct {
+			UserID  int64
+			HomeURL string
+			Count   uint
+			Small   int32
+			Elapsed time.Duration
+			When    time.Time
+			Inner   *inner
+			Items   []inner
+			Renamed string `json:"name"`
+		}
+	)
+	v1 := x{
+		UserID:  1<<53 + 1,
+		HomeURL: "https://example.com",
+		Count:   1<<64 - 1,
+		Small:   -3,
+		Elapsed: 90*time.Second + 500*time.Millisecond,
+		When:    time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Inner:   &inner{UserID: -(1 << 60)},
+		Items:   []inner{{UserID: 7}},
+		Renamed: "n",
+	}
+	for _, opts := range [][]Option{
+		{FieldNaming(SnakeCase)},
+		{FieldNaming(CamelCase), Int64Format(Int64String)},
+		{FieldNaming(KebabCase), Int64Format(Int64StringIfUnsafe)},
+		{DurationFormat(DurationString)},
+		{DurationFormat(DurationMinutes)},
+		{DurationFormat(DurationSeconds)},
+		{DurationFormat(DurationMilliseconds)},
+		{DurationFormat(DurationMicroseconds)},
+		{TimeLayout(time.RFC1123)},
+		{TimeLayout("02/01/2006 15:04:05")},
+		{UnixTime()},
+	} {
+		b, err := MarshalOpts(v1, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v2 x
+		if err := UnmarshalOpts(b, &v2, opts...); err != nil {
+			t.Errorf("%s: %v", b, err)
+			continue
+		}
+		if !reflect.DeepEqual(v1, v2) {
+			t.Errorf("%s:\ngot  %+v\nwant %+v", b, v2, v1)
+		}
+	}
+}
+
+func TestUnmarshalOptsErrors(t *testing.T) {
+	// The quoted integers are decoded only if the
+	// format of the 64-bit integers may quote them.
+	type x struct {
+		A int64 `json:"a"`
+		B int32 `json:"b"`
+	}
+	for _, tt := range []struct {
+		data string
+		opts []Option
+	}{
+		{`{"a":"1"}`, nil},
+		{`{"a":"1"}`, []Option{Int64Format(Int64Number)}},
+		{`{"b":"1"}`, []Option{Int64Format(Int64String)}},
+		{`{"a":"x"}`, []Option{Int64Format(Int64String)}},
+		{`{"a":"1.5"}`, []Option{Int64Format(Int64String)}},
+	} {
+		err := UnmarshalOpts([]byte(tt.data), &x{}, tt.opts...)
+		if _, ok := err.(*UnmarshalTypeError); !ok {
+			t.Errorf("%s: got %T, want UnmarshalTypeError", tt.data, err)
+		}
+	}
+	// The fractional numbers are durations only
+	// for the formats of minutes and seconds.
+	var d time.Duration
+	err := UnmarshalOpts([]byte(`1.5`), &d, DurationFormat(DurationMilliseconds))
+	if _, ok := err.(*UnmarshalTypeError); !ok {
+		t.Errorf("got %T, want UnmarshalTypeError", err)
+	}
+	if err := UnmarshalOpts([]byte(`1e300`), &d, DurationFormat(DurationMinutes)); err == nil {
+		t.Error("expected non-nil error")
+	}
+	err = UnmarshalOpts([]byte(`1`), &d, DurationFormat(DurationFmt(-1)))
+	if _, ok := err.(*InvalidOptionError); !ok {
+		t.Errorf("got %T, want InvalidOptionError", err)
+	}
+}
+
+func TestInternString(t *testing.T) {
+	d := decodeState{strs: new(stringCache)}
+
+	for _, s := range []string{
+		"a",
+		"ab",
+		"abcd",
+		"abcdefgh",
+		"8f9a65eb-4807-4d57-b6e0-bda5d62f1429",
+		strings.Repeat("x", maxInternedLen+1),
+	} {
+		b := []byte(s)
+		if got := d.internString(b); got != s {
+			t.Errorf("got %q, want %q", got, s)
+		}
+		// The strings cached are not allocated again,
+		// and the single bytes are never allocated.
+		var want float64
+		if len(s) > maxInternedLen {
+			want = 1
+		}
+		allocs := testing.AllocsPerRun(10, func() {
+			if got := d.internString(b); got != s {
+				t.Errorf("got %q, want %q", got, s)
+			}
+		})
+		if allocs != want {
+			t.Errorf("%q: got %v allocs, want %v", s, allocs, want)
+		}
+	}
+}
+
+func TestUnmarshalInlineSyncMap(t *testing.T) {
+	type x struct {
+		A int      `json:"a"`
+		M sync.Map `json:",inline"`
+	}
+	var v x
+	if err := Unmarshal([]byte(`{"a":1,"b":"c","A":2}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.A != 2 {
+		t.Errorf("got %d, want 2", v.A)
+	}
+	if b, _ := v.M.Load("b"); b != "c" {
+		t.Errorf("got %v, want c", b)
+	}
+}
+
+func TestUnmarshalRecursiveType(t *testing.T) {
+	type node struct {
+		V        int     `json:"v"`
+		Children []*node `json:"children"`
+	}
+	var n node
+	if err := Unmarshal([]byte(`{"v":1,"children":[{"v":2},{"v":3,"children":[{"v":4}]}]}`), &n); err != nil {
+		t.Fatal(err)
+	}
+	if v := n.Children[1].Children[0].V; v != 4 {
+		t.Errorf("got %d, want 4", v)
+	}
+}
+
+func TestUnmarshalInterfacePointer(t *testing.T) {
+	var (
+		i int
+		v interface{} = &i
+	)
+	if err := Unmarshal([]byte(`42`), &v); err != nil {
+		t.Fatal(err)
+	}
+	if i != 42 {
+		t.Errorf("got %d, want 42", i)
+	}
+	var s interface{ String() string }
+	if err := Unmarshal([]byte(`"a"`), &s); err == nil {
+		t.Error("expected non-nil error")
+	}
+}
+
+func TestUnmarshalQuotedErrors(t *testing.T) {
+	type x struct {
+		A int    `json:"a,string"`
+		B string `json:"b,string"`
+	}
+	for _, data := range []string{
+		`{"a":1}`,
+		`{"a":"x"}`,
+		`{"b":"b"}`,
+	} {
+		if err := Unmarshal([]byte(data), &x{}); err == nil {
+			t.Errorf("%s: expected non-nil error", data)
+		}
+	}
+	for i, data := range []string{`{"a":"12"}`, `{"a":" 12 "}`, `{"a":"null"}`} {
+		var v x
+		if err := Unmarshal([]byte(data), &v); err != nil {
+			t.Errorf("%s: %v", data, err)
+		}
+		if want := []int{12, 12, 0}[i]; v.A != want {
+			t.Errorf("%s: got %d, want %s", data, v.A, strconv.Itoa(want))
+		}
+	}
+}
+
+func TestUnmarshalEmbeddedPointers(t *testing.T) {
+	type (
+		inner struct{ A int }
+		x     struct{ *inner }
+		y     struct{ *DecEmbedded }
+	)
+	var v1 x
+	err := Unmarshal([]byte(`{"A":1}`), &v1)
+	const want = "json: cannot set embedded pointer to unexported struct: jettison.inner"
+	if err == nil || err.Error() != want {
+		t.Errorf("got %v, want %s", err, want)
+	}
+	// The existing pointers are used.
+	v1 = x{&inner{}}
+	if err := Unmarshal([]byte(`{"A":1}`), &v1); err != nil {
+		t.Fatal(err)
+	}
+	if v1.A != 1 {
+		t.Errorf("got %d, want 1", v1.A)
+	}
+	var v2 y
+	if err := Unmarshal([]byte(`{"e":2}`), &v2); err != nil {
+		t.Fatal(err)
+	}
+	if v2.DecEmbedded == nil || v2.E != 2 {
+		t.Errorf("got %+v, want E=2", v2.DecEmbedded)
+	}
+}
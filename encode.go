@@ -10,7 +10,6 @@ import (
 	"math"
 	"reflect"
 	"runtime"
-	"sort"
 	"strconv"
 	"sync"
 	"time"
@@ -20,6 +19,22 @@ import (
 
 const hex = "0123456789abcdef"
 
+// startDetectingCyclesAfter is the number of pointers,
+// maps and slices to traverse before checking whether
+// a value is already being encoded, which indicates a
+// cycle. Most values aren't that deep, and the cost of
+// the detection is avoided for them, like encoding/json
+// does.
+const startDetectingCyclesAfter = 1000
+
+// visitKey identifies a value that is being encoded
+// for the cycle detection.
+type visitKey struct {
+	typ unsafe.Pointer
+	ptr unsafe.Pointer
+	len int
+}
+
 //nolint:unparam
 func encodeBool(p unsafe.Pointer, dst []byte, _ encOpts) ([]byte, error) {
 	if *(*bool)(p) {
@@ -52,14 +67,26 @@ func encodeQuotedString(p unsafe.Pointer, dst []byte, opts encOpts) ([]byte, err
 
 // encodeFloat32 appends the textual representation of
 // the 32-bits floating point number pointed by p to dst.
-func encodeFloat32(p unsafe.Pointer, dst []byte, _ encOpts) ([]byte, error) {
-	return appendFloat(dst, float64(*(*float32)(p)), 32)
+func encodeFloat32(p unsafe.Pointer, dst []byte, opts encOpts) ([]byte, error) {
+	return appendFloat(dst, float64(*(*float32)(p)), 32, opts)
 }
 
 // encodeFloat64 appends the textual representation of
 // the 64-bits floating point number pointed by p to dst.
-func encodeFloat64(p unsafe.Pointer, dst []byte, _ encOpts) ([]byte, error) {
-	return appendFloat(dst, *(*float64)(p), 64)
+func encodeFloat64(p unsafe.Pointer, dst []byte, opts encOpts) ([]byte, error) {
+	return appendFloat(dst, *(*float64)(p), 64, opts)
+}
+
+// encodeQuotedFloat32 is similar to encodeFloat32, but
+// encloses the number with double-quote characters.
+func encodeQuotedFloat32(p unsafe.Pointer, dst []byte, opts encOpts) ([]byte, error) {
+	return appendQuotedFloat(dst, float64(*(*float32)(p)), 32, opts)
+}
+
+// encodeQuotedFloat64 is similar to encodeFloat64, but
+// encloses the number with double-quote characters.
+func encodeQuotedFloat64(p unsafe.Pointer, dst []byte, opts encOpts) ([]byte, error) {
+	return appendQuotedFloat(dst, *(*float64)(p), 64, opts)
 }
 
 func encodeInterface(p unsafe.Pointer, dst []byte, opts encOpts) ([]byte, error) {
@@ -68,7 +95,21 @@ func encodeInterface(p unsafe.Pointer, dst []byte, opts encOpts) ([]byte, error)
 		return append(dst, "null"...), nil
 	}
 	typ := reflect.TypeOf(v)
-	ins := cachedInstr(typ)
+	ins := opts.iset.cachedInstr(typ)
+
+	return ins(unpackEface(v).word, dst, opts)
+}
+
+// encodeMethodsInterface is similar to encodeInterface
+// for interfaces with methods, which all share a layout
+// that differs from the one of the empty interface.
+func encodeMethodsInterface(p unsafe.Pointer, dst []byte, opts encOpts) ([]byte, error) {
+	var v interface{} = *(*interface{ M() })(p)
+	if v == nil {
+		return append(dst, "null"...), nil
+	}
+	typ := reflect.TypeOf(v)
+	ins := opts.iset.cachedInstr(typ)
 
 	return ins(unpackEface(v).word, dst, opts)
 }
@@ -88,6 +129,9 @@ func encodeNumber(p unsafe.Pointer, dst []byte, opts encOpts) ([]byte, error) {
 	if !opts.flags.has(noNumberValidation) && !isValidNumber(num) {
 		return dst, fmt.Errorf("json: invalid number literal %q", num)
 	}
+	if opts.flags.has(canonical) {
+		return appendCanonicalNumber(dst, sp2b(unsafe.Pointer(&num)), opts)
+	}
 	return append(dst, num...), nil
 }
 
@@ -96,10 +140,16 @@ func encodeRawMessage(p unsafe.Pointer, dst []byte, opts encOpts) ([]byte, error
 	if v == nil {
 		return append(dst, "null"...), nil
 	}
+	if opts.flags.has(indentOutput) {
+		return appendIndentJSON(dst, v, opts)
+	}
+	if opts.flags.has(canonical) {
+		return appendCanonicalJSON(dst, v)
+	}
 	if opts.flags.has(noCompact) {
 		return append(dst, v...), nil
 	}
-	return appendCompactJSON(dst, v, !opts.flags.has(noHTMLEscaping))
+	return appendCompactJSON(dst, v, !opts.flags.has(noHTMLEscaping), false)
 }
 
 // encodeTime appends the time.Time value pointed by
@@ -137,9 +187,9 @@ func encodeDuration(p unsafe.Pointer, dst []byte, opts encOpts) ([]byte, error)
 	default: // DurationNanoseconds
 		return strconv.AppendInt(dst, d.Nanoseconds(), 10), nil
 	case DurationMinutes:
-		return appendFloat(dst, d.Minutes(), 64)
+		return appendFloat(dst, d.Minutes(), 64, opts)
 	case DurationSeconds:
-		return appendFloat(dst, d.Seconds(), 64)
+		return appendFloat(dst, d.Seconds(), 64, opts)
 	case DurationMicroseconds:
 		return strconv.AppendInt(dst, int64(d)/1e3, 10), nil
 	case DurationMilliseconds:
@@ -152,12 +202,9 @@ func encodeDuration(p unsafe.Pointer, dst []byte, opts encOpts) ([]byte, error)
 	}
 }
 
-func appendFloat(dst []byte, f float64, bs int) ([]byte, error) {
+func appendFloat(dst []byte, f float64, bs int, opts encOpts) ([]byte, error) {
 	if math.IsInf(f, 0) || math.IsNaN(f) {
-		return dst, &UnsupportedValueError{
-			reflect.ValueOf(f),
-			strconv.FormatFloat(f, 'g', -1, bs),
-		}
+		return appendNonFiniteFloat(dst, f, bs, opts)
 	}
 	// Convert as it was an ES6 number to string conversion.
 	// This matches most other JSON generators. The following
@@ -182,11 +229,100 @@ func appendFloat(dst []byte, f float64, bs int) ([]byte, error) {
 	return dst, nil
 }
 
-func encodePointer(p unsafe.Pointer, dst []byte, opts encOpts, ins instruction) ([]byte, error) {
-	if p = *(*unsafe.Pointer)(p); p != nil {
-		return ins(p, dst, opts)
+func appendQuotedFloat(dst []byte, f float64, bs int, opts encOpts) ([]byte, error) {
+	// The representations of the non-finite
+	// numbers are either a string or null,
+	// and must not be quoted again.
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return appendNonFiniteFloat(dst, f, bs, opts)
+	}
+	dst = append(dst, '"')
+	dst, _ = appendFloat(dst, f, bs, opts)
+	return append(dst, '"'), nil
+}
+
+// appendNonFiniteFloat appends the representation of
+// the NaN or infinite number f to dst, based on the
+// format configured in opts. If the value must be
+// omitted, dst is returned unchanged with errOmitValue.
+func appendNonFiniteFloat(dst []byte, f float64, bs int, opts encOpts) ([]byte, error) {
+	switch opts.nonFiniteFmt {
+	default: // NonFiniteError
+		return dst, &UnsupportedValueError{
+			Value: reflect.ValueOf(f),
+			Str:   strconv.FormatFloat(f, 'g', -1, bs),
+		}
+	case NonFiniteNull:
+		return append(dst, "null"...), nil
+	case NonFiniteString:
+		switch {
+		case math.IsNaN(f):
+			return append(dst, `"NaN"`...), nil
+		case f > 0:
+			return append(dst, `"Infinity"`...), nil
+		default:
+			return append(dst, `"-Infinity"`...), nil
+		}
+	case NonFiniteOmit:
+		return dst, errOmitValue
+	}
+}
+
+// nullIfOmitted appends the JSON null value to dst if
+// err is errOmitValue, since only the value of a struct
+// field can be omitted. Any other error is returned as is.
+func nullIfOmitted(dst []byte, err error) ([]byte, error) {
+	if err == errOmitValue {
+		return append(dst, "null"...), nil
+	}
+	return dst, err
+}
+
+func encodePointer(
+	p unsafe.Pointer, dst []byte, opts encOpts, t reflect.Type, ins instruction,
+) ([]byte, error) {
+	ptr := *(*unsafe.Pointer)(p)
+	if ptr == nil {
+		return append(dst, "null"...), nil
+	}
+	if opts.ptrLevel++; opts.ptrLevel > startDetectingCyclesAfter {
+		return encodeVisited(p, dst, opts, t, ptr, 0, func(opts encOpts) ([]byte, error) {
+			return ins(ptr, dst, opts)
+		})
 	}
-	return append(dst, "null"...), nil
+	return ins(ptr, dst, opts)
+}
+
+// encodeVisited calls fn to encode the value of type
+// t located at p, which references the data at ptr,
+// unless the value is already being encoded by one
+// of the instructions that led to this one, in which
+// case it returns an UnsupportedValueError.
+func encodeVisited(
+	p unsafe.Pointer, dst []byte, opts encOpts, t reflect.Type,
+	ptr unsafe.Pointer, len int, fn func(encOpts) ([]byte, error),
+) ([]byte, error) {
+	k := visitKey{typeID(t), ptr, len}
+
+	if _, ok := opts.ptrSeen[k]; ok {
+		// Copy the value, because p may
+		// point to a temporary variable.
+		v := reflect.New(t).Elem()
+		v.Set(reflect.NewAt(t, p).Elem())
+
+		return dst, &UnsupportedValueError{
+			Value: v,
+			Str:   fmt.Sprintf("encountered a cycle via %s", t),
+		}
+	}
+	if opts.ptrSeen == nil {
+		opts.ptrSeen = make(map[visitKey]struct{})
+	}
+	opts.ptrSeen[k] = struct{}{}
+	dst, err := fn(opts)
+	delete(opts.ptrSeen, k)
+
+	return dst, err
 }
 
 func encodeStruct(
@@ -197,13 +333,29 @@ func encodeStruct(
 		key []byte // key of the field
 	)
 	noHTMLEscape := opts.flags.has(noHTMLEscaping)
+	indent := opts.flags.has(indentOutput)
+	if opts.depth++; opts.depthExceeded() {
+		return dst, &DepthError{MaxDepth: opts.maxDepth}
+	}
+
+	// Keep track of the path trees that apply to
+	// this object, to restore them for each field.
+	allow, deny := opts.allowPaths, opts.denyPaths
+	hasPaths := allow != nil || deny != nil
 
 fieldLoop:
 	for i := 0; i < len(flds); i++ {
 		f := &flds[i] // get pointer to prevent copy
-		if opts.isDeniedField(f.name) {
+		if !f.inline && opts.isDeniedField(f.name) {
 			continue
 		}
+		if hasPaths && !f.inline {
+			var skip bool
+			opts.allowPaths, opts.denyPaths, skip = selectPath(allow, deny, f.name)
+			if skip {
+				continue
+			}
+		}
 		fp := p
 
 		// Find the nested struct field by following
@@ -221,6 +373,18 @@ fieldLoop:
 				}
 			}
 		}
+		if f.inline {
+			// The entries of an inline map are
+			// selected by key, with the path trees
+			// that apply to the object.
+			opts.allowPaths, opts.denyPaths = allow, deny
+
+			var err error
+			if dst, nxt, err = f.inlineInstr(fp, dst, opts, nxt); err != nil {
+				return dst, err
+			}
+			continue
+		}
 		// Ignore the field if it is a nil pointer and has
 		// the omitnil option in his tag.
 		if f.omitNil && *(*unsafe.Pointer)(fp) == nil {
@@ -233,34 +397,57 @@ fieldLoop:
 		if f.omitEmpty && f.empty(fp) {
 			continue
 		}
+		// Similarly, zero func is non-nil only if the
+		// field has the omitzero option in its tag.
+		if f.omitZero && f.zero(fp) {
+			continue
+		}
 		key = f.keyEscHTML
 		if noHTMLEscape {
 			key = f.keyNonEsc
 		}
-		lastKeyOffset := len(dst)
+		// Keep track of the offset and delimiter
+		// of the field, to remove it if its value
+		// is omitted once encoded.
+		off, prev := len(dst), nxt
+
 		dst = append(dst, nxt)
-		if nxt == '{' {
-			lastKeyOffset++
-		}
 		nxt = ','
+		if indent {
+			dst = opts.appendIndent(dst)
+		}
 		dst = append(dst, key...)
-
+		if indent {
+			dst = append(dst, ' ')
+		}
+		// Encode the field's value.
 		var err error
 		if dst, err = f.instr(fp, dst, opts); err != nil {
-			return dst, err
+			if err == errOmitValue {
+				dst, nxt = dst[:off], prev
+				continue
+			}
+			return dst, withKeyPath(err, f.name)
+		}
+		if opts.sizeExceeded(dst) {
+			return dst, withKeyPath(&SizeError{MaxBytes: opts.maxBytes}, f.name)
 		}
 		if f.omitNullMarshaler && len(dst) > 4 && bytes.Compare(dst[len(dst)-4:], []byte("null")) == 0 {
-			dst = dst[:lastKeyOffset]
+			dst, nxt = dst[:off], prev
 		}
 	}
 	if nxt == '{' {
 		return append(dst, "{}"...), nil
 	}
+	if indent {
+		opts.depth--
+		dst = opts.appendIndent(dst)
+	}
 	return append(dst, '}'), nil
 }
 
 func encodeSlice(
-	p unsafe.Pointer, dst []byte, opts encOpts, ins instruction, es uintptr,
+	p unsafe.Pointer, dst []byte, opts encOpts, t reflect.Type, ins instruction, es uintptr,
 ) ([]byte, error) {
 	shdr := (*sliceHeader)(p)
 	if shdr.Data == nil {
@@ -272,6 +459,14 @@ func encodeSlice(
 	if shdr.Len == 0 {
 		return append(dst, "[]"...), nil
 	}
+	if opts.ptrLevel++; opts.ptrLevel > startDetectingCyclesAfter {
+		// A slice is identified by its backing
+		// array and its length, since the slices
+		// of an array are different values.
+		return encodeVisited(p, dst, opts, t, shdr.Data, shdr.Len, func(opts encOpts) ([]byte, error) {
+			return encodeArray(shdr.Data, dst, opts, ins, es, shdr.Len, false)
+		})
+	}
 	return encodeArray(shdr.Data, dst, opts, ins, es, shdr.Len, false)
 }
 
@@ -312,18 +507,34 @@ func encodeArray(
 	}
 	var err error
 	nxt := byte('[')
+	indent := opts.flags.has(indentOutput)
+	if opts.depth++; opts.depthExceeded() {
+		return dst, &DepthError{MaxDepth: opts.maxDepth}
+	}
 
 	for i := 0; i < len; i++ {
 		dst = append(dst, nxt)
 		nxt = ','
+		if indent {
+			dst = opts.appendIndent(dst)
+		}
 		v := unsafe.Pointer(uintptr(p) + (uintptr(i) * es))
 		if dst, err = ins(v, dst, opts); err != nil {
-			return dst, err
+			if dst, err = nullIfOmitted(dst, err); err != nil {
+				return dst, withIndexPath(err, i)
+			}
+		}
+		if opts.sizeExceeded(dst) {
+			return dst, withIndexPath(&SizeError{MaxBytes: opts.maxBytes}, i)
 		}
 	}
 	if nxt == '[' {
 		return append(dst, "[]"...), nil
 	}
+	if indent {
+		opts.depth--
+		dst = opts.appendIndent(dst)
+	}
 	return append(dst, ']'), nil
 }
 
@@ -347,7 +558,7 @@ func encodeByteArrayAsString(p unsafe.Pointer, dst []byte, opts encOpts, len int
 }
 
 func encodeMap(
-	p unsafe.Pointer, dst []byte, opts encOpts, t reflect.Type, ki, vi instruction,
+	p unsafe.Pointer, dst []byte, opts encOpts, t reflect.Type, ki, vi instruction, sk bool,
 ) ([]byte, error) {
 	m := *(*unsafe.Pointer)(p)
 	if m == nil {
@@ -360,59 +571,130 @@ func encodeMap(
 	if ml == 0 {
 		return append(dst, "{}"...), nil
 	}
+	if opts.ptrLevel++; opts.ptrLevel > startDetectingCyclesAfter {
+		return encodeVisited(p, dst, opts, t, m, 0, func(opts encOpts) ([]byte, error) {
+			return encodeMapEntries(m, dst, opts, t, ki, vi, ml, sk)
+		})
+	}
+	return encodeMapEntries(m, dst, opts, t, ki, vi, ml, sk)
+}
+
+// encodeMapEntries appends the entries of the non-empty
+// map m, enclosed in braces, to dst.
+func encodeMapEntries(
+	m unsafe.Pointer, dst []byte, opts encOpts, t reflect.Type, ki, vi instruction, ml int, sk bool,
+) ([]byte, error) {
+	if opts.depth++; opts.depthExceeded() {
+		return dst, &DepthError{MaxDepth: opts.maxDepth}
+	}
 	dst = append(dst, '{')
+	off := len(dst)
 
+	dst, err := encodeMapElems(m, dst, opts, t, ki, vi, ml, sk, nil)
+	if err != nil {
+		return dst, err
+	}
+	// All the entries of the map may have
+	// been skipped by the path selection.
+	if opts.flags.has(indentOutput) && len(dst) != off {
+		opts.depth--
+		dst = opts.appendIndent(dst)
+	}
+	return append(dst, '}'), err
+}
+
+// encodeMapElems appends the elements of the non-empty
+// map m as comma-separated k/v pairs to dst, without the
+// enclosing braces. If names is not nil, the map is an
+// inline map, whose entries are filtered by skipInlineKey.
+func encodeMapElems(
+	m unsafe.Pointer, dst []byte, opts encOpts, t reflect.Type, ki, vi instruction, ml int, sk bool, names stringSet,
+) ([]byte, error) {
 	rt := unpackEface(t).word
 	it := newHiter(rt, m)
 
 	var err error
 	if opts.flags.has(unsortedMap) {
-		dst, err = encodeUnsortedMap(it, dst, opts, ki, vi)
+		dst, err = encodeUnsortedMap(it, dst, opts, ki, vi, sk, names)
 	} else {
-		dst, err = encodeSortedMap(it, dst, opts, ki, vi, ml)
+		dst, err = encodeSortedMap(it, dst, opts, ki, vi, ml, sk, names)
 	}
 	hiterPool.Put(it)
 
-	if err != nil {
-		return dst, err
-	}
-	return append(dst, '}'), err
+	return dst, err
 }
 
 // encodeUnsortedMap appends the elements of the map
 // pointed by p as comma-separated k/v pairs to dst,
-// in unspecified order.
+// in unspecified order. sk indicates whether the keys
+// of the map are strings.
 func encodeUnsortedMap(
-	it *hiter, dst []byte, opts encOpts, ki, vi instruction,
+	it *hiter, dst []byte, opts encOpts, ki, vi instruction, sk bool, names stringSet,
 ) ([]byte, error) {
 	var (
 		n   int
 		err error
 	)
+	indent := opts.flags.has(indentOutput)
+	allow, deny := opts.allowPaths, opts.denyPaths
+	hasPaths := allow != nil || deny != nil
+
 	for ; it.key != nil; mapiternext(it) {
+		off := len(dst)
 		if n != 0 {
 			dst = append(dst, ',')
 		}
+		if indent {
+			dst = opts.appendIndent(dst)
+		}
 		// Encode entry's key.
+		koff := len(dst)
 		if dst, err = ki(it.key, dst, opts); err != nil {
 			return dst, err
 		}
-		dst = append(dst, ':')
+		kend := len(dst)
+		// The keys of the inline maps are strings.
+		if names != nil && skipInlineKey(*(*string)(it.key), names, opts) {
+			dst = dst[:off]
+			continue
+		}
+		if hasPaths {
+			var skip bool
+			opts.allowPaths, opts.denyPaths, skip = selectPathBytes(
+				allow, deny, mapKey(it.key, dst[koff:kend], sk),
+			)
+			if skip {
+				dst = dst[:off]
+				continue
+			}
+		}
+		dst = appendKeySeparator(dst, indent)
 
 		// Encode entry's value.
 		if dst, err = vi(it.val, dst, opts); err != nil {
-			return dst, err
+			if dst, err = nullIfOmitted(dst, err); err != nil {
+				return dst, withKeyPath(err, string(mapKey(it.key, dst[koff:kend], sk)))
+			}
+		}
+		if opts.sizeExceeded(dst) {
+			err = &SizeError{MaxBytes: opts.maxBytes}
+			return dst, withKeyPath(err, string(mapKey(it.key, dst[koff:kend], sk)))
 		}
 		n++
 	}
 	return dst, nil
 }
 
-// encodeUnsortedMap appends the elements of the map
+// encodeSortedMap appends the elements of the map
 // pointed by p as comma-separated k/v pairs to dst,
-// sorted by key in lexicographical order.
+// sorted by key in lexicographical order. The keys
+// are encoded and sorted first, and the values are
+// encoded afterwards in the order of their keys, so
+// that the errors, such as the location where the
+// maximum size of the output is exceeded, do not
+// depend on the iteration order of the map.
 func encodeSortedMap(
-	it *hiter, dst []byte, opts encOpts, ki, vi instruction, ml int,
+	it *hiter, dst []byte, opts encOpts, ki, vi instruction, ml int, sk bool, names stringSet,
 ) ([]byte, error) {
 	var (
 		off int
@@ -426,42 +708,32 @@ func encodeSortedMap(
 		mel = &mapElems{s: make([]kv, 0, ml)}
 	}
 	for ; it.key != nil; mapiternext(it) {
-		kv := kv{}
-
 		// Encode the key and store the buffer
-		// portion to use during sort.
+		// portion to use during sort, along with
+		// the pointers to the key and the value,
+		// which remain valid until the map is
+		// modified.
 		if buf.B, err = ki(it.key, buf.B, opts); err != nil {
 			break
 		}
-		// Omit quotes of keys.
-		kv.key = buf.B[off+1 : len(buf.B)-1]
-
-		// Add separator after key.
-		buf.B = append(buf.B, ':')
-
-		// Encode the value and store the buffer
-		// portion corresponding to the semicolon
-		// delimited key/value pair.
-		if buf.B, err = vi(it.val, buf.B, opts); err != nil {
-			break
+		if names != nil && skipInlineKey(*(*string)(it.key), names, opts) {
+			buf.B = buf.B[:off]
+			continue
 		}
-		kv.keyval = buf.B[off:len(buf.B)]
-		mel.s = append(mel.s, kv)
+		mel.s = append(mel.s, kv{
+			key:    buf.B[off+1 : len(buf.B)-1], // omit quotes
+			keyval: buf.B[off:len(buf.B)],
+			kp:     it.key,
+			vp:     it.val,
+		})
 		off = len(buf.B)
 	}
 	if err == nil {
 		// Sort map entries by key in
 		// lexicographical order.
-		sort.Sort(mel)
+		sortMapElems(mel, opts)
 
-		// Append sorted comma-delimited k/v
-		// pairs to the given buffer.
-		for i, kv := range mel.s {
-			if i != 0 {
-				dst = append(dst, ',')
-			}
-			dst = append(dst, kv.keyval...)
-		}
+		dst, err = encodeSortedMapValues(mel, dst, opts, vi, sk)
 	}
 	// The map elements must be released before
 	// the buffer, because each k/v pair holds
@@ -473,6 +745,54 @@ func encodeSortedMap(
 	return dst, err
 }
 
+// encodeSortedMapValues appends the sorted pairs of
+// mel to dst as comma-separated k/v pairs, encoding
+// the value of each pair with the instruction vi.
+func encodeSortedMapValues(
+	mel *mapElems, dst []byte, opts encOpts, vi instruction, sk bool,
+) ([]byte, error) {
+	var (
+		n   int
+		err error
+	)
+	indent := opts.flags.has(indentOutput)
+	allow, deny := opts.allowPaths, opts.denyPaths
+	hasPaths := allow != nil || deny != nil
+
+	for i := range mel.s {
+		kv := &mel.s[i]
+		if hasPaths {
+			var skip bool
+			opts.allowPaths, opts.denyPaths, skip = selectPathBytes(
+				allow, deny, mapKey(kv.kp, kv.keyval, sk),
+			)
+			if skip {
+				continue
+			}
+		}
+		if n != 0 {
+			dst = append(dst, ',')
+		}
+		if indent {
+			dst = opts.appendIndent(dst)
+		}
+		dst = append(dst, kv.keyval...)
+		dst = appendKeySeparator(dst, indent)
+
+		if dst, err = vi(kv.vp, dst, opts); err != nil {
+			dst, err = nullIfOmitted(dst, err)
+		}
+		if err == nil && opts.sizeExceeded(dst) {
+			err = &SizeError{MaxBytes: opts.maxBytes}
+		}
+		if err != nil {
+			return dst, withKeyPath(err, string(mapKey(kv.kp, kv.keyval, sk)))
+		}
+		n++
+	}
+	return dst, nil
+}
+
 // encodeSyncMap appends the elements of a sync.Map pointed
 // to by p to dst and returns the extended buffer.
 // This function replicates the behavior of encoding Go maps,
@@ -480,8 +800,28 @@ func encodeSortedMap(
 // or int, or that does not implement encoding.TextMarshaler.
 func encodeSyncMap(p unsafe.Pointer, dst []byte, opts encOpts) ([]byte, error) {
 	sm := (*sync.Map)(p)
+	if opts.depth++; opts.depthExceeded() {
+		return dst, &DepthError{MaxDepth: opts.maxDepth}
+	}
 	dst = append(dst, '{')
+	off := len(dst)
 
+	dst, err := encodeSyncMapElems(sm, dst, opts, nil)
+	if err != nil {
+		return dst, err
+	}
+	// The closing brace of an empty map
+	// must not be indented.
+	if opts.flags.has(indentOutput) && len(dst) != off {
+		opts.depth--
+		dst = opts.appendIndent(dst)
+	}
+	return append(dst, '}'), nil
+}
+
+// encodeSyncMapElems is similar to encodeMapElems
+// but operates on a sync.Map type instead of a Go map.
+func encodeSyncMapElems(sm *sync.Map, dst []byte, opts encOpts, names stringSet) ([]byte, error) {
 	// The sync.Map type does not have a Len() method to
 	// determine if it has no entries, to bail out early,
 	// so we just range over it to encode all available
@@ -489,37 +829,62 @@ func encodeSyncMap(p unsafe.Pointer, dst []byte, opts encOpts) ([]byte, error) {
 	// If an error arises while encoding a key or a value,
 	// the error is stored and the method used by Range()
 	// returns false to stop the map's iteration.
-	var err error
 	if opts.flags.has(unsortedMap) {
-		dst, err = encodeUnsortedSyncMap(sm, dst, opts)
-	} else {
-		dst, err = encodeSortedSyncMap(sm, dst, opts)
-	}
-	if err != nil {
-		return dst, err
+		return encodeUnsortedSyncMap(sm, dst, opts, names)
 	}
-	return append(dst, '}'), nil
+	return encodeSortedSyncMap(sm, dst, opts, names)
 }
 
 // encodeUnsortedSyncMap is similar to encodeUnsortedMap
 // but operates on a sync.Map type instead of a Go map.
-func encodeUnsortedSyncMap(sm *sync.Map, dst []byte, opts encOpts) ([]byte, error) {
+func encodeUnsortedSyncMap(sm *sync.Map, dst []byte, opts encOpts, names stringSet) ([]byte, error) {
 	var (
 		n   int
 		err error
 	)
+	indent := opts.flags.has(indentOutput)
+	allow, deny := opts.allowPaths, opts.denyPaths
+	hasPaths := allow != nil || deny != nil
+
 	sm.Range(func(key, value interface{}) bool {
+		off := len(dst)
 		if n != 0 {
 			dst = append(dst, ',')
 		}
+		if indent {
+			dst = opts.appendIndent(dst)
+		}
 		// Encode the key.
+		koff := len(dst)
 		if dst, err = appendSyncMapKey(dst, key, opts); err != nil {
 			return false
 		}
-		dst = append(dst, ':')
+		kend := len(dst)
+		if names != nil && skipInlineKey(syncMapKey(key, dst[koff:kend]), names, opts) {
+			dst = dst[:off]
+			return true
+		}
+		if hasPaths {
+			var skip bool
+			opts.allowPaths, opts.denyPaths, skip = selectSyncMapKeyPath(
+				allow, deny, key, dst[koff:kend],
+			)
+			if skip {
+				dst = dst[:off]
+				return true
+			}
+		}
+		dst = appendKeySeparator(dst, indent)
 
 		// Encode the value.
 		if dst, err = appendJSON(dst, value, opts); err != nil {
+			dst, err = nullIfOmitted(dst, err)
+		}
+		if err == nil && opts.sizeExceeded(dst) {
+			err = &SizeError{MaxBytes: opts.maxBytes}
+		}
+		if err != nil {
+			err = withKeyPath(err, syncMapKey(key, dst[koff:kend]))
 			return false
 		}
 		n++
@@ -530,7 +895,7 @@ func encodeUnsortedSyncMap(sm *sync.Map, dst []byte, opts encOpts) ([]byte, erro
 
 // encodeSortedSyncMap is similar to encodeSortedMap
 // but operates on a sync.Map type instead of a Go map.
-func encodeSortedSyncMap(sm *sync.Map, dst []byte, opts encOpts) ([]byte, error) {
+func encodeSortedSyncMap(sm *sync.Map, dst []byte, opts encOpts, names stringSet) ([]byte, error) {
 	var (
 		off int
 		err error
@@ -543,51 +908,138 @@ func encodeSortedSyncMap(sm *sync.Map, dst []byte, opts encOpts) ([]byte, error)
 		mel = &mapElems{s: make([]kv, 0)}
 	}
 	sm.Range(func(key, value interface{}) bool {
-		kv := kv{}
-
 		// Encode the key and store the buffer
 		// portion to use during the later sort.
 		if buf.B, err = appendSyncMapKey(buf.B, key, opts); err != nil {
 			return false
 		}
-		// Omit quotes of keys.
-		kv.key = buf.B[off+1 : len(buf.B)-1]
-
-		// Add separator after key.
-		buf.B = append(buf.B, ':')
-
-		// Encode the value and store the buffer
-		// portion corresponding to the semicolon
-		// delimited key/value pair.
-		if buf.B, err = appendJSON(buf.B, value, opts); err != nil {
-			return false
+		if names != nil && skipInlineKey(syncMapKey(key, buf.B[off:]), names, opts) {
+			buf.B = buf.B[:off]
+			return true
 		}
-		kv.keyval = buf.B[off:len(buf.B)]
-		mel.s = append(mel.s, kv)
+		mel.s = append(mel.s, kv{
+			key:    buf.B[off+1 : len(buf.B)-1], // omit quotes
+			keyval: buf.B[off:len(buf.B)],
+		})
+		mel.ifaces = append(mel.ifaces, key, value)
 		off = len(buf.B)
 
 		return true
 	})
 	if err == nil {
+		// The pairs point to their key and value
+		// once all the entries have been stored.
+		for i := range mel.s {
+			mel.s[i].kp = unsafe.Pointer(&mel.ifaces[2*i])
+			mel.s[i].vp = unsafe.Pointer(&mel.ifaces[2*i+1])
+		}
 		// Sort map entries by key in
 		// lexicographical order.
-		sort.Sort(mel)
+		sortMapElems(mel, opts)
 
-		// Append sorted comma-delimited k/v
-		// pairs to the given buffer.
-		for i, kv := range mel.s {
-			if i != 0 {
-				dst = append(dst, ',')
-			}
-			dst = append(dst, kv.keyval...)
-		}
+		dst, err = encodeSortedSyncMapValues(mel, dst, opts)
 	}
+	// The map elements must be released before
+	// the buffer, because each k/v pair holds
+	// two sublices that points to the buffer's
+	// backing array.
 	releaseMapElems(mel)
 	bufferPool.Put(buf)
 
 	return dst, err
 }
 
+// encodeSortedSyncMapValues is similar to
+// encodeSortedMapValues for the pairs of
+// a sync.Map, whose values are interfaces.
+func encodeSortedSyncMapValues(mel *mapElems, dst []byte, opts encOpts) ([]byte, error) {
+	var (
+		n   int
+		err error
+	)
+	indent := opts.flags.has(indentOutput)
+	allow, deny := opts.allowPaths, opts.denyPaths
+	hasPaths := allow != nil || deny != nil
+
+	for i := range mel.s {
+		kv := &mel.s[i]
+		key := *(*interface{})(kv.kp)
+		if hasPaths {
+			var skip bool
+			opts.allowPaths, opts.denyPaths, skip = selectSyncMapKeyPath(
+				allow, deny, key, kv.keyval,
+			)
+			if skip {
+				continue
+			}
+		}
+		if n != 0 {
+			dst = append(dst, ',')
+		}
+		if indent {
+			dst = opts.appendIndent(dst)
+		}
+		dst = append(dst, kv.keyval...)
+		dst = appendKeySeparator(dst, indent)
+
+		if dst, err = appendJSON(dst, *(*interface{})(kv.vp), opts); err != nil {
+			dst, err = nullIfOmitted(dst, err)
+		}
+		if err == nil && opts.sizeExceeded(dst) {
+			err = &SizeError{MaxBytes: opts.maxBytes}
+		}
+		if err != nil {
+			return dst, withKeyPath(err, syncMapKey(key, kv.keyval))
+		}
+		n++
+	}
+	return dst, nil
+}
+
+// mapKey returns the key of a map entry to use for
+// the selection of paths. For string keys, this is the
+// raw string pointed by p, otherwise the JSON-encoded
+// key enc, without its enclosing quotes.
+func mapKey(p unsafe.Pointer, enc []byte, sk bool) []byte {
+	if sk {
+		return sp2b(p)
+	}
+	return enc[1 : len(enc)-1]
+}
+
+// syncMapKey returns the key of a sync.Map entry,
+// which is also given in its JSON-encoded form enc,
+// as a string.
+func syncMapKey(key interface{}, enc []byte) string {
+	if s, ok := key.(string); ok {
+		return s
+	}
+	return string(enc[1 : len(enc)-1])
+}
+
+// selectSyncMapKeyPath is similar to selectPathBytes,
+// for the key of a sync.Map entry, which is also given
+// in its JSON-encoded form enc.
+func selectSyncMapKeyPath(
+	allow, deny *pathNode, key interface{}, enc []byte,
+) (*pathNode, *pathNode, bool) {
+	if s, ok := key.(string); ok {
+		return selectPath(allow, deny, s)
+	}
+	return selectPathBytes(allow, deny, enc[1:len(enc)-1])
+}
+
+// appendKeySeparator appends the separator between
+// the key and the value of an object's member to dst.
+// When the output is indented, the colon is followed
+// by a space, like the encoding/json package does.
+func appendKeySeparator(dst []byte, indent bool) []byte {
+	if indent {
+		return append(dst, ':', ' ')
+	}
+	return append(dst, ':')
+}
+
 func appendSyncMapKey(dst []byte, key interface{}, opts encOpts) ([]byte, error) {
 	if key == nil {
 		return dst, errors.New("unsupported nil key in sync.Map")
@@ -681,124 +1133,172 @@ func encodeAppendMarshalerCtx(
 ) ([]byte, error) {
 	dst2, err := i.(AppendMarshalerCtx).AppendJSONContext(opts.ctx, dst)
 	if err != nil {
-		return dst, &MarshalerError{t, err, marshalerAppendJSONCtx}
+		return dst, &MarshalerError{Type: t, Err: err, funcName: marshalerAppendJSONCtx}
 	}
-	return dst2, nil
+	return formatAppended(dst2, len(dst), opts, t, marshalerAppendJSONCtx)
 }
 
 func encodeAppendMarshaler(
-	i interface{}, dst []byte, _ encOpts, t reflect.Type,
+	i interface{}, dst []byte, opts encOpts, t reflect.Type,
 ) ([]byte, error) {
 	dst2, err := i.(AppendMarshaler).AppendJSON(dst)
 	if err != nil {
-		return dst, &MarshalerError{t, err, marshalerAppendJSON}
+		return dst, &MarshalerError{Type: t, Err: err, funcName: marshalerAppendJSON}
 	}
-	return dst2, nil
+	return formatAppended(dst2, len(dst), opts, t, marshalerAppendJSON)
+}
+
+// formatAppended indents or canonicalizes the JSON
+// value that a marshaler appended to dst, starting at
+// offset off, according to the options. The output of
+// the marshalers is left as is otherwise.
+func formatAppended(dst []byte, off int, opts encOpts, t reflect.Type, funcName string) ([]byte, error) {
+	switch {
+	case opts.flags.has(indentOutput):
+		dst2, err := indentAppended(dst, off, opts)
+		if err != nil {
+			return dst[:off], &MarshalerError{Type: t, Err: err, funcName: funcName}
+		}
+		return dst2, nil
+	case opts.flags.has(canonical):
+		dst2, err := canonicalizeAppended(dst, off)
+		if err != nil {
+			return dst[:off], &MarshalerError{Type: t, Err: err, funcName: funcName}
+		}
+		return dst2, nil
+	}
+	return dst, nil
 }
 
 func encodeJSONMarshaler(i interface{}, dst []byte, opts encOpts, t reflect.Type) ([]byte, error) {
 	b, err := i.(json.Marshaler).MarshalJSON()
 	if err != nil {
-		return dst, &MarshalerError{t, err, marshalerJSON}
+		return dst, &MarshalerError{Type: t, Err: err, funcName: marshalerJSON}
 	}
-	if opts.flags.has(noCompact) {
+	if opts.flags.has(noCompact) && !opts.flags.has(indentOutput) {
 		return append(dst, b...), nil
 	}
-	// This is redundant with the parsing done
-	// by appendCompactJSON, but for the time
-	// being, we can't use the scanner of the
-	// standard library.
-	if !json.Valid(b) {
-		return dst, &MarshalerError{t, &SyntaxError{
-			msg: "json: invalid value",
-		}, marshalerJSON}
+	if opts.flags.has(canonical) {
+		dst2, err := appendCanonicalJSON(dst, b)
+		if err != nil {
+			return dst, &MarshalerError{Type: t, Err: err, funcName: marshalerJSON}
+		}
+		return dst2, nil
+	}
+	var dst2 []byte
+	if opts.flags.has(indentOutput) {
+		dst2, err = appendIndentJSON(dst, b, opts)
+	} else {
+		dst2, err = appendCompactJSON(dst, b, !opts.flags.has(noHTMLEscaping), false)
+	}
+	if err != nil {
+		return dst, &MarshalerError{Type: t, Err: err, funcName: marshalerJSON}
 	}
-	return appendCompactJSON(dst, b, !opts.flags.has(noHTMLEscaping))
+	return dst2, nil
 }
 
-func encodeTextMarshaler(i interface{}, dst []byte, _ encOpts, t reflect.Type) ([]byte, error) {
+func encodeTextMarshaler(i interface{}, dst []byte, opts encOpts, t reflect.Type) ([]byte, error) {
 	b, err := i.(encoding.TextMarshaler).MarshalText()
 	if err != nil {
-		return dst, &MarshalerError{t, err, marshalerText}
+		return dst, &MarshalerError{Type: t, Err: err, funcName: marshalerText}
 	}
 	dst = append(dst, '"')
-	dst = append(dst, b...)
+	if opts.flags.has(canonical) {
+		dst = appendEscapedBytes(dst, b, opts)
+	} else {
+		dst = append(dst, b...)
+	}
 	dst = append(dst, '"')
 
 	return dst, nil
 }
 
 // appendCompactJSON appends to dst the JSON-encoded src
-// with insignificant space characters elided. If escHTML
-// is true, HTML-characters are also escaped.
-func appendCompactJSON(dst, src []byte, escHTML bool) ([]byte, error) {
-	var (
-		inString bool
-		skipNext bool
-	)
+// with insignificant space characters elided. The strings
+// are escaped by appendEscapedJSON according to escHTML
+// and coerce. If src is not a single valid JSON value, a
+// SyntaxError is returned.
+func appendCompactJSON(dst, src []byte, escHTML, coerce bool) ([]byte, error) {
+	return appendFormattedJSON(dst, src, escHTML, coerce, nil)
+}
+
+// appendIndentJSON is similar to appendCompactJSON,
+// but also indents the output according to the
+// current nesting depth and indentation options.
+func appendIndentJSON(dst, src []byte, opts encOpts) ([]byte, error) {
+	return appendFormattedJSON(dst, src, !opts.flags.has(noHTMLEscaping), false, &opts)
+}
+
+// appendFormattedJSON appends to dst the JSON-encoded
+// src, with each element of an object or array placed
+// on a new line indented according to the options ind
+// if it isn't nil. If src is not a single valid JSON
+// value, the Scanner finds the SyntaxError to return.
+func appendFormattedJSON(dst, src []byte, escHTML, coerce bool, ind *encOpts) ([]byte, error) {
+	mode := formatCompact
+	if ind != nil {
+		mode = formatIndent
+	}
+	if dst2, ok := formatJSON(dst, src, mode, escHTML, coerce, ind); ok {
+		return dst2, nil
+	}
+	return dst, scanSyntaxError(src)
+}
+
+// appendEscapedJSON appends to dst the JSON-encoded src.
+// If escHTML is true, the HTML characters and the U+2028
+// and U+2029 characters are escaped, like the encoding/json
+// package does. If coerce is true, the invalid UTF-8 bytes
+// are replaced with the Unicode replacement rune. Since
+// these characters and bytes are only valid inside string
+// literals, src doesn't need to be parsed.
+func appendEscapedJSON(dst, src []byte, escHTML, coerce bool) []byte {
 	at := 0 // accumulated bytes start index
 
-	for i, c := range src {
-		if escHTML {
-			// Escape HTML characters.
-			if c == '<' || c == '>' || c == '&' {
-				if at < i {
-					dst = append(dst, src[at:i]...)
-				}
+	for i := 0; i < len(src); {
+		c := src[i]
+		if c < utf8.RuneSelf {
+			if escHTML && (c == '<' || c == '>' || c == '&') {
+				dst = append(dst, src[at:i]...)
 				dst = append(dst, `\u00`...)
 				dst = append(dst, hex[c>>4], hex[c&0xF])
 				at = i + 1
-				continue
-			}
-		}
-		// Convert U+2028 and U+2029.
-		// (E2 80 A8 and E2 80 A9).
-		if c == 0xE2 && i+2 < len(src) && src[i+1] == 0x80 && src[i+2]&^1 == 0xA8 {
-			if at < i {
-				dst = append(dst, src[at:i]...)
-			}
-			dst = append(dst, `\u202`...)
-			dst = append(dst, hex[src[i+2]&0xF])
-			at = i + 3
-			continue
-		}
-		if !inString {
-			switch c {
-			case '"':
-				// Within a string, we don't elide
-				// insignificant space characters.
-				inString = true
-			case ' ', '\n', '\r', '\t':
-				// Append the accumulated bytes,
-				// and skip the current character.
-				if at < i {
-					dst = append(dst, src[at:i]...)
-				}
-				at = i + 1
 			}
+			i++
 			continue
 		}
-		// Next character is escaped, and must
-		// not be interpreted as the end of a
-		// string by mistake.
-		if skipNext {
-			skipNext = false
-			continue
-		}
-		// Next character must be skipped.
-		if c == '\\' {
-			skipNext = true
+		r, size := utf8.DecodeRune(src[i:])
+		switch {
+		case r == utf8.RuneError && size == 1 && coerce:
+			dst = append(dst, src[at:i]...)
+			dst = append(dst, `\ufffd`...)
+		case (r == '\u2028' || r == '\u2029') && escHTML:
+			dst = append(dst, src[at:i]...)
+			dst = append(dst, `\u202`...)
+			dst = append(dst, hex[r&0xF])
+		default:
+			i += size
 			continue
 		}
-		// Leaving a string value.
-		if c == '"' {
-			inString = false
-		}
-	}
-	if at < len(src) {
-		dst = append(dst, src[at:]...)
+		i += size
+		at = i
 	}
-	return dst, nil
+	return append(dst, src[at:]...)
+}
+
+// indentAppended indents the JSON value that a
+// marshaler appended to dst, starting at offset off.
+// The insignificant space characters of the value are
+// elided first, and its strings are left as is.
+func indentAppended(dst []byte, off int, opts encOpts) ([]byte, error) {
+	buf := cachedBuffer()
+	buf.B = append(buf.B, dst[off:]...)
+
+	var err error
+	dst, err = appendFormattedJSON(dst[:off], buf.B, false, false, &opts)
+	bufferPool.Put(buf)
+
+	return dst, err
 }
 
 func appendEscapedBytes(dst []byte, b []byte, opts encOpts) []byte {
@@ -811,6 +1311,7 @@ func appendEscapedBytes(dst []byte, b []byte, opts encOpts) []byte {
 	)
 	noCoerce := opts.flags.has(noUTF8Coercion)
 	noEscape := opts.flags.has(noHTMLEscaping)
+	canon := opts.flags.has(canonical)
 
 	for i < len(b) {
 		if c := b[i]; c < utf8.RuneSelf {
@@ -842,6 +1343,18 @@ func appendEscapedBytes(dst []byte, b []byte, opts encOpts) []byte {
 				dst = append(dst, '\\', 'r')
 			case '\t': // 0x9, horizontal tab
 				dst = append(dst, '\\', 't')
+			case '\b', '\f':
+				// The canonical form of RFC 8785 uses
+				// all the two-character sequences.
+				if canon {
+					if c == '\b' {
+						dst = append(dst, '\\', 'b')
+					} else {
+						dst = append(dst, '\\', 'f')
+					}
+					break
+				}
+				fallthrough
 			default:
 				dst = append(dst, `\u00`...)
 				dst = append(dst, hex[c>>4])
@@ -860,7 +1373,11 @@ func appendEscapedBytes(dst []byte, b []byte, opts encOpts) []byte {
 				if at < i {
 					dst = append(dst, b[at:i]...)
 				}
-				dst = append(dst, `\ufffd`...)
+				if canon {
+					dst = append(dst, "\ufffd"...)
+				} else {
+					dst = append(dst, `\ufffd`...)
+				}
 				i += size
 				at = i
 				continue
@@ -873,7 +1390,7 @@ func appendEscapedBytes(dst []byte, b []byte, opts encOpts) []byte {
 			// security holes there. It is valid JSON to escape
 			// them, so we do so unconditionally.
 			// See http://timelessrepo.com/json-isnt-a-javascript-subset.
-			if r == '\u2028' || r == '\u2029' {
+			if (r == '\u2028' || r == '\u2029') && !canon {
 				if at < i {
 					dst = append(dst, b[at:i]...)
 				}
@@ -0,0 +1,52 @@
+package jettison
+
+// An Encoder encodes values to JSON using a set of
+// options that are applied and validated only once,
+// when the encoder is created, rather than on every
+// call like MarshalOpts and AppendOpts do.
+// An Encoder is safe for concurrent use by multiple
+// goroutines.
+type Encoder struct {
+	opts encOpts
+}
+
+// NewEncoder returns a new Encoder configured with
+// the given options. An InvalidOptionError is
+// returned if one of the options is invalid.
+func NewEncoder(opts ...Option) (*Encoder, error) {
+	eo, err := defaultEncOpts().with(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Encoder{opts: eo}, nil
+}
+
+// With returns a new Encoder that uses the options
+// of e, with the given options applied on top of
+// them. The receiver is left unchanged.
+func (e *Encoder) With(opts ...Option) (*Encoder, error) {
+	eo, err := e.opts.with(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Encoder{opts: eo}, nil
+}
+
+// Marshal returns the JSON encoding of v, using
+// the options of the encoder.
+func (e *Encoder) Marshal(v interface{}) ([]byte, error) {
+	if v == nil {
+		return []byte("null"), nil
+	}
+	return marshalJSON(v, e.opts)
+}
+
+// Append is similar to Marshal but appends the JSON
+// representation of v to dst instead of returning a
+// new allocated slice.
+func (e *Encoder) Append(dst []byte, v interface{}) ([]byte, error) {
+	if v == nil {
+		return append(dst, "null"...), nil
+	}
+	return appendJSON(dst, v, e.opts)
+}
@@ -0,0 +1,161 @@
+package jettison
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEncoder(t *testing.T) {
+	opts := []Option{
+		DurationFormat(DurationString),
+		NoHTMLEscaping(),
+	}
+	enc, err := NewEncoder(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []interface{}{
+		nil, xx, &xx, 2 * time.Second, "<&>",
+	} {
+		want, err := MarshalOpts(v, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b1, err := enc.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b1, want) {
+			t.Errorf("Marshal: got %s, want %s", b1, want)
+		}
+		b2, err := enc.Append([]byte("x"), v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b2, append([]byte("x"), want...)) {
+			t.Errorf("Append: got %s, want x%s", b2, want)
+		}
+	}
+}
+
+func TestEncoderWith(t *testing.T) {
+	base, err := NewEncoder(DurationFormat(DurationString))
+	if err != nil {
+		t.Fatal(err)
+	}
+	derived, err := base.With(DenyList([]string{"b"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The settings shared with base are copied,
+	// and not modified in place.
+	seconds, err := base.With(DurationFormat(DurationSeconds))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := struct {
+		A time.Duration `json:"a"`
+		B int           `json:"b"`
+	}{time.Second, 42}
+
+	for _, tt := range []struct {
+		enc  *Encoder
+		want string
+	}{
+		{base, `{"a":"1s","b":42}`},
+		{derived, `{"a":"1s"}`},
+		{seconds, `{"a":1,"b":42}`},
+	} {
+		b, err := tt.enc.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := string(b); s != tt.want {
+			t.Errorf("got %s, want %s", s, tt.want)
+		}
+	}
+	if _, err := base.With(TimeLayout("")); err == nil {
+		t.Error("expected non-nil error")
+	} else if _, ok := err.(*InvalidOptionError); !ok {
+		t.Errorf("got %T, want InvalidOptionError", err)
+	}
+}
+
+// TestOptionsSharedConfig tests that the options
+// that only set flags share the default settings,
+// instead of copying them for each call.
+func TestOptionsSharedConfig(t *testing.T) {
+	eo, err := defaultEncOpts().with(UnsortedMap(), NoHTMLEscaping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if eo.encConfig != &defaultEncConfig {
+		t.Error("expected default settings to be shared")
+	}
+	eo, err = defaultEncOpts().with(TimeLayout(time.Kitchen), FloatPrecision(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if eo.encConfig == &defaultEncConfig {
+		t.Fatal("expected default settings to be copied")
+	}
+	if defaultEncConfig.timeLayout != defaultTimeLayout || defaultEncConfig.iset != defaultInstrSet {
+		t.Error("default settings modified")
+	}
+	if eo.timeLayout != time.Kitchen || eo.iset.floatPrec != 2 {
+		t.Error("options not applied")
+	}
+	n := testing.AllocsPerRun(100, func() {
+		_, _ = MarshalOpts(1, UnsortedMap())
+	})
+	// The options, which escape to the heap
+	// when applied, and the output.
+	if n > 2 {
+		t.Errorf("got %v allocs, want at most 2", n)
+	}
+}
+
+func TestInvalidEncoderOpts(t *testing.T) {
+	enc, err := NewEncoder(TimeLayout(""))
+	if err == nil {
+		t.Fatal("expected non-nil error")
+	}
+	if _, ok := err.(*InvalidOptionError); !ok {
+		t.Errorf("got %T, want InvalidOptionError", err)
+	}
+	if enc != nil {
+		t.Error("expected nil encoder")
+	}
+}
+
+func TestEncoderConcurrency(t *testing.T) {
+	enc, err := NewEncoder(UnsortedMap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := enc.Marshal(&xx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				b, err := enc.Marshal(&xx)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if len(b) != len(want) {
+					t.Errorf("got %d bytes, want %d", len(b), len(want))
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
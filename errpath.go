@@ -0,0 +1,111 @@
+package jettison
+
+import (
+	"strconv"
+	"strings"
+)
+
+// maxErrorPathSegments is the maximum number of
+// segments of an errorPath. A cycle is detected
+// after a thousand levels of nesting, and the path
+// of the value that revisits a pointer is mostly a
+// repetition of the cycle.
+const maxErrorPathSegments = 64
+
+// An errorPath is the location of the value that
+// caused an encoding or decoding error, from the root
+// of the document, embedded in the errors that report
+// it. It is recorded while the instructions return,
+// which costs nothing unless an error occurs.
+// The segments are stored in reverse order, since
+// the innermost one is known first. Only the first
+// segments from the root are kept, and the number
+// of innermost segments dropped is counted.
+type errorPath struct {
+	segs   []string
+	elided int
+}
+
+// Path returns the location of the value from
+// the root of the document, in the JSONPath
+// notation, such as $.orders[17].items[3].price.
+// A truncated path ends with an ellipsis.
+func (p *errorPath) Path() string {
+	var sb strings.Builder
+	sb.WriteByte('$')
+	for i := len(p.segs) - 1; i >= 0; i-- {
+		sb.WriteString(p.segs[i])
+	}
+	if p.elided != 0 {
+		sb.WriteString("...")
+	}
+	return sb.String()
+}
+
+// atRoot returns whether the path is the one
+// of the root value.
+func (p *errorPath) atRoot() bool {
+	return len(p.segs) == 0
+}
+
+// addPathSegment adds a segment in front of the
+// path, and drops the innermost one if the path
+// is full.
+func (p *errorPath) addPathSegment(seg string) {
+	if len(p.segs) == maxErrorPathSegments {
+		copy(p.segs, p.segs[1:])
+		p.segs = p.segs[:len(p.segs)-1]
+		p.elided++
+	}
+	p.segs = append(p.segs, seg)
+}
+
+// A pathError is an error that records the
+// location of the value that caused it.
+type pathError interface {
+	error
+	addPathSegment(seg string)
+}
+
+// withKeyPath adds the key of an object's member
+// to the path of err, if it records one.
+func withKeyPath(err error, key string) error {
+	if pe, ok := err.(pathError); ok {
+		pe.addPathSegment(keySegment(key))
+	}
+	return err
+}
+
+// withIndexPath adds the index of an array's
+// element to the path of err, if it records one.
+func withIndexPath(err error, i int) error {
+	if pe, ok := err.(pathError); ok {
+		pe.addPathSegment("[" + strconv.Itoa(i) + "]")
+	}
+	return err
+}
+
+// keySegment returns the path segment of a key.
+// The dot notation is used for keys that are
+// identifiers, and the bracket notation with a
+// quoted key for the others.
+func keySegment(key string) string {
+	if isPathIdent(key) {
+		return "." + key
+	}
+	return "[" + strconv.Quote(key) + "]"
+}
+
+func isPathIdent(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i != 0 && c >= '0' && c <= '9' {
+			continue
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,156 @@
+package jettison
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestKeySegment(t *testing.T) {
+	for _, tt := range []struct {
+		key string
+		seg string
+	}{
+		{"price", ".price"},
+		{"_id2", "._id2"},
+		{"2d", `["2d"]`},
+		{"a.b", `["a.b"]`},
+		{"", `[""]`},
+		{`"q"`, `["\"q\""]`},
+	} {
+		if seg := keySegment(tt.key); seg != tt.seg {
+			t.Errorf("%q: got %s, want %s", tt.key, seg, tt.seg)
+		}
+	}
+}
+
+type (
+	errPathItem struct {
+		Price float64 `json:"price"`
+	}
+	errPathOrder struct {
+		Items map[string][]errPathItem `json:"items"`
+	}
+)
+
+// TestErrorPath tests that the errors returned by the
+// encoder report the path of the value that caused it.
+func TestErrorPath(t *testing.T) {
+	items := map[string][]errPathItem{
+		"a":     {{1}},
+		"x.y":   {{1}, {math.NaN()}},
+		"other": {{2}},
+	}
+	orders := []errPathOrder{{}, {Items: items}}
+
+	var sm sync.Map
+	sm.Store("orders", orders)
+
+	for _, tt := range []struct {
+		v    interface{}
+		opts []Option
+		path string
+	}{
+		{math.Inf(1), nil, "$"},
+		{orders, nil, `$[1].items["x.y"][1].price`},
+		{orders, []Option{UnsortedMap()}, `$[1].items["x.y"][1].price`},
+		{map[int]interface{}{42: orders}, nil, `$["42"][1].items["x.y"][1].price`},
+		{map[int]interface{}{42: orders}, []Option{UnsortedMap()}, `$["42"][1].items["x.y"][1].price`},
+		{&sm, nil, `$.orders[1].items["x.y"][1].price`},
+		{&sm, []Option{UnsortedMap()}, `$.orders[1].items["x.y"][1].price`},
+	} {
+		_, err := MarshalOpts(tt.v, tt.opts...)
+		if err == nil {
+			t.Errorf("%T: expected non-nil error", tt.v)
+			continue
+		}
+		uve, ok := err.(*UnsupportedValueError)
+		if !ok {
+			t.Errorf("%T: got %T, want UnsupportedValueError", tt.v, err)
+			continue
+		}
+		if p := uve.Path(); p != tt.path {
+			t.Errorf("%T: got path %s, want %s", tt.v, p, tt.path)
+		}
+	}
+}
+
+func TestErrorPathTruncated(t *testing.T) {
+	var v interface{} = math.NaN()
+	for i := 0; i < 2*maxErrorPathSegments; i++ {
+		v = []interface{}{v}
+	}
+	_, err := Marshal(v)
+	uve, ok := err.(*UnsupportedValueError)
+	if !ok {
+		t.Fatalf("got %T, want UnsupportedValueError", err)
+	}
+	want := "$" + strings.Repeat("[0]", maxErrorPathSegments) + "..."
+	if p := uve.Path(); p != want {
+		t.Errorf("got path %s, want %s", p, want)
+	}
+}
+
+func TestErrorPathMessage(t *testing.T) {
+	_, err := Marshal(errPathOrder{
+		Items: map[string][]errPathItem{"a": {{math.NaN()}}},
+	})
+	if err == nil {
+		t.Fatal("expected non-nil error")
+	}
+	const want = "json: unsupported value: NaN at $.items.a[0].price"
+	if s := err.Error(); s != want {
+		t.Errorf("got %q, want %q", s, want)
+	}
+}
+
+type errPathMarshaler struct{ fail bool }
+
+func (m errPathMarshaler) MarshalJSON() ([]byte, error) {
+	if m.fail {
+		return nil, errors.New("failed")
+	}
+	return []byte("true"), nil
+}
+
+func TestMarshalerErrorPath(t *testing.T) {
+	type line struct {
+		Check errPathMarshaler `json:"check"`
+	}
+	lines := make([]line, 5000)
+	lines[4217].Check.fail = true
+
+	for _, tt := range []struct {
+		v    interface{}
+		path string
+		msg  string
+	}{
+		{
+			errPathMarshaler{true},
+			"$",
+			"json: error calling MarshalJSON for type jettison.errPathMarshaler: failed",
+		},
+		{
+			map[string]interface{}{"lines": lines},
+			"$.lines[4217].check",
+			"json: error calling MarshalJSON for type jettison.errPathMarshaler at $.lines[4217].check: failed",
+		},
+	} {
+		_, err := Marshal(tt.v)
+		if err == nil {
+			t.Fatal("expected non-nil error")
+		}
+		me, ok := err.(*MarshalerError)
+		if !ok {
+			t.Fatalf("got %T, want MarshalerError", err)
+		}
+		if p := me.Path(); p != tt.path {
+			t.Errorf("got path %s, want %s", p, tt.path)
+		}
+		if s := me.Error(); s != tt.msg {
+			t.Errorf("got %q, want %q", s, tt.msg)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"reflect"
 	"strconv"
 	"time"
 
@@ -72,6 +73,109 @@ func ExampleAppendOpts() {
 	// "2s"
 }
 
+func ExampleEncoder() {
+	enc, err := jettison.NewEncoder(
+		jettison.DurationFormat(jettison.DurationString),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Derive a second encoder that also
+	// omits the field named "id".
+	noid, err := enc.With(jettison.DenyList([]string{"id"}))
+	if err != nil {
+		log.Fatal(err)
+	}
+	type X struct {
+		ID  int           `json:"id"`
+		TTL time.Duration `json:"ttl"`
+	}
+	x := X{ID: 42, TTL: 90 * time.Second}
+
+	for _, e := range []*jettison.Encoder{enc, noid} {
+		b, err := e.Marshal(x)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s\n", string(b))
+	}
+	// Output:
+	// {"id":42,"ttl":"1m30s"}
+	// {"ttl":"1m30s"}
+}
+
+func ExampleStreamEncoder() {
+	enc, err := jettison.NewStreamEncoder(os.Stdout, jettison.NoHTMLEscaping())
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, v := range []interface{}{
+		map[string]string{"a": "<b>"},
+		[]int{1, 2, 3},
+		nil,
+	} {
+		if err := enc.Encode(v); err != nil {
+			log.Fatal(err)
+		}
+	}
+	// Output:
+	// {"a":"<b>"}
+	// [1,2,3]
+	// null
+}
+
+func ExampleIndent() {
+	type X struct {
+		A string         `json:"a"`
+		B []int          `json:"b"`
+		C map[string]int `json:"c"`
+	}
+	x := X{
+		A: "Loreum",
+		B: []int{1, 2},
+		C: map[string]int{},
+	}
+	b, err := jettison.MarshalOpts(x, jettison.Indent("", "  "))
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Stdout.Write(b)
+	// Output:
+	// {
+	//   "a": "Loreum",
+	//   "b": [
+	//     1,
+	//     2
+	//   ],
+	//   "c": {}
+	// }
+}
+
+func ExampleTypedEncoder() {
+	type Event struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+	enc, err := jettison.NewTypedEncoder[Event]()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var buf []byte
+	for _, ev := range []Event{
+		{1, "start"},
+		{2, "stop"},
+	} {
+		buf, err = enc.Append(buf[:0], &ev)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s\n", string(buf))
+	}
+	// Output:
+	// {"id":1,"name":"start"}
+	// {"id":2,"name":"stop"}
+}
+
 type Animal int
 
 const (
@@ -409,3 +513,120 @@ func ExampleWithContext() {
 	// "v3ryS3nSitiv3P4ssWord"
 	// "**__SECRET__**"
 }
+
+func ExampleAllowPaths() {
+	type Address struct {
+		Street string `json:"street"`
+		City   string `json:"city"`
+	}
+	type User struct {
+		ID      int     `json:"id"`
+		Name    string  `json:"name"`
+		Address Address `json:"address"`
+	}
+	type X struct {
+		ID    int    `json:"id"`
+		User  User   `json:"user"`
+		Users []User `json:"users"`
+	}
+	u := User{
+		ID:      1,
+		Name:    "Loreum",
+		Address: Address{"Ipsum", "Dolor"},
+	}
+	x := X{ID: 42, User: u, Users: []User{u}}
+
+	for _, opt := range []jettison.Option{
+		jettison.AllowPaths([]string{"id", "user.address.city", "users.name"}),
+		jettison.DenyPaths([]string{"user", "*.address"}),
+	} {
+		b, err := jettison.MarshalOpts(x, opt)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s\n", string(b))
+	}
+	// Output:
+	// {"id":42,"user":{"address":{"city":"Dolor"}},"users":[{"name":"Loreum"}]}
+	// {"id":42,"users":[{"id":1,"name":"Loreum"}]}
+}
+
+func ExampleFields() {
+	type Author struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	}
+	type Article struct {
+		ID      int      `json:"id"`
+		Title   string   `json:"title"`
+		Authors []Author `json:"authors"`
+		Tags    []string `json:"tags"`
+	}
+	a := Article{
+		ID:      42,
+		Title:   "Loreum",
+		Authors: []Author{{"Ipsum", "ipsum@dolor.com"}},
+		Tags:    []string{"sit", "amet"},
+	}
+	for _, expr := range []string{
+		"id,authors(name),tags",
+		"id,authors(phone)",
+	} {
+		b, err := jettison.MarshalOpts(a, jettison.Fields(expr))
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		fmt.Printf("%s\n", string(b))
+	}
+	// Output:
+	// {"id":42,"authors":[{"name":"Ipsum"}],"tags":["sit","amet"]}
+	// json: unknown field "authors.phone" selected for type jettison_test.Article
+}
+
+func ExampleFieldNaming() {
+	type User struct {
+		UserID    int
+		FirstName string
+		Nickname  string `json:"nick"`
+	}
+	u := User{42, "Loreum", "ipsum"}
+
+	for _, np := range []*jettison.NamingPolicy{
+		jettison.SnakeCase,
+		jettison.CamelCase,
+	} {
+		b, err := jettison.MarshalOpts(u, jettison.FieldNaming(np))
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s\n", string(b))
+	}
+	// Output:
+	// {"user_id":42,"first_name":"Loreum","nick":"ipsum"}
+	// {"userID":42,"firstName":"Loreum","nick":"ipsum"}
+}
+
+type Celsius float64
+
+func ExampleRegisterEncoder() {
+	typ := reflect.TypeOf(Celsius(0))
+
+	jettison.RegisterEncoder(typ, func(_ context.Context, dst []byte, v interface{}) ([]byte, error) {
+		dst = append(dst, '"')
+		dst = strconv.AppendFloat(dst, float64(v.(Celsius)), 'f', 1, 64)
+		return append(dst, "°C\""...), nil
+	})
+	defer jettison.RegisterEncoder(typ, nil)
+
+	b, err := jettison.Marshal(map[string]Celsius{
+		"paris":  21.5,
+		"berlin": 18,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Stdout.Write(b)
+	// Output:
+	// {"berlin":"18.0°C","paris":"21.5°C"}
+}
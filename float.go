@@ -0,0 +1,74 @@
+package jettison
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strconv"
+	"unsafe"
+)
+
+// NonFiniteFmt represents the format used to
+// encode the NaN and infinite floating-point
+// numbers, which have no JSON representation.
+type NonFiniteFmt int
+
+// NonFiniteFmt constants.
+const (
+	NonFiniteError  NonFiniteFmt = iota // default
+	NonFiniteNull                       // null
+	NonFiniteString                     // "NaN", "Infinity", "-Infinity"
+	NonFiniteOmit                       // omit the struct field, null otherwise
+)
+
+// String implements the fmt.Stringer
+// interface for NonFiniteFmt.
+func (f NonFiniteFmt) String() string {
+	if !f.valid() {
+		return "unknown"
+	}
+	return nonFiniteFmtStr[f]
+}
+
+func (f NonFiniteFmt) valid() bool {
+	return f >= NonFiniteError && f <= NonFiniteOmit
+}
+
+var nonFiniteFmtStr = []string{"error", "null", "string", "omit"}
+
+// errOmitValue is returned by the instructions of
+// the values that must be omitted from the output.
+// The struct fields that hold such a value are not
+// encoded, and other values are replaced by null.
+// It never reaches the caller.
+var errOmitValue = errors.New("omit value")
+
+// newFixedFloatInstr returns an instruction that
+// encodes the floats of type t with prec decimals.
+func newFixedFloatInstr(t reflect.Type, prec int, quoted bool) instruction {
+	if t.Kind() == reflect.Float32 {
+		return func(p unsafe.Pointer, dst []byte, opts encOpts) ([]byte, error) {
+			return appendFixedFloat(dst, float64(*(*float32)(p)), 32, prec, quoted, opts)
+		}
+	}
+	return func(p unsafe.Pointer, dst []byte, opts encOpts) ([]byte, error) {
+		return appendFixedFloat(dst, *(*float64)(p), 64, prec, quoted, opts)
+	}
+}
+
+// appendFixedFloat appends the textual representation
+// of f with prec decimals to dst, without exponent.
+// The trailing zeros are kept.
+func appendFixedFloat(dst []byte, f float64, bs, prec int, quoted bool, opts encOpts) ([]byte, error) {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return appendNonFiniteFloat(dst, f, bs, opts)
+	}
+	if quoted {
+		dst = append(dst, '"')
+	}
+	dst = strconv.AppendFloat(dst, f, 'f', prec, bs)
+	if quoted {
+		dst = append(dst, '"')
+	}
+	return dst, nil
+}
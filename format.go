@@ -0,0 +1,447 @@
+package jettison
+
+import (
+	"encoding/binary"
+	"unicode/utf8"
+)
+
+// Valid reports whether data is a valid JSON
+// encoding, like the json.Valid function.
+func Valid(data []byte) bool {
+	return checkValid(data) == nil
+}
+
+// AppendCompact appends to dst the JSON-encoded src
+// with the insignificant space characters elided, like
+// the json.Compact function. The strings are escaped
+// like the output of the MarshalJSON methods by the
+// encoder: the HTML characters and the U+2028 and U+2029
+// characters are escaped, unless the NoHTMLEscaping option
+// is given, and the invalid UTF-8 bytes are replaced with
+// the Unicode replacement rune, unless the NoUTF8Coercion
+// option is given. With the Canonical option, the canonical
+// form of src is appended instead. The other options are
+// ignored. If src is not valid, a SyntaxError is returned,
+// along with dst unchanged.
+func AppendCompact(dst, src []byte, opts ...Option) ([]byte, error) {
+	eo, err := formatOpts(opts)
+	if err != nil {
+		return dst, err
+	}
+	if eo.flags.has(canonical) {
+		return appendCanonicalJSON(dst, src)
+	}
+	return appendCompactJSON(dst, src, !eo.flags.has(noHTMLEscaping), !eo.flags.has(noUTF8Coercion))
+}
+
+// AppendIndent appends to dst an indented form of the
+// JSON-encoded src, like the json.Indent function. Each
+// element of an object or array begins on a new line
+// starting with prefix, followed by one or more copies
+// of indent according to the nesting depth. The first
+// line doesn't start with prefix, and the space characters
+// that surround src are dropped. The strings are escaped
+// like AppendCompact does, with the same options. If src
+// is not valid, a SyntaxError is returned, along with dst
+// unchanged.
+func AppendIndent(dst, src []byte, prefix, indent string, opts ...Option) ([]byte, error) {
+	eo, err := formatOpts(opts)
+	if err != nil {
+		return dst, err
+	}
+	eo.apply(func(o *encOpts) {
+		cfg := o.config()
+		cfg.prefix, cfg.indent = prefix, indent
+	})
+
+	return appendFormattedJSON(dst, src, !eo.flags.has(noHTMLEscaping), !eo.flags.has(noUTF8Coercion), &eo)
+}
+
+// AppendHTMLEscape appends to dst the JSON-encoded src
+// with the <, >, & characters, and the U+2028 and U+2029
+// characters, inside string literals escaped, like the
+// json.HTMLEscape function, so that the JSON is safe to
+// embed inside an HTML <script> tag. Like its equivalent,
+// it doesn't validate src.
+func AppendHTMLEscape(dst, src []byte) []byte {
+	return appendEscapedJSON(dst, src, true, false)
+}
+
+// formatOpts returns the options of the functions that
+// reformat JSON, or an InvalidOptionError if the options
+// are invalid.
+func formatOpts(opts []Option) (encOpts, error) {
+	eo := defaultEncOpts()
+	if len(opts) == 0 {
+		return eo, nil
+	}
+	eo.apply(opts...)
+	if err := eo.validate(); err != nil {
+		return eo, &InvalidOptionError{err}
+	}
+	return eo, nil
+}
+
+// formatMode is the operation done by formatJSON.
+type formatMode int
+
+const (
+	formatValid formatMode = iota
+	formatCompact
+	formatIndent
+)
+
+// Classes of the bytes of the string literals.
+const (
+	strPlain   = iota // a byte copied as is
+	strSpecial        // a byte that may have to be escaped, such as <, or the start of a multibyte sequence
+	strQuote          // the closing quote
+	strEscape         // the start of an escape sequence
+	strControl        // a control character, which is invalid
+)
+
+// strClass maps the bytes of the string literals
+// to their class.
+var strClass = func() (t [256]uint8) {
+	for c := 0; c < ' '; c++ {
+		t[c] = strControl
+	}
+	for c := utf8.RuneSelf; c < len(t); c++ {
+		t[c] = strSpecial
+	}
+	t['<'], t['>'], t['&'] = strSpecial, strSpecial, strSpecial
+	t['"'], t['\\'] = strQuote, strEscape
+	return t
+}()
+
+// formatJSON validates the JSON-encoded src, and appends
+// it to dst with the insignificant space characters elided,
+// unless mode is formatValid. With formatIndent, each element
+// of an object or array is placed on a new line, indented
+// according to the options ind. The strings are escaped by
+// appendEscapedJSON according to escHTML and coerce.
+//
+// It is a dedicated loop over the bytes of src, which is
+// faster than the Scanner, but doesn't report the errors:
+// if src is not a single valid JSON value, it returns false,
+// and the Scanner must be used to find the SyntaxError.
+func formatJSON(dst, src []byte, mode formatMode, escHTML, coerce bool, ind *encOpts) ([]byte, bool) {
+	var (
+		n      = len(src)
+		i      = 0 // offset of the next byte to read
+		at     = 0 // offset of the first byte not appended yet
+		depth  = 0
+		objs   [maxNestingDepth/64 + 1]uint64 // bit set of the objects opened
+		obj    = false                        // whether the innermost value opened is an object
+		write  = mode != formatValid
+		indent = mode == formatIndent
+		esc    = write && (escHTML || coerce)
+		html   = write && escHTML
+		lines  indenter
+	)
+	if indent {
+		lines = newIndenter(ind)
+	}
+	key := false // whether a key precedes the next value
+	for {
+		if i < n && src[i] <= ' ' {
+			dst, i, at = elideSpaces(dst, src, i, at, write)
+		}
+		if key {
+			if i == n || src[i] != '"' {
+				return dst, false
+			}
+			start := i
+			end, special, ok := formatString(src, i, html)
+			if !ok {
+				return dst, false
+			}
+			i = end
+			if special && esc {
+				dst = append(dst, src[at:start]...)
+				dst = appendEscapedJSON(dst, src[start:i], escHTML, coerce)
+				at = i
+			}
+			if i < n && src[i] <= ' ' {
+				dst, i, at = elideSpaces(dst, src, i, at, write)
+			}
+			if i == n || src[i] != ':' {
+				return dst, false
+			}
+			i++
+			if indent {
+				dst = append(dst, src[at:i]...)
+				dst = append(dst, ' ')
+				at = i
+			}
+			if i < n && src[i] <= ' ' {
+				dst, i, at = elideSpaces(dst, src, i, at, write)
+			}
+		}
+		if i == n {
+			return dst, false
+		}
+		switch c := src[i]; c {
+		case '{', '[':
+			if depth == maxNestingDepth {
+				return dst, false
+			}
+			obj = c == '{'
+			if obj {
+				objs[depth>>6] |= 1 << (depth & 63)
+			} else {
+				objs[depth>>6] &^= 1 << (depth & 63)
+			}
+			depth++
+			i++
+			if i < n && src[i] <= ' ' {
+				dst, i, at = elideSpaces(dst, src, i, at, write)
+			}
+			// The empty objects and arrays are
+			// left on a single line.
+			if i == n || src[i] != c+2 {
+				if indent {
+					dst = append(dst, src[at:i]...)
+					dst = lines.appendNewline(dst, depth)
+					at = i
+				}
+				key = obj
+				continue
+			}
+			i++
+			depth--
+			obj = depth > 0 && objs[(depth-1)>>6]&(1<<((depth-1)&63)) != 0
+		case '"':
+			start := i
+			end, special, ok := formatString(src, i, html)
+			if !ok {
+				return dst, false
+			}
+			i = end
+			if special && esc {
+				dst = append(dst, src[at:start]...)
+				dst = appendEscapedJSON(dst, src[start:i], escHTML, coerce)
+				at = i
+			}
+		case 't':
+			if n-i < 4 || string(src[i:i+4]) != "true" {
+				return dst, false
+			}
+			i += 4
+		case 'f':
+			if n-i < 5 || string(src[i:i+5]) != "false" {
+				return dst, false
+			}
+			i += 5
+		case 'n':
+			if n-i < 4 || string(src[i:i+4]) != "null" {
+				return dst, false
+			}
+			i += 4
+		default:
+			if c-'1' < 9 {
+				// Fast path of the positive integers.
+				j := i + 1
+				for j < n && src[j]-'0' < 10 {
+					j++
+				}
+				if j == n || src[j] != '.' && src[j] != 'e' && src[j] != 'E' {
+					i = j
+					break
+				}
+			} else if !isNumberStart(c) {
+				return dst, false
+			}
+			end, err := scanNumber(src, i)
+			if err != nil {
+				return dst, false
+			}
+			i = end
+		}
+		// After a value, either the input ends, or a
+		// comma precedes the next value, or the object
+		// or array that holds the value ends.
+		for {
+			if i < n && src[i] <= ' ' {
+				dst, i, at = elideSpaces(dst, src, i, at, write)
+			}
+			if depth == 0 {
+				if i != n {
+					return dst, false
+				}
+				if write {
+					dst = append(dst, src[at:]...)
+				}
+				return dst, true
+			}
+			if i == n {
+				return dst, false
+			}
+			c := src[i]
+			if c == ',' {
+				i++
+				if indent {
+					dst = append(dst, src[at:i]...)
+					dst = lines.appendNewline(dst, depth)
+					at = i
+				}
+				key = obj
+				break
+			}
+			if obj && c != '}' || !obj && c != ']' {
+				return dst, false
+			}
+			if indent {
+				dst = append(dst, src[at:i]...)
+				dst = lines.appendNewline(dst, depth-1)
+				at = i
+			}
+			i++
+			depth--
+			obj = depth > 0 && objs[(depth-1)>>6]&(1<<((depth-1)&63)) != 0
+		}
+	}
+}
+
+// An indenter appends the newlines that start the lines
+// of formatJSON, followed by their indentation.
+type indenter struct {
+	prefix string
+	indent string
+	depth  int // nesting depth of the formatted value
+}
+
+func newIndenter(ind *encOpts) indenter {
+	return indenter{
+		prefix: ind.prefix,
+		indent: ind.indent,
+		depth:  ind.depth,
+	}
+}
+
+// appendNewline appends to dst a newline followed by
+// the prefix and the indentation of the given depth,
+// relative to the one of the formatted value.
+func (in *indenter) appendNewline(dst []byte, depth int) []byte {
+	dst = append(dst, '\n')
+	dst = append(dst, in.prefix...)
+
+	n := (in.depth + depth) * len(in.indent)
+	if n == 0 {
+		return dst
+	}
+	// Append the first copy of the indentation, and
+	// double the copies already appended until there
+	// are enough, rather than appending them one by one.
+	start := len(dst)
+	dst = append(dst, in.indent...)
+	for k := len(dst) - start; k < n; k = len(dst) - start {
+		if k > n-k {
+			k = n - k
+		}
+		dst = append(dst, dst[start:start+k]...)
+	}
+	return dst
+}
+
+// formatString validates the string literal that starts
+// at the offset i of src, and returns the offset of its
+// end, and whether it has bytes that may have to be
+// escaped, including the HTML characters if html is true.
+func formatString(src []byte, i int, html bool) (end int, special, ok bool) {
+	n := len(src)
+	for i++; ; i++ {
+		// Skip the plain bytes, 8 at a time
+		// while none of them is special.
+		for n-i >= 8 && !hasSpecialByte(binary.LittleEndian.Uint64(src[i:]), html) {
+			i += 8
+		}
+		for i < n && strClass[src[i]] == strPlain {
+			i++
+		}
+		if i == n {
+			return i, special, false
+		}
+		switch strClass[src[i]] {
+		case strQuote:
+			return i + 1, special, true
+		case strSpecial:
+			special = true
+		case strControl:
+			return i, special, false
+		case strEscape:
+			if i++; i == n {
+				return i, special, false
+			}
+			switch src[i] {
+			case '"', '\\', '/', 'b', 'f', 'n', 'r', 't':
+			case 'u':
+				if n-i <= 4 || !isHexDigit(src[i+1]) || !isHexDigit(src[i+2]) ||
+					!isHexDigit(src[i+3]) || !isHexDigit(src[i+4]) {
+					return i, special, false
+				}
+				i += 4
+			default:
+				return i, special, false
+			}
+		}
+	}
+}
+
+// elideSpaces skips the space characters at the offset
+// i of src, and returns the new offset. If write is true,
+// the bytes of src from at that precede them are appended
+// to dst, and the offset that follows them is returned as
+// the new at, so that they are elided.
+func elideSpaces(dst, src []byte, i, at int, write bool) ([]byte, int, int) {
+	j := skipSpaces(src, i)
+	if write && j != i {
+		dst = append(dst, src[at:i]...)
+		at = j
+	}
+	return dst, j, at
+}
+
+// skipSpaces is similar to skipSpace, but faster
+// on the runs of spaces that indent the lines of
+// the inputs formatted for humans.
+func skipSpaces(data []byte, off int) int {
+	for {
+		for len(data)-off >= 8 && binary.LittleEndian.Uint64(data[off:]) == 0x2020202020202020 {
+			off += 8
+		}
+		if off == len(data) || !isSpace[data[off]] {
+			return off
+		}
+		off++
+	}
+}
+
+var isSpace = [256]bool{' ': true, '\t': true, '\n': true, '\r': true}
+
+const (
+	lsb = 0x0101010101010101
+	msb = 0x8080808080808080
+)
+
+// hasSpecialByte returns whether one of the 8 bytes
+// of the string literal in x isn't a plain byte, as
+// classified by strClass, or one of the HTML characters
+// if html is true. It may report false positives after
+// the first byte that isn't plain.
+func hasSpecialByte(x uint64, html bool) bool {
+	m := x - lsb*' ' | hasZeroByte(x^lsb*'"') | hasZeroByte(x^lsb*'\\')
+	if html {
+		m |= hasZeroByte(x^lsb*'<') | hasZeroByte(x^lsb*'>') | hasZeroByte(x^lsb*'&')
+	}
+	// The bytes above 0x7f, and the ones below 0x20,
+	// which wrap around, have their high bit set.
+	return (m|x)&msb != 0
+}
+
+// hasZeroByte returns a word with the high bit of
+// the zero bytes of x set, and possibly of the bytes
+// that follow them.
+func hasZeroByte(x uint64) uint64 {
+	return (x - lsb) &^ x
+}
@@ -0,0 +1,181 @@
+package jettison
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+var formatTestdata = []string{
+	``,
+	` `,
+	`null`,
+	` true `,
+	`-1.5e+10`,
+	`"a\"b\\cé😀"`,
+	`"<script>&amp;</script>"`,
+	"\"\u2028 \u2029\"",
+	`{}`,
+	`[ ]`,
+	`{ "a" : [ 1 , 2 , { } , [ ] ] ,
+	  "b<" : { "c" : null } }`,
+	"[\n\t\"x\",\r\n\t{\"y\": [[], {}]}\n]",
+	`{"a":1,}`,
+	`[1 2]`,
+	`{"a" 1}`,
+	`"abc`,
+	`nul`,
+	`01`,
+	`1 2`,
+	`[1,`,
+	`{"\x01":1}`,
+}
+
+func TestValid(t *testing.T) {
+	for _, data := range formatTestdata {
+		if got, want := Valid([]byte(data)), json.Valid([]byte(data)); got != want {
+			t.Errorf("%q: got %t, want %t", data, got, want)
+		}
+	}
+}
+
+// formatTestErrors maps the invalid inputs of the
+// formatTestdata to the message and the offset of
+// the SyntaxError reported by the formatting.
+var formatTestErrors = map[string]struct {
+	msg    string
+	offset int64
+}{
+	``:           {"unexpected end of JSON input", 0},
+	` `:          {"unexpected end of JSON input", 1},
+	`{"a":1,}`:   {"invalid character '}' looking for beginning of object key string", 8},
+	`[1 2]`:      {"invalid character '2' after array element", 4},
+	`{"a" 1}`:    {"invalid character '1' after object key", 6},
+	`"abc`:       {"unexpected end of JSON input", 4},
+	`nul`:        {"unexpected end of JSON input", 3},
+	`01`:         {"invalid character '1' after top-level value", 2},
+	`1 2`:        {"invalid character '2' after top-level value", 3},
+	`[1,`:        {"unexpected end of JSON input", 3},
+	`{"\x01":1}`: {"invalid character 'x' in string escape code", 4},
+}
+
+// checkFormatError checks that the error err of the
+// formatting of data is the one expected, and returns
+// whether data is valid.
+func checkFormatError(t *testing.T, data string, err error) bool {
+	t.Helper()
+
+	want, ok := formatTestErrors[data]
+	if !ok {
+		if err != nil {
+			t.Errorf("%q: %s", data, err)
+		}
+		return true
+	}
+	var e *SyntaxError
+	if !errors.As(err, &e) {
+		t.Errorf("%q: got error %v, want SyntaxError", data, err)
+	} else if e.Error() != want.msg || e.Offset != want.offset {
+		t.Errorf("%q: got message %q at offset %d, want %q at offset %d",
+			data, e.Error(), e.Offset, want.msg, want.offset)
+	}
+	return false
+}
+
+func TestAppendCompact(t *testing.T) {
+	for _, data := range formatTestdata {
+		// The options disable the escaping of the
+		// strings, which json.Compact doesn't do.
+		b, err := AppendCompact([]byte("x"), []byte(data), NoHTMLEscaping(), NoUTF8Coercion())
+		if !checkFormatError(t, data, err) {
+			if string(b) != "x" {
+				t.Errorf("%q: got %q, want dst unchanged", data, b)
+			}
+			continue
+		}
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		if string(b[1:]) != buf.String() {
+			t.Errorf("%q: got %q, want %q", data, b[1:], buf.String())
+		}
+		// By default, the strings are escaped
+		// like json.HTMLEscape does.
+		var esc bytes.Buffer
+		json.HTMLEscape(&esc, buf.Bytes())
+
+		b, err = AppendCompact(nil, []byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != esc.String() {
+			t.Errorf("%q: got %q, want %q", data, b, esc.String())
+		}
+	}
+}
+
+func TestAppendCompactOptions(t *testing.T) {
+	src := []byte("{\"b\":\"\xff<\", \"a\": 1.0}")
+
+	for _, tt := range []struct {
+		opts []Option
+		want string
+	}{
+		{nil, `{"b":"\ufffd\u003c","a":1.0}`},
+		{[]Option{NoHTMLEscaping()}, `{"b":"\ufffd<","a":1.0}`},
+		{[]Option{NoUTF8Coercion()}, "{\"b\":\"\xff\\u003c\",\"a\":1.0}"},
+		{[]Option{Canonical()}, `{"a":1,"b":"�<"}`},
+	} {
+		b, err := AppendCompact(nil, src, tt.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tt.want {
+			t.Errorf("got %q, want %q", b, tt.want)
+		}
+	}
+	_, err := AppendCompact(nil, src, TimeLayout(""))
+	if _, ok := err.(*InvalidOptionError); !ok {
+		t.Errorf("got %T, want InvalidOptionError", err)
+	}
+}
+
+func TestAppendIndent(t *testing.T) {
+	for _, data := range formatTestdata {
+		for _, ind := range []struct{ prefix, indent string }{
+			{"", "  "},
+			{">", "\t"},
+		} {
+			b, err := AppendIndent([]byte("x"), []byte(data), ind.prefix, ind.indent, NoHTMLEscaping(), NoUTF8Coercion())
+			if !checkFormatError(t, data, err) {
+				if string(b) != "x" {
+					t.Errorf("%q: got %q, want dst unchanged", data, b)
+				}
+				continue
+			}
+			var buf bytes.Buffer
+			if err := json.Indent(&buf, []byte(data), ind.prefix, ind.indent); err != nil {
+				t.Fatal(err)
+			}
+			// Unlike json.Indent, the trailing
+			// space characters are dropped.
+			want := bytes.TrimRight(buf.Bytes(), " \t\r\n")
+			if string(b[1:]) != string(want) {
+				t.Errorf("%q: got %q, want %q", data, b[1:], want)
+			}
+		}
+	}
+}
+
+func TestAppendHTMLEscape(t *testing.T) {
+	for _, data := range append(formatTestdata, "\"\xff\xe2\x80\"") {
+		var buf bytes.Buffer
+		json.HTMLEscape(&buf, []byte(data))
+
+		if b := AppendHTMLEscape([]byte("x"), []byte(data)); string(b[1:]) != buf.String() {
+			t.Errorf("%q: got %q, want %q", data, b[1:], buf.String())
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package jettison
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type indentm struct{ out string }
+
+func (m indentm) AppendJSON(dst []byte) ([]byte, error) {
+	if m.out != "" {
+		return append(dst, m.out...), nil
+	}
+	return append(dst, `{"a":[1,{}],"b":"{,:}"}`...), nil
+}
+
+// TestIndent tests that the output of an encoder
+// configured with the Indent option is identical
+// to the one of the json.MarshalIndent function.
+func TestIndent(t *testing.T) {
+	type e struct{}
+	testdata := []interface{}{
+		xx,
+		&xx,
+		[]int{},
+		[]int{1, 2, 3},
+		[][]string{{"a"}, {}, nil},
+		map[string]interface{}{},
+		map[string]interface{}{
+			"a": []interface{}{1, "b", map[string]int{}},
+			"c": map[string]bool{"d": true},
+			"e": json.RawMessage(` { "f" : [ 1, 2 ], "g":{ } } `),
+		},
+		struct{}{},
+		e{},
+		struct {
+			A []e
+			B *int
+			C json.RawMessage
+		}{
+			A: []e{{}, {}},
+			C: json.RawMessage(`[]`),
+		},
+	}
+	for _, prefix := range []string{"", ">"} {
+		for _, indent := range []string{"", "\t", "  "} {
+			for _, v := range testdata {
+				b1, err := MarshalOpts(v, Indent(prefix, indent))
+				if err != nil {
+					t.Fatal(err)
+				}
+				b2, err := json.MarshalIndent(v, prefix, indent)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(b1, b2) {
+					t.Errorf("non-equal outputs:\n%s\n%s", b1, b2)
+				}
+			}
+		}
+	}
+}
+
+func TestIndentUnsortedMap(t *testing.T) {
+	m := map[string][]int{"a": {1}}
+
+	b, err := MarshalOpts(m, Indent("", " "), UnsortedMap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, want := string(b), "{\n \"a\": [\n  1\n ]\n}"; s != want {
+		t.Errorf("got %q, want %q", s, want)
+	}
+}
+
+func TestIndentSyncMap(t *testing.T) {
+	var sm sync.Map
+
+	for _, opt := range []Option{nil, UnsortedMap()} {
+		b, err := MarshalOpts(&sm, Indent("", " "), opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := string(b); s != "{}" {
+			t.Errorf("got %q, want {}", s)
+		}
+	}
+	sm.Store("a", []int{1})
+
+	for _, opt := range []Option{nil, UnsortedMap()} {
+		b, err := MarshalOpts(struct{ M *sync.Map }{&sm}, Indent("", " "), opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "{\n \"M\": {\n  \"a\": [\n   1\n  ]\n }\n}"
+		if s := string(b); s != want {
+			t.Errorf("got %q, want %q", s, want)
+		}
+	}
+}
+
+func TestIndentAppendMarshaler(t *testing.T) {
+	// The output of the marshalers is compacted
+	// before being indented, and its strings are
+	// left as is.
+	b, err := MarshalOpts([]indentm{{}, {"{ \"a\" : [1,\n {} ] ,\t\"b\":\"{,:}\" }"}}, Indent("", "\t"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		`[`,
+		`	{`,
+		`		"a": [`,
+		`			1,`,
+		`			{}`,
+		`		],`,
+		`		"b": "{,:}"`,
+		`	},`,
+		`	{`,
+		`		"a": [`,
+		`			1,`,
+		`			{}`,
+		`		],`,
+		`		"b": "{,:}"`,
+		`	}`,
+		`]`,
+	}, "\n")
+	if s := string(b); s != want {
+		t.Errorf("got %q, want %q", s, want)
+	}
+	// An invalid output is reported.
+	_, err = MarshalOpts(indentm{`{"a":}`}, Indent("", "\t"))
+
+	var e *SyntaxError
+	if _, ok := err.(*MarshalerError); !ok || !errors.As(err, &e) {
+		t.Errorf("got %v, want MarshalerError wrapping a SyntaxError", err)
+	}
+}
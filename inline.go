@@ -0,0 +1,99 @@
+package jettison
+
+import (
+	"reflect"
+	"sync"
+	"unsafe"
+)
+
+// An inlineInstr appends the entries of an inline map
+// field to dst, as members of the enclosing object. The
+// first member is preceded by the delimiter nxt, and the
+// delimiter of the next member is returned.
+type inlineInstr func(p unsafe.Pointer, dst []byte, opts encOpts, nxt byte) ([]byte, byte, error)
+
+// isInlineMapType returns whether the fields of type
+// t can be inlined, which is the case of the maps with
+// string keys and of the sync.Map type.
+func isInlineMapType(t reflect.Type) bool {
+	if t == syncMapType {
+		return true
+	}
+	return t.Kind() == reflect.Map && isString(t.Key())
+}
+
+// hasInlineField returns whether one of the
+// fields of a struct is an inline map.
+func hasInlineField(flds []field) bool {
+	for i := range flds {
+		if flds[i].inline {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldNames returns the set of names of the fields
+// of a struct, excluding its inline map.
+func fieldNames(flds []field) stringSet {
+	names := make(stringSet, len(flds))
+	for i := range flds {
+		if !flds[i].inline {
+			names[flds[i].name] = struct{}{}
+		}
+	}
+	return names
+}
+
+// skipInlineKey returns whether the entry of an inline
+// map with the given key must be skipped, because the
+// key is the name of one of the fields of the struct in
+// names, which have precedence even if omitted, or is
+// denied by the field lists of the options.
+func skipInlineKey(key string, names stringSet, opts encOpts) bool {
+	if _, ok := names[key]; ok {
+		return true
+	}
+	return opts.isDeniedField(key)
+}
+
+// newInlineMapInstr returns an instruction that encodes
+// the entries of a map of type t, or sync.Map, inlined
+// in a struct whose fields have the given names.
+func (c *compiler) newInlineMapInstr(t reflect.Type, names stringSet) inlineInstr {
+	var ins instruction
+
+	if t == syncMapType {
+		ins = func(p unsafe.Pointer, dst []byte, opts encOpts) ([]byte, error) {
+			return encodeSyncMapElems((*sync.Map)(p), dst, opts, names)
+		}
+	} else {
+		vi := c.newInstruction(t.Elem(), false, false)
+
+		ins = func(p unsafe.Pointer, dst []byte, opts encOpts) ([]byte, error) {
+			m := *(*unsafe.Pointer)(p)
+			if m == nil {
+				return dst, nil
+			}
+			ml := maplen(m)
+			if ml == 0 {
+				return dst, nil
+			}
+			return encodeMapElems(m, dst, opts, t, encodeString, vi, ml, true, names)
+		}
+	}
+	return func(p unsafe.Pointer, dst []byte, opts encOpts, nxt byte) ([]byte, byte, error) {
+		// The entries are comma-separated, which
+		// leaves room for the first delimiter only.
+		off := len(dst)
+		dst, err := ins(p, append(dst, nxt), opts)
+		if err != nil {
+			return dst, nxt, err
+		}
+		if len(dst) == off+1 {
+			// No entries, or all of them skipped.
+			return dst[:off], nxt, nil
+		}
+		return dst, ',', nil
+	}
+}
@@ -0,0 +1,220 @@
+package jettison
+
+import (
+	"math"
+	"sync"
+	"testing"
+)
+
+func TestInlineMap(t *testing.T) {
+	type (
+		x struct {
+			A     int                    `json:"a"`
+			Extra map[string]interface{} `json:",inline"`
+			B     string                 `json:"b,omitempty"`
+		}
+		y struct {
+			M map[string]int `json:"m,inline"`
+			A int            `json:"a,omitempty"`
+		}
+		z struct {
+			M map[int]string `json:",inline"`
+		}
+		e struct{ y }
+		p struct{ *y }
+		d struct {
+			y
+			N map[string]int `json:",inline"`
+		}
+		a struct {
+			M1 map[string]int `json:",inline"`
+			M2 map[string]int `json:",inline"`
+		}
+	)
+	extra := map[string]interface{}{"z": 1, "a": 2, "b": "extra", "c": nil}
+
+	for _, tt := range []struct {
+		name string
+		v    interface{}
+		opts []Option
+		want string
+	}{
+		{
+			"fields precedence",
+			x{A: 1, Extra: extra},
+			nil,
+			`{"a":1,"c":null,"z":1}`,
+		},
+		{
+			"position",
+			x{A: 1, Extra: map[string]interface{}{"c": true}, B: "b"},
+			nil,
+			`{"a":1,"c":true,"b":"b"}`,
+		},
+		{"nil map", x{A: 1}, nil, `{"a":1}`},
+		{"first field", y{M: map[string]int{"b": 2, "c": 3}, A: 1}, nil, `{"b":2,"c":3,"a":1}`},
+		{"first field skipped", y{M: map[string]int{"a": 2}}, nil, `{}`},
+		{"empty", y{M: map[string]int{}}, nil, `{}`},
+		{"non-string keys", z{M: map[int]string{1: "a"}}, nil, `{"M":{"1":"a"}}`},
+		{"embedded", e{y{M: map[string]int{"b": 2}, A: 1}}, nil, `{"b":2,"a":1}`},
+		{"nil embedded pointer", p{}, nil, `{}`},
+		{"dominant", d{y: y{M: map[string]int{"b": 2}}, N: map[string]int{"c": 3}}, nil, `{"c":3}`},
+		{"ambiguous", a{M1: map[string]int{"a": 1}, M2: map[string]int{"b": 2}}, nil, `{}`},
+		{
+			"unsorted",
+			x{A: 1, Extra: map[string]interface{}{"c": true}},
+			[]Option{UnsortedMap()},
+			`{"a":1,"c":true}`,
+		},
+		{
+			"deny list",
+			x{A: 1, Extra: extra},
+			[]Option{DenyList([]string{"z", "a"})},
+			`{"c":null}`,
+		},
+		{
+			"allow list",
+			x{A: 1, Extra: extra},
+			[]Option{AllowList([]string{"z", "b"})},
+			`{"z":1}`,
+		},
+		{
+			"paths",
+			x{A: 1, Extra: map[string]interface{}{"c": map[string]int{"d": 1, "e": 2}, "f": 3}},
+			[]Option{AllowPaths([]string{"a", "c.e"})},
+			`{"a":1,"c":{"e":2}}`,
+		},
+		{
+			"indent",
+			x{A: 1, Extra: map[string]interface{}{"c": true}, B: "b"},
+			[]Option{Indent("", "  ")},
+			"{\n  \"a\": 1,\n  \"c\": true,\n  \"b\": \"b\"\n}",
+		},
+		{
+			"canonical",
+			x{A: 1, Extra: map[string]interface{}{"c": true, "0": false}, B: "b"},
+			[]Option{Canonical()},
+			`{"0":false,"a":1,"b":"b","c":true}`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := MarshalOpts(tt.v, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s := string(b); s != tt.want {
+				t.Errorf("got %s, want %s", s, tt.want)
+			}
+		})
+	}
+}
+
+func TestInlineSyncMap(t *testing.T) {
+	type x struct {
+		A int      `json:"a"`
+		M sync.Map `json:",inline"`
+	}
+	v := &x{A: 1}
+	v.M.Store("c", 3)
+	v.M.Store("b", 2)
+	v.M.Store("a", 0)
+	v.M.Store(4, "d")
+
+	b, err := MarshalOpts(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, want := string(b), `{"a":1,"4":"d","b":2,"c":3}`; s != want {
+		t.Errorf("got %s, want %s", s, want)
+	}
+}
+
+func TestInlineMapError(t *testing.T) {
+	type x struct {
+		A int                `json:"a"`
+		M map[string]float64 `json:",inline"`
+	}
+	_, err := MarshalOpts(x{M: map[string]float64{"nan": math.NaN()}})
+	e, ok := err.(*UnsupportedValueError)
+	if !ok {
+		t.Fatalf("got %T, want UnsupportedValueError", err)
+	}
+	if p := e.Path(); p != "$.nan" {
+		t.Errorf("got path %s, want $.nan", p)
+	}
+}
+
+func TestInlineStruct(t *testing.T) {
+	type (
+		address struct {
+			Street string `json:"street"`
+			City   string `json:"city,omitempty"`
+		}
+		user struct {
+			Name    string  `json:"name"`
+			Address address `json:"address,inline"`
+		}
+		prefixed struct {
+			Home address  `json:",inline,prefix=home_"`
+			Work *address `json:",inline,prefix=work_"`
+		}
+		nested struct {
+			P prefixed `json:",inline,prefix=p_"`
+		}
+		embedded struct {
+			address `json:",prefix=addr_"`
+		}
+		info struct {
+			Name string `json:"name"`
+			Age  int    `json:"age"`
+		}
+		dominant struct {
+			Name string `json:"name"`
+			Info info   `json:",inline"`
+		}
+		other struct {
+			Age int `json:"age"`
+		}
+		conflict struct {
+			Info  info  `json:",inline"`
+			Other other `json:",inline"`
+		}
+		node struct {
+			V    int   `json:"v"`
+			Next *node `json:",inline,prefix=n_"`
+		}
+		withMap struct {
+			A address        `json:",inline,prefix=a_"`
+			M map[string]int `json:",inline"`
+		}
+		nonStruct struct {
+			S []int `json:"s,inline"`
+		}
+	)
+	for _, tt := range []struct {
+		name string
+		v    interface{}
+		want string
+	}{
+		{"named", user{Name: "a", Address: address{Street: "s", City: "c"}}, `{"name":"a","street":"s","city":"c"}`},
+		{"prefix", prefixed{Home: address{Street: "s"}}, `{"home_street":"s"}`},
+		{"pointer", prefixed{Work: &address{City: "c"}}, `{"home_street":"","work_street":"","work_city":"c"}`},
+		{"nested prefix", nested{P: prefixed{Home: address{City: "c"}}}, `{"p_home_street":"","p_home_city":"c"}`},
+		{"embedded prefix", embedded{address{Street: "s"}}, `{"addr_street":"s"}`},
+		{"dominant", dominant{Name: "a", Info: info{Name: "b", Age: 1}}, `{"name":"a","age":1}`},
+		{"conflict", conflict{Info: info{Name: "b", Age: 1}, Other: other{Age: 2}}, `{"name":"b"}`},
+		{"cyclic", node{V: 1, Next: &node{V: 2}}, `{"v":1,"Next":{"v":2,"Next":null}}`},
+		{"inline map", withMap{A: address{Street: "s"}, M: map[string]int{"a_street": 1, "street": 2}}, `{"a_street":"s","street":2}`},
+		{"non-struct", nonStruct{S: []int{1}}, `{"s":[1]}`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := MarshalOpts(tt.v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s := string(b); s != tt.want {
+				t.Errorf("got %s, want %s", s, tt.want)
+			}
+		})
+	}
+}
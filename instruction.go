@@ -9,8 +9,8 @@ import (
 )
 
 var (
-	instrCachePtr    unsafe.Pointer // *instrCache
-	structInstrCache sync.Map       // map[string]instruction
+	instrSets       sync.Map // map[instrSetKey]*instrSet
+	defaultInstrSet = loadInstrSet(instrSetKey{floatPrec: -1})
 )
 
 // An instruction appends the JSON representation
@@ -24,16 +24,104 @@ type instruction func(unsafe.Pointer, []byte, encOpts) ([]byte, error)
 // reflect.Type to improve lookup performance.
 type instrCache map[unsafe.Pointer]instruction
 
+// instrSetKey represents the settings that affect the
+// generation of instructions, as opposed to the other
+// options, which are applied at runtime.
+type instrSetKey struct {
+	naming *NamingPolicy
+
+	// floatPrec is the number of decimals of the
+	// floats, or -1 for the shortest representation.
+	floatPrec int
+	int64Fmt  Int64Fmt
+
+	// canonical indicates whether the output is in
+	// the canonical form defined by RFC 8785.
+	canonical bool
+}
+
+// An instrSet holds the compiler that generates the
+// instructions to encode Go types according to a set
+// of settings. The compiler is replaced when the type
+// encoders registered change, which invalidates the
+// instructions generated by the previous one. The set
+// also caches the instructions to decode Go types.
+type instrSet struct {
+	instrSetKey
+	ptr       unsafe.Pointer // *compiler
+	decInstrs sync.Map       // map[reflect.Type]decInstr
+}
+
+// A compiler generates the instructions to encode Go
+// types according to a set of settings and a snapshot
+// of the type encoders registered, and caches the
+// instructions and struct fields metadata generated.
+type compiler struct {
+	instrSetKey
+
+	encoders         unsafe.Pointer // *typeEncoders
+	instrCachePtr    unsafe.Pointer // *instrCache
+	structInstrCache sync.Map       // map[string]instruction
+	fieldsCache      sync.Map       // map[reflect.Type][]field
+}
+
+// loadInstrSet returns the instruction set for the
+// given settings, or create one on the fly. The sets
+// are never released, since the settings are expected
+// to be few and long-lived.
+func loadInstrSet(key instrSetKey) *instrSet {
+	if s, ok := instrSets.Load(key); ok {
+		return s.(*instrSet)
+	}
+	s, _ := instrSets.LoadOrStore(key, &instrSet{instrSetKey: key})
+	return s.(*instrSet)
+}
+
+// compiler returns the current compiler of the set,
+// or create a new one if none exists yet, or if the
+// type encoders registered have changed since.
+func (s *instrSet) compiler() *compiler {
+	enc := atomic.LoadPointer(&encodersPtr)
+
+	p := atomic.LoadPointer(&s.ptr)
+	if p != nil && (*compiler)(p).encoders == enc {
+		return (*compiler)(p)
+	}
+	c := &compiler{
+		instrSetKey: s.instrSetKey,
+		encoders:    enc,
+	}
+	if !atomic.CompareAndSwapPointer(&s.ptr, p, unsafe.Pointer(c)) {
+		// Another goroutine replaced the
+		// compiler concurrently.
+		return s.compiler()
+	}
+	return c
+}
+
+// cachedInstr returns an instruction to encode the
+// given type using the current compiler of the set.
+func (s *instrSet) cachedInstr(t reflect.Type) instruction {
+	return s.compiler().cachedInstr(t)
+}
+
+// cachedFields returns the list of fields to encode
+// for the struct type t using the current compiler
+// of the set.
+func (s *instrSet) cachedFields(t reflect.Type) []field {
+	return s.compiler().cachedFields(t)
+}
+
 func typeID(t reflect.Type) unsafe.Pointer {
 	return unpackEface(t).word
 }
 
 // cachedInstr returns an instruction to encode the
 // given type from a cache, or create one on the fly.
-func cachedInstr(t reflect.Type) instruction {
+func (c *compiler) cachedInstr(t reflect.Type) instruction {
 	id := typeID(t)
 
-	if instr, ok := loadInstr(id); ok {
+	if instr, ok := c.loadInstr(id); ok {
 		return instr
 	}
 	canAddr := t.Kind() == reflect.Ptr
@@ -42,27 +130,27 @@ func cachedInstr(t reflect.Type) instruction {
 	// At this point, we only need to know if the value is
 	// a pointer, the others instructions will handle that
 	// themselves for their type, or pass-by the value.
-	instr := newInstruction(t, canAddr, false)
+	instr := c.newInstruction(t, canAddr, false)
 	if isInlined(t) {
 		instr = wrapInlineInstr(instr)
 	}
-	storeInstr(id, instr, loadCache())
+	c.storeInstr(id, instr, c.loadCache())
 
 	return instr
 }
 
-func loadCache() instrCache {
-	p := atomic.LoadPointer(&instrCachePtr)
+func (c *compiler) loadCache() instrCache {
+	p := atomic.LoadPointer(&c.instrCachePtr)
 	return *(*instrCache)(unsafe.Pointer(&p))
 }
 
-func loadInstr(id unsafe.Pointer) (instruction, bool) {
-	cache := loadCache()
+func (c *compiler) loadInstr(id unsafe.Pointer) (instruction, bool) {
+	cache := c.loadCache()
 	instr, ok := cache[id]
 	return instr, ok
 }
 
-func storeInstr(key unsafe.Pointer, instr instruction, cache instrCache) {
+func (c *compiler) storeInstr(key unsafe.Pointer, instr instruction, cache instrCache) {
 	newCache := make(instrCache, len(cache)+1)
 
 	// Clone the current cache and add the
@@ -73,7 +161,7 @@ func storeInstr(key unsafe.Pointer, instr instruction, cache instrCache) {
 	newCache[key] = instr
 
 	atomic.StorePointer(
-		&instrCachePtr,
+		&c.instrCachePtr,
 		*(*unsafe.Pointer)(unsafe.Pointer(&newCache)),
 	)
 }
@@ -82,7 +170,21 @@ func storeInstr(key unsafe.Pointer, instr instruction, cache instrCache) {
 // canAddr and quoted respectively indicates if the
 // value to encode is addressable and must be enclosed
 // with double-quote character in the output.
-func newInstruction(t reflect.Type, canAddr, quoted bool) instruction {
+func (c *compiler) newInstruction(t reflect.Type, canAddr, quoted bool) instruction {
+	// Registered encoders have precedence over all
+	// the other instructions, to be able to override
+	// the encoding of any type.
+	if fn := c.encoder(t); fn != nil {
+		return newEncoderFuncInstr(t, fn)
+	}
+	// A pointer to a type with a registered encoder
+	// must not use the methods of the marshalers it
+	// may implement, which would be promoted from
+	// the element type, and neither must a pointer
+	// to a big number.
+	if t.Kind() == reflect.Ptr && (c.encoder(t.Elem()) != nil || isBigNumber(t.Elem())) {
+		return c.newPtrInstr(t, quoted)
+	}
 	// Go types must be checked first, because a Duration
 	// is an int64, json.Number is a string, and both would
 	// be interpreted as a basic type. Also, the time.Time
@@ -94,22 +196,41 @@ func newInstruction(t reflect.Type, canAddr, quoted bool) instruction {
 	if ins := newMarshalerTypeInstr(t, canAddr); ins != nil {
 		return ins
 	}
+	if c.canonical && isFloatingPoint(t) {
+		return newCanonicalFloatInstr(t, quoted)
+	}
+	if c.floatPrec >= 0 && isFloatingPoint(t) {
+		return newFixedFloatInstr(t, c.floatPrec, quoted)
+	}
+	// The quoted integers are already safe,
+	// regardless of the int64 format.
+	if !quoted && isInteger(t) && t.Size() == 8 {
+		switch {
+		case c.int64Fmt != Int64Number:
+			return newInt64Instr(t, c.int64Fmt)
+		case c.canonical:
+			return newCanonicalInt64Instr(t)
+		}
+	}
 	if ins := newBasicTypeInstr(t, quoted); ins != nil {
 		return ins
 	}
 	switch t.Kind() {
 	case reflect.Interface:
+		if t.NumMethod() != 0 {
+			return encodeMethodsInterface
+		}
 		return encodeInterface
 	case reflect.Struct:
-		return newStructInstr(t, canAddr)
+		return c.newStructInstr(t, canAddr)
 	case reflect.Map:
-		return newMapInstr(t)
+		return c.newMapInstr(t)
 	case reflect.Slice:
-		return newSliceInstr(t)
+		return c.newSliceInstr(t)
 	case reflect.Array:
-		return newArrayInstr(t, canAddr)
+		return c.newArrayInstr(t, canAddr)
 	case reflect.Ptr:
-		return newPtrInstr(t, quoted)
+		return c.newPtrInstr(t, quoted)
 	}
 	return newUnsupportedTypeInstr(t)
 }
@@ -126,6 +247,12 @@ func newGoTypeInstr(t reflect.Type) instruction {
 		return encodeNumber
 	case jsonRawMessageType:
 		return encodeRawMessage
+	case bigIntType:
+		return encodeBigInt
+	case bigFloatType:
+		return encodeBigFloat
+	case bigRatType:
+		return encodeBigRat
 	default:
 		return nil
 	}
@@ -191,8 +318,14 @@ func newBasicTypeInstr(t reflect.Type, quoted bool) instruction {
 	case reflect.Uintptr:
 		ins = encodeUintptr
 	case reflect.Float32:
+		if quoted {
+			return encodeQuotedFloat32
+		}
 		ins = encodeFloat32
 	case reflect.Float64:
+		if quoted {
+			return encodeQuotedFloat64
+		}
 		ins = encodeFloat64
 	default:
 		return nil
@@ -203,6 +336,33 @@ func newBasicTypeInstr(t reflect.Type, quoted bool) instruction {
 	return ins
 }
 
+// newNumberFieldInstr returns an instruction to encode
+// a struct field of type t, or a pointer to it, with the
+// precision or scale of its tag's options. It returns
+// nil if the options don't apply to the type, because
+// it isn't a float or an integer, or because its values
+// are encoded by a marshaler or a registered encoder.
+func (c *compiler) newNumberFieldInstr(t reflect.Type, canAddr, quoted bool, prec, scale int) instruction {
+	if c.encoder(t) != nil || newGoTypeInstr(t) != nil || newMarshalerTypeInstr(t, canAddr) != nil {
+		return nil
+	}
+	switch {
+	case t.Kind() == reflect.Ptr:
+		i := c.newNumberFieldInstr(t.Elem(), true, quoted, prec, scale)
+		if i == nil {
+			return nil
+		}
+		return func(p unsafe.Pointer, dst []byte, opts encOpts) ([]byte, error) {
+			return encodePointer(p, dst, opts, t, i)
+		}
+	case prec >= 0 && isFloatingPoint(t):
+		return newFixedFloatInstr(t, prec, quoted)
+	case scale > 0 && isInteger(t):
+		return newScaledIntInstr(t, scale, quoted)
+	}
+	return nil
+}
+
 func newStringInstr(quoted bool) instruction {
 	if quoted {
 		return encodeQuotedString
@@ -216,11 +376,11 @@ func newUnsupportedTypeInstr(t reflect.Type) instruction {
 	}
 }
 
-func newPtrInstr(t reflect.Type, quoted bool) instruction {
+func (c *compiler) newPtrInstr(t reflect.Type, quoted bool) instruction {
 	e := t.Elem()
-	i := newInstruction(e, true, quoted)
+	i := c.newInstruction(e, true, quoted)
 	return func(p unsafe.Pointer, dst []byte, opts encOpts) ([]byte, error) {
-		return encodePointer(p, dst, opts, i)
+		return encodePointer(p, dst, opts, t, i)
 	}
 }
 
@@ -248,10 +408,10 @@ func newTextMarshalerInstr(t reflect.Type, hasPtr bool) instruction {
 	}
 }
 
-func newStructInstr(t reflect.Type, canAddr bool) instruction {
+func (c *compiler) newStructInstr(t reflect.Type, canAddr bool) instruction {
 	id := fmt.Sprintf("%p-%t", typeID(t), canAddr)
 
-	if instr, ok := structInstrCache.Load(id); ok {
+	if instr, ok := c.structInstrCache.Load(id); ok {
 		return instr.(instruction)
 	}
 	// To deal with recursive types, populate the
@@ -265,7 +425,7 @@ func newStructInstr(t reflect.Type, canAddr bool) instruction {
 		ins instruction
 	)
 	wg.Add(1)
-	i, loaded := structInstrCache.LoadOrStore(id,
+	i, loaded := c.structInstrCache.LoadOrStore(id,
 		instruction(func(p unsafe.Pointer, dst []byte, opts encOpts) ([]byte, error) {
 			wg.Wait() // few ns/op overhead
 			return ins(p, dst, opts)
@@ -276,14 +436,14 @@ func newStructInstr(t reflect.Type, canAddr bool) instruction {
 	}
 	// Generate the real instruction and replace
 	// the indirect func with it.
-	ins = newStructFieldsInstr(t, canAddr)
+	ins = c.newStructFieldsInstr(t, canAddr)
 	wg.Done()
-	structInstrCache.Store(id, ins)
+	c.structInstrCache.Store(id, ins)
 
 	return ins
 }
 
-func newStructFieldsInstr(t reflect.Type, canAddr bool) instruction {
+func (c *compiler) newStructFieldsInstr(t reflect.Type, canAddr bool) instruction {
 	if t.NumField() == 0 {
 		// Fast path for empty struct.
 		return func(_ unsafe.Pointer, dst []byte, opts encOpts) ([]byte, error) {
@@ -291,14 +451,24 @@ func newStructFieldsInstr(t reflect.Type, canAddr bool) instruction {
 		}
 	}
 	var (
-		flds = cachedFields(t)
+		flds = c.cachedFields(t)
 		dupl = append(flds[:0:0], flds...) // clone
+		inln = hasInlineField(flds)
 	)
+	if err := fieldsTagError(flds); err != nil {
+		return func(_ unsafe.Pointer, dst []byte, _ encOpts) ([]byte, error) {
+			return dst, err
+		}
+	}
 	for i := range dupl {
 		f := &dupl[i]
 		ftyp := typeByIndex(t, f.index)
 		etyp := ftyp
 
+		if f.inline {
+			f.inlineInstr = c.newInlineMapInstr(ftyp, fieldNames(flds))
+			continue
+		}
 		if etyp.Kind() == reflect.Ptr {
 			etyp = etyp.Elem()
 		}
@@ -313,17 +483,44 @@ func newStructFieldsInstr(t reflect.Type, canAddr bool) instruction {
 		// Generate instruction and empty func of the field.
 		// Only strings, floats, integers, and booleans
 		// types can be quoted.
-		f.instr = newInstruction(ftyp, canAddr, f.quoted && isBasicType(etyp))
+		f.instr = c.newNumberFieldInstr(ftyp, canAddr, f.quoted && isBasicType(etyp), f.precision, f.scale)
+		if f.instr != nil && c.canonical {
+			// The number is rounded, or scaled, but
+			// serialized as an ECMAScript number.
+			f.instr = wrapCanonicalNumberInstr(f.instr)
+		}
+		if f.instr == nil {
+			f.instr = c.newInstruction(ftyp, canAddr, f.quoted && isBasicType(etyp))
+		}
 		if f.omitEmpty {
 			f.empty = cachedEmptyFuncOf(ftyp)
 		}
+		if f.omitZero {
+			f.zero = cachedZeroFuncOf(ftyp)
+		}
+	}
+	if c.canonical {
+		sortCanonical(dupl)
+
+		if inln {
+			// The entries of the inline map must be
+			// sorted with the fields of the struct.
+			return func(p unsafe.Pointer, dst []byte, opts encOpts) ([]byte, error) {
+				off := len(dst)
+				dst, err := encodeStruct(p, dst, opts, dupl)
+				if err != nil {
+					return dst, err
+				}
+				return canonicalizeAppended(dst, off)
+			}
+		}
 	}
 	return func(p unsafe.Pointer, dst []byte, opts encOpts) ([]byte, error) {
 		return encodeStruct(p, dst, opts, dupl)
 	}
 }
 
-func newArrayInstr(t reflect.Type, canAddr bool) instruction {
+func (c *compiler) newArrayInstr(t reflect.Type, canAddr bool) instruction {
 	var (
 		etyp = t.Elem()
 		size = etyp.Size()
@@ -331,7 +528,7 @@ func newArrayInstr(t reflect.Type, canAddr bool) instruction {
 	)
 	// Array elements are addressable if the
 	// array itself is addressable.
-	ins := newInstruction(etyp, canAddr, false)
+	ins := c.newInstruction(etyp, canAddr, false)
 
 	// Byte arrays does not encode as a string
 	// by default, this behavior is defined by
@@ -347,7 +544,7 @@ func newArrayInstr(t reflect.Type, canAddr bool) instruction {
 	}
 }
 
-func newSliceInstr(t reflect.Type) instruction {
+func (c *compiler) newSliceInstr(t reflect.Type) instruction {
 	etyp := t.Elem()
 
 	if etyp.Kind() == reflect.Uint8 {
@@ -360,15 +557,15 @@ func newSliceInstr(t reflect.Type) instruction {
 	// see https://golang.org/pkg/reflect/#Value.CanAddr
 	// for reference.
 	var (
-		ins  = newInstruction(etyp, true, false)
+		ins  = c.newInstruction(etyp, true, false)
 		size = etyp.Size()
 	)
 	return func(p unsafe.Pointer, dst []byte, opts encOpts) ([]byte, error) {
-		return encodeSlice(p, dst, opts, ins, size)
+		return encodeSlice(p, dst, opts, t, ins, size)
 	}
 }
 
-func newMapInstr(t reflect.Type) instruction {
+func (c *compiler) newMapInstr(t reflect.Type) instruction {
 	var (
 		ki instruction
 		vi instruction
@@ -383,10 +580,21 @@ func newMapInstr(t reflect.Type) instruction {
 	// for map key types, defined by the documentation of
 	// the json.Marshal function. That's why we bypass the
 	// newTypeInstr function if key type is string.
-	if isString(kt) {
+	// The keys must be encoded as JSON strings, which
+	// the output of the marshalers and the registered
+	// encoders can't be trusted to be, so the keys of
+	// other types use the TextMarshaler interface, or
+	// are formatted as integers, like the standard
+	// library does.
+	switch {
+	case isString(kt):
 		ki = encodeString
-	} else {
-		ki = newInstruction(kt, false, false)
+	case kt.Implements(textMarshalerType):
+		ki = newTextMarshalerInstr(kt, false)
+	default:
+		// The integer keys are quoted below, and
+		// must not be affected by the int64 format.
+		ki = newBasicTypeInstr(kt, false)
 	}
 	// Wrap the key instruction for types that
 	// do not encode with quotes by default.
@@ -397,10 +605,11 @@ func newMapInstr(t reflect.Type) instruction {
 	if kt.Implements(textMarshalerType) && kt.Kind() == reflect.Ptr {
 		ki = wrapTextMarshalerNilCheck(ki)
 	}
-	vi = newInstruction(et, false, false)
+	vi = c.newInstruction(et, false, false)
+	sk := isString(kt)
 
 	return func(p unsafe.Pointer, dst []byte, opts encOpts) ([]byte, error) {
-		return encodeMap(p, dst, opts, t, ki, vi)
+		return encodeMap(p, dst, opts, t, ki, vi, sk)
 	}
 }
 
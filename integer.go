@@ -1,10 +1,44 @@
 package jettison
 
 import (
+	"reflect"
 	"strconv"
 	"unsafe"
 )
 
+// maxSafeInteger is the largest integer that can
+// be represented exactly by a JavaScript number,
+// which is a 64-bit floating-point number.
+const maxSafeInteger = 1<<53 - 1
+
+// Int64Fmt represents the format used to encode
+// the 64-bit integers, which can't be represented
+// exactly by the JavaScript numbers when their
+// magnitude exceeds 2^53-1.
+type Int64Fmt int
+
+// Int64Fmt constants.
+const (
+	Int64Number         Int64Fmt = iota // default
+	Int64String                         // always quoted
+	Int64StringIfUnsafe                 // quoted if magnitude exceeds 2^53-1
+)
+
+// String implements the fmt.Stringer
+// interface for Int64Fmt.
+func (f Int64Fmt) String() string {
+	if !f.valid() {
+		return "unknown"
+	}
+	return int64FmtStr[f]
+}
+
+func (f Int64Fmt) valid() bool {
+	return f >= Int64Number && f <= Int64StringIfUnsafe
+}
+
+var int64FmtStr = []string{"number", "string", "string-if-unsafe"}
+
 // nolint:unparam
 func encodeInt(
 	p unsafe.Pointer, dst []byte, _ encOpts,
@@ -81,3 +115,129 @@ func encodeUintptr(
 ) ([]byte, error) {
 	return strconv.AppendUint(dst, uint64(*(*uintptr)(p)), 10), nil
 }
+
+// newScaledIntInstr returns an instruction that encodes
+// the integers of type t as the exact decimal numbers
+// they represent, divided by 10 to the power of scale.
+func newScaledIntInstr(t reflect.Type, scale int, quoted bool) instruction {
+	var load func(unsafe.Pointer) (uint64, bool)
+
+	switch t.Kind() {
+	case reflect.Int:
+		load = func(p unsafe.Pointer) (uint64, bool) { return absInt(int64(*(*int)(p))) }
+	case reflect.Int8:
+		load = func(p unsafe.Pointer) (uint64, bool) { return absInt(int64(*(*int8)(p))) }
+	case reflect.Int16:
+		load = func(p unsafe.Pointer) (uint64, bool) { return absInt(int64(*(*int16)(p))) }
+	case reflect.Int32:
+		load = func(p unsafe.Pointer) (uint64, bool) { return absInt(int64(*(*int32)(p))) }
+	case reflect.Int64:
+		load = func(p unsafe.Pointer) (uint64, bool) { return absInt(*(*int64)(p)) }
+	case reflect.Uint:
+		load = func(p unsafe.Pointer) (uint64, bool) { return uint64(*(*uint)(p)), false }
+	case reflect.Uint8:
+		load = func(p unsafe.Pointer) (uint64, bool) { return uint64(*(*uint8)(p)), false }
+	case reflect.Uint16:
+		load = func(p unsafe.Pointer) (uint64, bool) { return uint64(*(*uint16)(p)), false }
+	case reflect.Uint32:
+		load = func(p unsafe.Pointer) (uint64, bool) { return uint64(*(*uint32)(p)), false }
+	case reflect.Uint64:
+		load = func(p unsafe.Pointer) (uint64, bool) { return *(*uint64)(p), false }
+	case reflect.Uintptr:
+		load = func(p unsafe.Pointer) (uint64, bool) { return uint64(*(*uintptr)(p)), false }
+	default:
+		return nil
+	}
+	ins := func(p unsafe.Pointer, dst []byte, _ encOpts) ([]byte, error) {
+		u, neg := load(p)
+		return appendScaledInt(dst, u, neg, scale), nil
+	}
+	if quoted {
+		return wrapQuotedInstr(ins)
+	}
+	return ins
+}
+
+// absInt returns the absolute value of i,
+// and whether it is negative.
+func absInt(i int64) (uint64, bool) {
+	u := uint64(i)
+	if i < 0 {
+		u = -u
+	}
+	return u, i < 0
+}
+
+// appendScaledInt appends the decimal number u divided
+// by 10 to the power of scale to dst, with exactly scale
+// decimals. For example, 12345 with a scale of 2 is
+// appended as 123.45, and 5 as 0.05.
+func appendScaledInt(dst []byte, u uint64, neg bool, scale int) []byte {
+	if neg {
+		dst = append(dst, '-')
+	}
+	off := len(dst)
+	dst = strconv.AppendUint(dst, u, 10)
+
+	// Pad the digits with leading zeros,
+	// to have at least one integer digit.
+	if n := len(dst) - off; n <= scale {
+		pad := scale + 1 - n
+		for i := 0; i < pad; i++ {
+			dst = append(dst, '0')
+		}
+		copy(dst[off+pad:], dst[off:off+n])
+		for i := 0; i < pad; i++ {
+			dst[off+i] = '0'
+		}
+	}
+	// Insert the decimal point before
+	// the last scale digits.
+	dst = append(dst, 0)
+	end := len(dst) - 1
+	copy(dst[end-scale+1:], dst[end-scale:end])
+	dst[end-scale] = '.'
+
+	return dst
+}
+
+// newInt64Instr returns an instruction that encodes
+// the 64-bit integers of type t with the format f.
+func newInt64Instr(t reflect.Type, f Int64Fmt) instruction {
+	signed := t.Kind() >= reflect.Int && t.Kind() <= reflect.Int64
+
+	if f == Int64String {
+		if signed {
+			return func(p unsafe.Pointer, dst []byte, _ encOpts) ([]byte, error) {
+				dst = append(dst, '"')
+				dst = strconv.AppendInt(dst, *(*int64)(p), 10)
+				return append(dst, '"'), nil
+			}
+		}
+		return func(p unsafe.Pointer, dst []byte, _ encOpts) ([]byte, error) {
+			dst = append(dst, '"')
+			dst = strconv.AppendUint(dst, *(*uint64)(p), 10)
+			return append(dst, '"'), nil
+		}
+	}
+	if signed {
+		return func(p unsafe.Pointer, dst []byte, _ encOpts) ([]byte, error) {
+			i := *(*int64)(p)
+			if i > maxSafeInteger || i < -maxSafeInteger {
+				dst = append(dst, '"')
+				dst = strconv.AppendInt(dst, i, 10)
+				return append(dst, '"'), nil
+			}
+			return strconv.AppendInt(dst, i, 10), nil
+		}
+	}
+	return func(p unsafe.Pointer, dst []byte, _ encOpts) ([]byte, error) {
+		u := *(*uint64)(p)
+		if u > maxSafeInteger {
+			dst = append(dst, '"')
+			dst = strconv.AppendUint(dst, u, 10)
+			return append(dst, '"'), nil
+		}
+		return strconv.AppendUint(dst, u, 10), nil
+	}
+}
@@ -34,15 +34,25 @@ const (
 )
 
 // MarshalerError represents an error from calling
-// the methods MarshalJSON or MarshalText.
+// the methods MarshalJSON or MarshalText. Its Path
+// method returns the location of the value whose
+// method returned the error.
 type MarshalerError struct {
 	Type     reflect.Type
 	Err      error
 	funcName string
+
+	errorPath
 }
 
 // Error implements the builtin error interface.
+// The path of the value is included in the message
+// if it isn't the root value.
 func (e *MarshalerError) Error() string {
+	if !e.atRoot() {
+		return fmt.Sprintf("json: error calling %s for type %s at %s: %s",
+			e.funcName, e.Type, e.Path(), e.Err.Error())
+	}
 	return fmt.Sprintf("json: error calling %s for type %s: %s",
 		e.funcName, e.Type, e.Err.Error())
 }
@@ -67,35 +77,108 @@ func (e *UnsupportedTypeError) Error() string {
 	return fmt.Sprintf("json: unsupported type: %s", e.Type)
 }
 
+// A TagError is returned by the encoder and the decoder
+// when the JSON tag of a struct field has an invalid option,
+// such as a precision or a scale that is not an integer
+// between 0 and 255, or that is given to a field which is
+// not a float or an integer respectively.
+type TagError struct {
+	Type   reflect.Type // type of the struct that declares the field
+	Field  string       // Go name of the field
+	Option string       // option of the tag, such as "precision=abc"
+}
+
+// Error implements the builtin error interface.
+func (e *TagError) Error() string {
+	return fmt.Sprintf("json: invalid option %q in the tag of field %s of type %s",
+		e.Option, e.Field, e.Type)
+}
+
 // UnsupportedValueError is the error returned
 // by Marshal when attempting to encode an
-// unsupported value.
+// unsupported value, found at the location
+// returned by its Path method.
 type UnsupportedValueError struct {
 	Value reflect.Value
 	Str   string
+
+	errorPath
 }
 
 // Error implements the builtin error interface.
+// The path of the value is included in the message
+// if it isn't the root value.
 func (e *UnsupportedValueError) Error() string {
+	if !e.atRoot() {
+		return fmt.Sprintf("json: unsupported value: %s at %s", e.Str, e.Path())
+	}
 	return fmt.Sprintf("json: unsupported value: %s", e.Str)
 }
 
-// A SyntaxError is a description of a JSON syntax error.
-// Unlike its equivalent in the encoding/json package, the
-// Error method implemented does not return a meaningful
-// message, and the Offset field is always zero.
-// It is present merely for consistency.
+// A DepthError is returned by the encoder when the
+// nesting depth of the value to encode exceeds the
+// limit set with the MaxDepth option. Its Path method
+// returns the location of the object or array that
+// exceeds the limit.
+type DepthError struct {
+	MaxDepth int
+
+	errorPath
+}
+
+// Error implements the builtin error interface.
+func (e *DepthError) Error() string {
+	return fmt.Sprintf("json: exceeded max depth of %d at %s", e.MaxDepth, e.Path())
+}
+
+// A SizeError is returned by the encoder when the
+// size of the output exceeds the limit set with the
+// MaxBytes option. Its Path method returns the
+// location of the value that made the output exceed
+// the limit.
+type SizeError struct {
+	MaxBytes int
+
+	errorPath
+}
+
+// Error implements the builtin error interface.
+func (e *SizeError) Error() string {
+	return fmt.Sprintf("json: exceeded max size of %d bytes at %s", e.MaxBytes, e.Path())
+}
+
+// A SyntaxError is a description of a JSON syntax error,
+// found in the input of Unmarshal, a Decoder or a Scanner,
+// or in the output of a marshaler. The message names the
+// first invalid character and what was expected instead,
+// such as "invalid character '}' after object key", or
+// reports the unexpected end of the input, including in
+// the middle of a literal, a number or an escape sequence,
+// or the object or array that exceeds the maximum nesting
+// depth of 10000. The message and the position of an error
+// are the same for a given input, whichever function
+// reports it, but a Decoder returns io.ErrUnexpectedEOF
+// instead when its input ends in the middle of a value.
 type SyntaxError struct {
-	msg    string
+	msg string
+
+	// Offset is the number of bytes read
+	// before the error was detected.
 	Offset int64
+
+	// Line and Column are the 1-based position
+	// of the byte that causes the error, or of
+	// the end of the input. Columns are counted
+	// in bytes.
+	Line, Column int
 }
 
 // Error implements the builtin error interface.
 func (e *SyntaxError) Error() string { return e.msg }
 
 // InvalidOptionError is the error returned by
-// MarshalOpts when one of the given options is
-// invalid.
+// MarshalOpts and NewEncoder when one of the
+// given options is invalid.
 type InvalidOptionError struct {
 	Err error
 }
@@ -134,9 +217,9 @@ func MarshalOpts(v interface{}, opts ...Option) ([]byte, error) {
 	eo := defaultEncOpts()
 
 	if len(opts) != 0 {
-		(&eo).apply(opts...)
-		if err := eo.validate(); err != nil {
-			return nil, &InvalidOptionError{err}
+		var err error
+		if eo, err = eo.with(opts...); err != nil {
+			return nil, err
 		}
 	}
 	return marshalJSON(v, eo)
@@ -151,16 +234,22 @@ func AppendOpts(dst []byte, v interface{}, opts ...Option) ([]byte, error) {
 	eo := defaultEncOpts()
 
 	if len(opts) != 0 {
-		(&eo).apply(opts...)
-		if err := eo.validate(); err != nil {
-			return nil, &InvalidOptionError{err}
+		var err error
+		if eo, err = eo.with(opts...); err != nil {
+			return nil, err
 		}
 	}
 	return appendJSON(dst, v, eo)
 }
 
 func marshalJSON(v interface{}, opts encOpts) ([]byte, error) {
-	ins := cachedInstr(reflect.TypeOf(v))
+	typ := reflect.TypeOf(v)
+	if opts.selection != nil {
+		if err := opts.selection.validate(typ, opts.iset); err != nil {
+			return nil, err
+		}
+	}
+	ins := opts.iset.cachedInstr(typ)
 	buf := cachedBuffer()
 
 	var err error
@@ -170,6 +259,14 @@ func marshalJSON(v interface{}, opts encOpts) ([]byte, error) {
 	// the instruction has returned.
 	runtime.KeepAlive(v)
 
+	if err != nil {
+		buf.B, err = nullIfOmitted(buf.B, err)
+	}
+
+	if err == nil && opts.sizeExceeded(buf.B) {
+		err = &SizeError{MaxBytes: opts.maxBytes}
+	}
+
 	var b []byte
 	if err == nil {
 		// Make a copy of the buffer's content
@@ -183,10 +280,32 @@ func marshalJSON(v interface{}, opts encOpts) ([]byte, error) {
 }
 
 func appendJSON(dst []byte, v interface{}, opts encOpts) ([]byte, error) {
-	ins := cachedInstr(reflect.TypeOf(v))
+	typ := reflect.TypeOf(v)
+
+	// The field selection must be validated only
+	// against the type of the root value, and the
+	// size of the output counted from the start of
+	// the root value, but this function is also used
+	// to encode the values of a sync.Map.
+	root := opts.depth == 0
+	if root {
+		if opts.selection != nil {
+			if err := opts.selection.validate(typ, opts.iset); err != nil {
+				return dst, err
+			}
+		}
+		opts.sizeOff = -len(dst)
+	}
+	ins := opts.iset.cachedInstr(typ)
 	var err error
 	dst, err = ins(unpackEface(v).word, dst, opts)
 	runtime.KeepAlive(v)
 
+	if err != nil && root {
+		dst, err = nullIfOmitted(dst, err)
+	}
+	if err == nil && root && opts.sizeExceeded(dst) {
+		err = &SizeError{MaxBytes: opts.maxBytes}
+	}
 	return dst, err
 }
@@ -13,6 +13,7 @@ import (
 	"reflect"
 	"regexp"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -182,7 +183,14 @@ func TestInvalidEncodeOpts(t *testing.T) {
 		TimeLayout(""),
 		DurationFormat(DurationFmt(-1)),
 		DurationFormat(DurationFmt(6)),
+		NonFiniteFormat(NonFiniteFmt(-1)),
+		NonFiniteFormat(NonFiniteFmt(4)),
 		WithContext(nil), // nolint:staticcheck
+		MaxDepth(-1),
+		MaxBytes(-1),
+		FloatPrecision(256),
+		Int64Format(Int64Fmt(-1)),
+		Int64Format(Int64Fmt(3)),
 	} {
 		_, err1 := MarshalOpts(struct{}{}, opt)
 		_, err2 := AppendOpts([]byte(nil), struct{}{}, opt)
@@ -256,6 +264,27 @@ func TestCompositeTypes(t *testing.T) {
 	}
 }
 
+type methodsIface interface{ Method() }
+
+type methodsImpl struct{ A int }
+
+func (methodsImpl) Method() {}
+
+// TestMethodsInterface tests that values of interface
+// types with methods, which have a different memory
+// layout than the empty interface, are encoded.
+func TestMethodsInterface(t *testing.T) {
+	type x struct {
+		I  methodsIface
+		N  methodsIface
+		IS []methodsIface
+	}
+	marshalCompare(t, x{
+		I:  methodsImpl{1},
+		IS: []methodsIface{&methodsImpl{2}, nil},
+	}, "")
+}
+
 // TestUnsupportedTypes tests that marshaling an
 // unsupported type such as channel, complex, and
 // function value returns an UnsupportedTypeError.
@@ -301,6 +330,631 @@ func TestInvalidFloatValues(t *testing.T) {
 	}
 }
 
+// TestNonFiniteFormat tests the encoding of NaN
+// and infinite float values with each format.
+func TestNonFiniteFormat(t *testing.T) {
+	nan, inf := math.NaN(), math.Inf(1)
+	f32 := float32(math.Inf(-1))
+
+	type x struct {
+		A float64     `json:"a"`
+		B *float32    `json:"b"`
+		C interface{} `json:"c"`
+		D float64     `json:"d,string"`
+		E []float64   `json:"e"`
+		F float64     `json:"f"`
+	}
+	v := x{
+		A: nan,
+		B: &f32,
+		C: inf,
+		D: -inf,
+		E: []float64{1, nan},
+		F: 2,
+	}
+	m := map[string]float64{"b": nan}
+
+	for _, tt := range []struct {
+		format NonFiniteFmt
+		struc  string
+		mp     string
+		root   string
+	}{
+		{
+			NonFiniteNull,
+			`{"a":null,"b":null,"c":null,"d":null,"e":[1,null],"f":2}`,
+			`{"b":null}`,
+			`null`,
+		},
+		{
+			NonFiniteString,
+			`{"a":"NaN","b":"-Infinity","c":"Infinity","d":"-Infinity","e":[1,"NaN"],"f":2}`,
+			`{"b":"NaN"}`,
+			`"Infinity"`,
+		},
+		{
+			NonFiniteOmit,
+			`{"e":[1,null],"f":2}`,
+			`{"b":null}`,
+			`null`,
+		},
+	} {
+		opt := NonFiniteFormat(tt.format)
+
+		for _, c := range []struct {
+			v    interface{}
+			want string
+		}{
+			{v, tt.struc},
+			{m, tt.mp},
+			{inf, tt.root},
+		} {
+			for _, o := range [][]Option{{opt}, {opt, UnsortedMap()}} {
+				b, err := MarshalOpts(c.v, o...)
+				if err != nil {
+					t.Errorf("%s: %s", tt.format, err)
+					continue
+				}
+				if s := string(b); s != c.want {
+					t.Errorf("%s: got %s, want %s", tt.format, s, c.want)
+				}
+			}
+			b, err := AppendOpts([]byte("x"), c.v, opt)
+			if err != nil {
+				t.Errorf("%s: %s", tt.format, err)
+				continue
+			}
+			if s := string(b); s != "x"+c.want {
+				t.Errorf("%s: got %s, want x%s", tt.format, s, c.want)
+			}
+		}
+	}
+	// The duration formats that use
+	// floats are never non-finite, but
+	// the finite values are unaffected.
+	b, err := MarshalOpts(struct {
+		D time.Duration
+		F float32 `json:",string"`
+	}{time.Minute, 1.5},
+		DurationFormat(DurationSeconds), NonFiniteFormat(NonFiniteString),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"D":60,"F":"1.5"}`; string(b) != want {
+		t.Errorf("got %s, want %s", b, want)
+	}
+}
+
+// TestNonFiniteOmitFields tests that the omitted
+// fields leave a valid object, whatever their
+// position.
+func TestNonFiniteOmitFields(t *testing.T) {
+	nan := math.NaN()
+
+	for _, tt := range []struct {
+		v    interface{}
+		want string
+	}{
+		{struct{ A, B, C float64 }{nan, 1, 2}, `{"B":1,"C":2}`},
+		{struct{ A, B, C float64 }{1, nan, 2}, `{"A":1,"C":2}`},
+		{struct{ A, B, C float64 }{1, 2, nan}, `{"A":1,"B":2}`},
+		{struct{ A, B float64 }{nan, nan}, `{}`},
+		{struct {
+			A *float64 `json:"a,omitnil"`
+			B float64  `json:"b"`
+		}{nil, 1}, `{"b":1}`},
+	} {
+		b, err := MarshalOpts(tt.v, NonFiniteFormat(NonFiniteOmit))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := string(b); s != tt.want {
+			t.Errorf("got %s, want %s", s, tt.want)
+		}
+		b, err = MarshalOpts(tt.v, NonFiniteFormat(NonFiniteOmit), Indent("", "  "))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, []byte(tt.want), "", "  "); err != nil {
+			t.Fatal(err)
+		}
+		if s := string(b); s != buf.String() {
+			t.Errorf("got %s, want %s", s, buf.String())
+		}
+	}
+}
+
+type (
+	precFloat     float64
+	precMarshaler float64
+)
+
+func (m precMarshaler) MarshalJSON() ([]byte, error) {
+	return []byte(`"m"`), nil
+}
+
+// TestFloatPrecision tests the encoding of floats with
+// a fixed number of decimals, set globally or by the
+// precision option of a field's tag.
+func TestFloatPrecision(t *testing.T) {
+	f := 1.005
+	type x struct {
+		A float64       `json:"a"`
+		B float64       `json:"b,precision=2"`
+		C *float32      `json:"c,precision=1"`
+		D float64       `json:"d,string,precision=3"`
+		E precFloat     `json:"e,precision=0"`
+		F precMarshaler `json:"f,precision=2"`
+		G []float64     `json:"g"`
+		J float64       `json:"j,precision=2"`
+	}
+	f32 := float32(2.25)
+	v := x{
+		A: 3.14159,
+		B: 2.675,
+		C: &f32,
+		D: 1.5,
+		E: 12.5,
+		F: 1,
+		G: []float64{1, f},
+		J: 1e21,
+	}
+	for _, tt := range []struct {
+		opts []Option
+		want string
+	}{
+		{nil, `{"a":3.14159,"b":2.67,"c":2.2,"d":"1.500","e":12,"f":"m","g":[1,1.005],"j":1000000000000000000000.00}`},
+		{[]Option{FloatPrecision(1)}, `{"a":3.1,"b":2.67,"c":2.2,"d":"1.500","e":12,"f":"m","g":[1.0,1.0],"j":1000000000000000000000.00}`},
+		{[]Option{FloatPrecision(1), FloatPrecision(-5)}, `{"a":3.14159,"b":2.67,"c":2.2,"d":"1.500","e":12,"f":"m","g":[1,1.005],"j":1000000000000000000000.00}`},
+	} {
+		b, err := MarshalOpts(v, tt.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := string(b); s != tt.want {
+			t.Errorf("got %s, want %s", s, tt.want)
+		}
+		if !json.Valid(b) {
+			t.Errorf("invalid JSON: %s", b)
+		}
+	}
+	// The global precision applies to the floats
+	// of the interfaces and maps, and to the quoted
+	// fields, but not to the float durations.
+	b, err := MarshalOpts([]interface{}{
+		1.0 / 3,
+		map[string]float32{"a": 0.5},
+		struct {
+			F float64 `json:",string"`
+		}{2},
+		time.Second,
+		math.NaN(),
+	}, FloatPrecision(2), DurationFormat(DurationMinutes), NonFiniteFormat(NonFiniteNull))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[0.33,{"a":0.50},{"F":"2.00"},0.016666666666666666,null]`; string(b) != want {
+		t.Errorf("got %s, want %s", b, want)
+	}
+}
+
+// TestIntegerScale tests the encoding of integers
+// as exact decimal numbers with the scale option
+// of a field's tag.
+func TestIntegerScale(t *testing.T) {
+	i8 := int8(-5)
+	type x struct {
+		A int64         `json:"a,scale=2"`
+		B int           `json:"b,scale=2"`
+		C *int8         `json:"c,scale=3"`
+		D uint16        `json:"d,scale=1,string"`
+		E int64         `json:"e,scale=2"`
+		F uint64        `json:"f,scale=4"`
+		G int           `json:"g,scale=0"`
+		I time.Duration `json:"i,scale=2"`
+		J int64         `json:"j,scale=20"`
+		K *int          `json:"k,scale=2"`
+	}
+	v := x{
+		A: 12345,
+		B: -7,
+		C: &i8,
+		D: 10,
+		E: math.MinInt64,
+		F: math.MaxUint64,
+		G: 100,
+		I: 100,
+		J: 1,
+	}
+	const want = `{"a":123.45,"b":-0.07,"c":-0.005,"d":"1.0","e":-92233720368547758.08,"f":1844674407370955.1615,"g":100,"i":100,"j":0.00000000000000000001,"k":null}`
+
+	b, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(b); s != want {
+		t.Errorf("got %s, want %s", s, want)
+	}
+	// The scaled numbers must be equal to the
+	// integers divided by the power of ten.
+	for i, s := range []string{"12345", "-7", "1", "-9223372036854775808"} {
+		r, ok := new(big.Rat).SetString(s)
+		if !ok {
+			t.Fatal("invalid rat")
+		}
+		r.Quo(r, new(big.Rat).SetInt64(100))
+		n, _ := strconv.ParseInt(s, 10, 64)
+		u, neg := absInt(n)
+		got, ok := new(big.Rat).SetString(string(appendScaledInt(nil, u, neg, 2)))
+		if !ok || got.Cmp(r) != 0 {
+			t.Errorf("#%d: got %s, want %s", i, got, r)
+		}
+	}
+}
+
+// TestInt64Format tests the encoding of the
+// 64-bit integers with each format.
+func TestInt64Format(t *testing.T) {
+	const (
+		safe   = 1<<53 - 1
+		unsafe = 1 << 53
+	)
+	type x struct {
+		A int64            `json:"a"`
+		B int64            `json:"b"`
+		C uint64           `json:"c"`
+		D *int64           `json:"d"`
+		E int64            `json:"e,string"`
+		F int32            `json:"f"`
+		G []int            `json:"g"`
+		H map[int64]uint64 `json:"h"`
+		I interface{}      `json:"i"`
+		J int64            `json:"j,scale=2"`
+		K time.Duration    `json:"k"`
+	}
+	d := int64(-unsafe)
+	v := x{
+		A: safe,
+		B: -safe,
+		C: unsafe,
+		D: &d,
+		E: unsafe,
+		F: math.MaxInt32,
+		G: []int{1, unsafe},
+		H: map[int64]uint64{unsafe: 1},
+		I: uint64(math.MaxUint64),
+		J: unsafe,
+		K: unsafe,
+	}
+	for _, tt := range []struct {
+		format Int64Fmt
+		want   string
+	}{
+		{
+			Int64Number,
+			`{"a":9007199254740991,"b":-9007199254740991,"c":9007199254740992,"d":-9007199254740992,"e":"9007199254740992","f":2147483647,"g":[1,9007199254740992],"h":{"9007199254740992":1},"i":18446744073709551615,"j":90071992547409.92,"k":9007199254740992}`,
+		},
+		{
+			Int64String,
+			`{"a":"9007199254740991","b":"-9007199254740991","c":"9007199254740992","d":"-9007199254740992","e":"9007199254740992","f":2147483647,"g":["1","9007199254740992"],"h":{"9007199254740992":"1"},"i":"18446744073709551615","j":90071992547409.92,"k":9007199254740992}`,
+		},
+		{
+			Int64StringIfUnsafe,
+			`{"a":9007199254740991,"b":-9007199254740991,"c":"9007199254740992","d":"-9007199254740992","e":"9007199254740992","f":2147483647,"g":[1,"9007199254740992"],"h":{"9007199254740992":1},"i":"18446744073709551615","j":90071992547409.92,"k":9007199254740992}`,
+		},
+	} {
+		b, err := MarshalOpts(v, Int64Format(tt.format))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := string(b); s != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.format, s, tt.want)
+		}
+	}
+}
+
+type (
+	pointerCycle struct {
+		Ptr *pointerCycle
+	}
+	pointerCycleIndirect struct {
+		Ptrs []interface{}
+	}
+	mapCycle   map[string]interface{}
+	sliceCycle []interface{}
+)
+
+// TestCycles tests that encoding a value that contains
+// a cycle of pointers, maps or slices returns an
+// UnsupportedValueError rather than overflowing the
+// stack, with the path where the cycle was found.
+func TestCycles(t *testing.T) {
+	pc := &pointerCycle{}
+	pc.Ptr = pc
+
+	pci := &pointerCycleIndirect{}
+	pci.Ptrs = []interface{}{pci}
+
+	mc := mapCycle{}
+	mc["x"] = mc
+
+	sc := make(sliceCycle, 1)
+	sc[0] = sc
+
+	for _, tt := range []struct {
+		v    interface{}
+		via  string
+		path string
+	}{
+		{pc, "*jettison.pointerCycle", "$.Ptr.Ptr"},
+		{pci, "*jettison.pointerCycleIndirect", "$.Ptrs[0].Ptrs[0]"},
+		{mc, "jettison.mapCycle", "$.x.x"},
+		{sc, "jettison.sliceCycle", "$[0][0]"},
+	} {
+		_, err := Marshal(tt.v)
+		if err == nil {
+			t.Errorf("%T: got nil, want non-nil error", tt.v)
+			continue
+		}
+		uve, ok := err.(*UnsupportedValueError)
+		if !ok {
+			t.Errorf("%T: got %T, want UnsupportedValueError", tt.v, err)
+			continue
+		}
+		if want := "encountered a cycle via " + tt.via; uve.Str != want {
+			t.Errorf("got %q, want %q", uve.Str, want)
+		}
+		if uve.Value.Type() != reflect.TypeOf(tt.v) {
+			t.Errorf("got value of type %s, want %T", uve.Value.Type(), tt.v)
+		}
+		// The cycle is detected after a threshold, so
+		// only the beginning of the path is checked.
+		if p := uve.Path(); !strings.HasPrefix(p, tt.path) {
+			t.Errorf("got path %.32s..., want prefix %s", p, tt.path)
+		}
+		if !strings.Contains(err.Error(), " at "+tt.path) {
+			t.Errorf("error message doesn't include the path: %.128s", err)
+		}
+		// The path is truncated after a few levels.
+		if p := uve.Path(); !strings.HasSuffix(p, "...") || len(p) > 32*maxErrorPathSegments {
+			t.Errorf("got path of length %d, want truncated path", len(p))
+		}
+	}
+}
+
+// TestNoCycles tests that values that are referenced
+// several times, but not by themselves, and deeply
+// nested values, are not reported as cycles.
+func TestNoCycles(t *testing.T) {
+	type x struct {
+		A, B *int
+		S    []interface{}
+	}
+	i := 42
+	s := []interface{}{1}
+	marshalCompare(t, x{&i, &i, []interface{}{s, s}}, "")
+
+	var (
+		root = &pointerCycle{}
+		curr = root
+	)
+	for n := 0; n < 2*startDetectingCyclesAfter; n++ {
+		curr.Ptr = &pointerCycle{}
+		curr = curr.Ptr
+	}
+	if _, err := Marshal(root); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestMaxDepth tests that encoding a value whose
+// nesting depth exceeds the limit set with the
+// MaxDepth option returns a DepthError.
+func TestMaxDepth(t *testing.T) {
+	type x struct {
+		A []interface{}        `json:"a"`
+		M map[string]x         `json:"m,omitempty"`
+		E map[string]int       `json:"e,omitempty"`
+		S []x                  `json:"s,omitempty"`
+		I map[string]*sync.Map `json:"i,omitempty"`
+	}
+	var sm sync.Map
+	sm.Store("k", []int{1})
+
+	for _, tt := range []struct {
+		v     interface{}
+		depth int
+		path  string
+	}{
+		{x{}, 1, ""},
+		{x{A: []interface{}{}}, 1, ""},
+		{x{A: []interface{}{1}}, 1, "$.a"},
+		{x{A: []interface{}{1}}, 2, ""},
+		{x{A: []interface{}{[]int{1}}}, 2, "$.a[0]"},
+		{x{E: map[string]int{}}, 1, ""},
+		{x{E: map[string]int{"b": 1}}, 1, "$.e"},
+		{x{M: map[string]x{"b": {}}}, 2, "$.m.b"},
+		{x{S: []x{{}, {M: map[string]x{"c": {}}}}}, 3, `$.s[1].m`},
+		{x{S: []x{{}, {M: map[string]x{"c": {}}}}}, 4, `$.s[1].m.c`},
+		{x{I: map[string]*sync.Map{"d": &sm}}, 3, "$.i.d.k"},
+		{map[string]interface{}{"x y": []interface{}{}}, 1, ""},
+		{map[string]interface{}{"x y": []interface{}{1}}, 1, `$["x y"]`},
+	} {
+		_, err := MarshalOpts(tt.v, MaxDepth(tt.depth))
+		if tt.path == "" {
+			if err != nil {
+				t.Errorf("%+v: %s", tt.v, err)
+			}
+			continue
+		}
+		de, ok := err.(*DepthError)
+		if !ok {
+			t.Errorf("%+v: got %T, want DepthError", tt.v, err)
+			continue
+		}
+		if de.MaxDepth != tt.depth {
+			t.Errorf("got max depth %d, want %d", de.MaxDepth, tt.depth)
+		}
+		if p := de.Path(); p != tt.path {
+			t.Errorf("%+v: got path %s, want %s", tt.v, p, tt.path)
+		}
+	}
+	// The depth is unlimited by default.
+	var v interface{} = 1
+	for i := 0; i < 2*startDetectingCyclesAfter; i++ {
+		v = []interface{}{v}
+	}
+	if _, err := Marshal(v); err != nil {
+		t.Error(err)
+	}
+	_, err := MarshalOpts(v, MaxDepth(100))
+	if _, ok := err.(*DepthError); !ok {
+		t.Errorf("got %T, want DepthError", err)
+	}
+	const want = "json: exceeded max depth of 100 at $"
+	if s := err.Error(); !strings.HasPrefix(s, want) {
+		t.Errorf("got %.64q, want prefix %q", s, want)
+	}
+}
+
+type sizeMarshaler int
+
+func (m sizeMarshaler) AppendJSON(dst []byte) ([]byte, error) {
+	return strconv.AppendQuote(dst, strings.Repeat("x", int(m))), nil
+}
+
+// TestMaxBytes tests that encoding a value whose output
+// exceeds the limit set with the MaxBytes option returns
+// a SizeError, with the path of the value that crossed
+// the limit.
+func TestMaxBytes(t *testing.T) {
+	type x struct {
+		A string                  `json:"a"`
+		B []int                   `json:"b,omitempty"`
+		M map[string]string       `json:"m,omitempty"`
+		S *sync.Map               `json:"s,omitempty"`
+		J map[int][]sizeMarshaler `json:"j,omitempty"`
+	}
+	sm := &sync.Map{}
+	sm.Store("k", "vvvv")
+
+	for _, tt := range []struct {
+		v     interface{}
+		opts  []Option
+		limit int
+		path  string
+	}{
+		{x{}, nil, len(`{"a":""}`), ""},
+		{x{}, nil, len(`{"a":""}`) - 1, "$"},
+		{x{A: "aaaa"}, nil, 8, "$.a"},
+		{x{B: []int{1, 2, 3}}, nil, len(`{"a":"","b":[1,2`), "$.b[2]"},
+		{x{M: map[string]string{"a b": "c", "d": "eeee"}}, nil, len(`{"a":"","m":{"a b":"c","d"`), "$.m.d"},
+		{x{M: map[string]string{"a b": "cccc", "d": "eeee"}}, nil, len(`{"a":"","m":{"a b":"c`), `$.m["a b"]`},
+		{x{M: map[string]string{"a b": "cccc"}}, []Option{UnsortedMap()}, len(`{"a":"","m":{"a b"`), `$.m["a b"]`},
+		{x{S: sm}, nil, len(`{"a":"","s":{"k":"`), "$.s.k"},
+		{x{S: sm}, []Option{UnsortedMap()}, len(`{"a":"","s":{"k":"`), "$.s.k"},
+		{x{J: map[int][]sizeMarshaler{42: {1, 64}}}, nil, 32, `$.j["42"][1]`},
+		{sizeMarshaler(64), nil, 32, "$"},
+		{sizeMarshaler(2), []Option{Indent("", "\t")}, 4, ""},
+	} {
+		b, err := MarshalOpts(tt.v, append(tt.opts, MaxBytes(tt.limit))...)
+		if tt.path == "" {
+			if err != nil {
+				t.Errorf("%+v: %s", tt.v, err)
+			}
+			if len(b) > tt.limit {
+				t.Errorf("%+v: output %s exceeds limit", tt.v, b)
+			}
+			continue
+		}
+		se, ok := err.(*SizeError)
+		if !ok {
+			t.Errorf("%+v: got %T, want SizeError", tt.v, err)
+			continue
+		}
+		if se.MaxBytes != tt.limit {
+			t.Errorf("got max bytes %d, want %d", se.MaxBytes, tt.limit)
+		}
+		if p := se.Path(); p != tt.path {
+			t.Errorf("%+v: got path %s, want %s", tt.v, p, tt.path)
+		}
+	}
+}
+
+// TestMaxBytesSortedMap tests that the location
+// where the maximum size of the output is exceeded
+// in a sorted map doesn't depend on the iteration
+// order of its entries.
+func TestMaxBytesSortedMap(t *testing.T) {
+	m := make(map[string]string)
+	sm := &sync.Map{}
+	for _, k := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		m[k] = "vvvv"
+		sm.Store(k, "vvvv")
+	}
+	// The limit is exceeded by the value
+	// of the third entry in key order.
+	limit := len(`{"a":"vvvv","b":"vvvv","c":"v`)
+
+	for i := 0; i < 20; i++ {
+		for _, v := range []interface{}{m, sm} {
+			_, err := MarshalOpts(v, MaxBytes(limit))
+			se, ok := err.(*SizeError)
+			if !ok {
+				t.Fatalf("got %T, want SizeError", err)
+			}
+			if p := se.Path(); p != "$.c" {
+				t.Errorf("%T: got path %s, want $.c", v, p)
+			}
+		}
+	}
+}
+
+// TestMaxBytesAppend tests that the bytes of the
+// destination buffer that precede the output are
+// not accounted for.
+func TestMaxBytesAppend(t *testing.T) {
+	var (
+		v   = []string{"abc"}
+		dst = []byte("prefix:")
+	)
+	enc, err := NewEncoder(MaxBytes(len(`["abc"]`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	typ := TypedEncoderOf[[]string](enc)
+
+	for _, fn := range []func() ([]byte, error){
+		func() ([]byte, error) { return enc.Append(dst, v) },
+		func() ([]byte, error) { return typ.Append(dst, &v) },
+		func() ([]byte, error) { return AppendOpts(dst, v, MaxBytes(len(`["abc"]`))) },
+	} {
+		b, err := fn()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := `prefix:["abc"]`; string(b) != want {
+			t.Errorf("got %s, want %s", b, want)
+		}
+	}
+	v = append(v, "d")
+	for _, fn := range []func() ([]byte, error){
+		func() ([]byte, error) { return enc.Append(dst, v) },
+		func() ([]byte, error) { return typ.Append(dst, &v) },
+	} {
+		_, err := fn()
+		if _, ok := err.(*SizeError); !ok {
+			t.Errorf("got %T, want SizeError", err)
+		}
+		const want = "json: exceeded max size of 7 bytes at $[1]"
+		if err.Error() != want {
+			t.Errorf("got %q, want %q", err, want)
+		}
+	}
+}
+
 // TestJSONNumber tests that a json.Number literal value
 // can be marshaled, and that an error is returned if it
 // isn't a valid number according to the JSON grammar.
@@ -553,6 +1207,10 @@ func (mkvintMarshaler) MarshalText() ([]byte, error)  { return []byte("MKVINT"),
 func (*mkrintMarshaler) MarshalText() ([]byte, error) { return []byte("MKRINT"), nil }
 func (mkvcmpMarshaler) MarshalText() ([]byte, error)  { return []byte("MKVCMP"), nil }
 
+type mkjsonMarshaler int
+
+func (mkjsonMarshaler) MarshalJSON() ([]byte, error) { return []byte(`"MKJSON"`), nil }
+
 // TestMapKeyPrecedence tests that the precedence
 // order of map key types is respected during marshaling.
 func TestMapKeyPrecedence(t *testing.T) {
@@ -564,6 +1222,9 @@ func TestMapKeyPrecedence(t *testing.T) {
 		map[mkvintMarshaler]string{42: "V"},
 		map[mkrintMarshaler]string{1: "one"},
 		map[mkvcmpMarshaler]string{{}: "V"},
+		map[mkjsonMarshaler]string{1: "V"},
+		map[time.Duration]string{1: "V"},
+		map[*big.Int]string{big.NewInt(1): "V"},
 	}
 	for _, v := range testdata {
 		marshalCompare(t, v, "")
@@ -573,8 +1234,8 @@ func TestMapKeyPrecedence(t *testing.T) {
 // TestJSONMarshaler tests that a type implementing the
 // json.Marshaler interface is marshaled using the result
 // of its MarshalJSON method call result.
-// Because the types big.Int and time.Time also implements
-// the encoding.TextMarshaler interface, the test ensures
+// Because the type time.Time also implements the
+// encoding.TextMarshaler interface, the test ensures
 // that MarshalJSON has priority.
 func TestJSONMarshaler(t *testing.T) {
 	type x struct {
@@ -589,11 +1250,11 @@ func TestJSONMarshaler(t *testing.T) {
 		S4 *bvjm      `json:""`
 		S5 *bvjm      `json:""`           // nil
 		S6 *bvjm      `json:",omitempty"` // nil
-		I1 big.Int    `json:""`
-		I2 big.Int    `json:",omitempty"`
-		I3 *big.Int   `json:""`
-		I4 *big.Int   `json:""`           // nil
-		I5 *big.Int   `json:",omitempty"` // nil
+		C1 crjm       `json:""`
+		C2 crjm       `json:",omitempty"`
+		C3 *crjm      `json:""`
+		C4 *crjm      `json:""`           // nil
+		C5 *crjm      `json:",omitempty"` // nil
 		P1 brjm       `json:",omitempty"`
 		P2 brjm       `json:",omitempty"`
 		P3 brjm       `json:""`
@@ -604,7 +1265,7 @@ func TestJSONMarshaler(t *testing.T) {
 		// NOTE
 		// time.Time = Non-pointer receiver of composite type.
 		// bvjm = Non-pointer receiver of basic type.
-		// big.Int = Pointer receiver of composite type.
+		// crjm = Pointer receiver of composite type.
 		// brjm = Pointer receiver of basic type.
 	}
 	var (
@@ -616,8 +1277,8 @@ func TestJSONMarshaler(t *testing.T) {
 			T3: &now,
 			S1: "S1",
 			S4: &bval,
-			I1: *big.NewInt(math.MaxInt64),
-			I3: big.NewInt(math.MaxInt64),
+			C1: crjm{L: "A", R: "B"},
+			C3: &crjm{L: "C", R: "D"},
 			P1: "P1",
 			P4: &bref,
 		}
@@ -631,33 +1292,33 @@ func TestJSONMarshaler(t *testing.T) {
 // quoted string of its MashalText method result.
 func TestTextMarshaler(t *testing.T) {
 	type x struct {
-		S1 net.IP     `json:""`
-		S2 net.IP     `json:",omitempty"`
-		S3 *net.IP    `json:""`
-		S4 *net.IP    `json:""`           // nil
-		S5 *net.IP    `json:",omitempty"` // nil
-		I1 bvtm       `json:",omitempty"`
-		I2 bvtm       `json:",omitempty"`
-		I3 bvtm       `json:""`
-		I4 *bvtm      `json:""`
-		I5 *bvtm      `json:""`           // nil
-		I6 *bvtm      `json:",omitempty"` // nil
-		F1 big.Float  `json:""`
-		F2 big.Float  `json:",omitempty"`
-		F3 *big.Float `json:""`
-		F4 *big.Float `json:""`           // nil
-		F5 *big.Float `json:",omitempty"` // nil
-		P1 brtm       `json:",omitempty"`
-		P2 brtm       `json:",omitempty"`
-		P3 brtm       `json:""`
-		P4 *brtm      `json:""`
-		P5 *brtm      `json:""`           // nil
-		P6 *brtm      `json:",omitempty"` // nil
+		S1 net.IP  `json:""`
+		S2 net.IP  `json:",omitempty"`
+		S3 *net.IP `json:""`
+		S4 *net.IP `json:""`           // nil
+		S5 *net.IP `json:",omitempty"` // nil
+		I1 bvtm    `json:",omitempty"`
+		I2 bvtm    `json:",omitempty"`
+		I3 bvtm    `json:""`
+		I4 *bvtm   `json:""`
+		I5 *bvtm   `json:""`           // nil
+		I6 *bvtm   `json:",omitempty"` // nil
+		C1 crtm    `json:""`
+		C2 crtm    `json:",omitempty"`
+		C3 *crtm   `json:""`
+		C4 *crtm   `json:""`           // nil
+		C5 *crtm   `json:",omitempty"` // nil
+		P1 brtm    `json:",omitempty"`
+		P2 brtm    `json:",omitempty"`
+		P3 brtm    `json:""`
+		P4 *brtm   `json:""`
+		P5 *brtm   `json:""`           // nil
+		P6 *brtm   `json:",omitempty"` // nil
 
 		// NOTE
 		// net.IP = Non-pointer receiver of composite type.
 		// bvtm = Non-pointer receiver of basic type.
-		// big.Float = Pointer receiver of composite type.
+		// crtm = Pointer receiver of composite type.
 		// brtm = Pointer receiver of basic type.
 	}
 	var (
@@ -668,8 +1329,8 @@ func TestTextMarshaler(t *testing.T) {
 			S3: &net.IP{127, 0, 0, 1},
 			I1: 42,
 			I4: &bval,
-			F1: *big.NewFloat(math.MaxFloat64),
-			F3: big.NewFloat(math.MaxFloat64),
+			C1: crtm{L: "A", R: "B"},
+			C3: &crtm{L: "C", R: "D"},
 			P1: 42,
 			P4: &bref,
 		}
@@ -1044,6 +1705,117 @@ func TestStructFieldOmitnil(t *testing.T) {
 	}
 }
 
+type (
+	zeroValuer    struct{ v int }
+	zeroPtrValuer struct{ v int }
+	zeroIface     interface{ IsZero() bool }
+)
+
+// IsZero reports whether v is odd, to ensure
+// that the method has precedence over the
+// comparison with the zero-value.
+func (z zeroValuer) IsZero() bool     { return z.v%2 != 0 }
+func (z *zeroPtrValuer) IsZero() bool { return z.v%2 != 0 }
+
+// TestStructFieldOmitzero tests that the fields of a
+// struct with the omitzero option are not encoded when
+// they are zero, according to their IsZero method, or
+// the zero-value of their type otherwise.
+func TestStructFieldOmitzero(t *testing.T) {
+	type (
+		nested struct {
+			A int
+			B *string
+			_ int
+		}
+		x struct {
+			S   string         `json:"s,omitzero"`
+			I   int            `json:"i,omitzero"`
+			F   float64        `json:"f,omitzero"`
+			NZ  float64        `json:"nz,omitzero"`
+			B   bool           `json:"b,omitzero"`
+			Sl  []int          `json:"sl,omitzero"`
+			Esl []int          `json:"esl,omitzero"`
+			M   map[string]int `json:"m,omitzero"`
+			Em  map[string]int `json:"em,omitzero"`
+			P   *int           `json:"p,omitzero"`
+			If  interface{}    `json:"if,omitzero"`
+			T   time.Time      `json:"t,omitzero"`
+			Pt  *time.Time     `json:"pt,omitzero"`
+			N   nested         `json:"n,omitzero"`
+			UID [16]byte       `json:"uid,omitzero"`
+			Arr [2]nested      `json:"arr,omitzero"`
+			Zv  zeroValuer     `json:"zv,omitzero"`
+			Zp  zeroPtrValuer  `json:"zp,omitzero"`
+			Zi  zeroIface      `json:"zi,omitzero"`
+		}
+	)
+	var (
+		s   = "s"
+		i   = 0
+		now = time.Date(2022, time.March, 21, 0, 0, 0, 0, time.UTC)
+	)
+	for _, tt := range []struct {
+		v    x
+		want string
+	}{
+		{x{}, `{"zv":{},"zp":{}}`},
+		{x{Zv: zeroValuer{1}, Zp: zeroPtrValuer{1}, Zi: zeroValuer{1}}, `{}`},
+		{x{Zi: &zeroPtrValuer{}}, `{"zv":{},"zp":{},"zi":{}}`},
+		{x{
+			NZ:  math.Copysign(0, -1),
+			Esl: []int{},
+			Em:  map[string]int{},
+			P:   &i,
+			If:  0,
+			Pt:  &time.Time{},
+			N:   nested{B: &s},
+			UID: [16]byte{15: 1},
+			Arr: [2]nested{1: {A: 1}},
+			Zv:  zeroValuer{1},
+			Zp:  zeroPtrValuer{1},
+		}, `{"nz":-0,"esl":[],"em":{},"p":0,"if":0,"n":{"A":0,"B":"s"},"uid":[0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,1],"arr":[{"A":0,"B":null},{"A":1,"B":null}]}`},
+		{x{T: now, Pt: &now}, `{"t":"2022-03-21T00:00:00Z","pt":"2022-03-21T00:00:00Z","zv":{},"zp":{}}`},
+	} {
+		b, err := Marshal(tt.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(b); got != tt.want {
+			t.Errorf("got: %#q, want: %#q", got, tt.want)
+		}
+	}
+}
+
+// TestStructFieldOmitzeroCompose tests that the
+// omitzero option composes with the omitempty and
+// omitnil options, a field being omitted if any
+// of them applies.
+func TestStructFieldOmitzeroCompose(t *testing.T) {
+	type x struct {
+		A []int          `json:"a,omitempty,omitzero"`
+		B map[string]int `json:"b,omitnil,omitzero"`
+		C *time.Time     `json:"c,omitnil,omitzero"`
+		D time.Time      `json:"d,omitempty,omitzero"`
+	}
+	for _, tt := range []struct {
+		v    x
+		want string
+	}{
+		{x{}, `{}`},
+		{x{A: []int{}, B: map[string]int{}, C: &time.Time{}}, `{"b":{}}`},
+		{x{A: []int{1}, D: time.Unix(0, 0).UTC()}, `{"a":[1],"d":"1970-01-01T00:00:00Z"}`},
+	} {
+		b, err := Marshal(tt.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(b); got != tt.want {
+			t.Errorf("got: %#q, want: %#q", got, tt.want)
+		}
+	}
+}
+
 // TestQuotedStructFields tests that the fields of
 // a struct with the string option are quoted during
 // marshaling if the type support it.
@@ -1948,10 +2720,15 @@ func TestMarshalFloat(t *testing.T) {
 }
 
 type (
-	jm  int
-	jmp int
+	jm   int
+	jmp  int
+	crjm struct{ L, R string }
 )
 
+func (m *crjm) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(m.L + ":" + m.R)), nil
+}
+
 func (m jm) MarshalJSON() ([]byte, error) {
 	if m == 0 {
 		return []byte("null"), nil
@@ -2020,3 +2797,19 @@ func TestIssue5(t *testing.T) {
 		t.Errorf("got %s, want %s,", string(b), string(want))
 	}
 }
+
+// TestOmitnilMarshalerFirstField tests that omitting
+// the first field of a struct whose marshaler returns
+// null doesn't produce a leading comma.
+func TestOmitnilMarshalerFirstField(t *testing.T) {
+	b, err := Marshal(struct {
+		A jm `json:"a,omitnil"`
+		B jm `json:"b"`
+	}{0, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"b":1}`; string(b) != want {
+		t.Errorf("got %s, want %s", b, want)
+	}
+}
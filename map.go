@@ -2,6 +2,7 @@ package jettison
 
 import (
 	"bytes"
+	"sort"
 	"sync"
 	"unsafe"
 )
@@ -11,13 +12,24 @@ var (
 	mapElemsPool sync.Pool // *mapElems
 )
 
-// kv represents a map key/value pair.
+// kv represents a map key/value pair. The keyval
+// field holds the encoded key and its separator,
+// followed by the encoded value, unless the value
+// is encoded after the sort, in which case kp and
+// vp point to the key and the value of the entry.
 type kv struct {
 	key    []byte
 	keyval []byte
+	kp, vp unsafe.Pointer
 }
 
-type mapElems struct{ s []kv }
+// mapElems holds the pairs of a map to sort. The
+// entries of a sync.Map are stored in ifaces, two
+// interfaces per pair, pointed to by kp and vp.
+type mapElems struct {
+	s      []kv
+	ifaces []interface{}
+}
 
 // releaseMapElems zeroes the content of the
 // map elements slice and resets the length to
@@ -26,7 +38,11 @@ func releaseMapElems(me *mapElems) {
 	for i := range me.s {
 		me.s[i] = kv{}
 	}
+	for i := range me.ifaces {
+		me.ifaces[i] = nil
+	}
 	me.s = me.s[:0]
+	me.ifaces = me.ifaces[:0]
 	mapElemsPool.Put(me)
 }
 
@@ -34,6 +50,23 @@ func (m mapElems) Len() int           { return len(m.s) }
 func (m mapElems) Swap(i, j int)      { m.s[i], m.s[j] = m.s[j], m.s[i] }
 func (m mapElems) Less(i, j int) bool { return bytes.Compare(m.s[i].key, m.s[j].key) < 0 }
 
+// canonicalMapElems sorts the map elements by key
+// in the order of their UTF-16 code units, which is
+// the order of the canonical form of RFC 8785.
+type canonicalMapElems struct{ mapElems }
+
+func (m canonicalMapElems) Less(i, j int) bool { return compareKeys(m.s[i].key, m.s[j].key) < 0 }
+
+// sortMapElems sorts the map elements by key in
+// the order corresponding to the options.
+func sortMapElems(m *mapElems, opts encOpts) {
+	if opts.flags.has(canonical) {
+		sort.Sort(canonicalMapElems{*m})
+		return
+	}
+	sort.Sort(m)
+}
+
 // hiter is the runtime representation
 // of a hashmap iteration structure.
 type hiter struct {
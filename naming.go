@@ -0,0 +1,128 @@
+package jettison
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A NamingPolicy converts the Go name of the struct
+// fields that have no name in their JSON tag to the
+// key that is used to encode them.
+// The policies are compared by identity, and the
+// instructions generated for each are kept for the
+// lifetime of the program, so a custom policy should
+// be created once and reused, rather than for every
+// call to an encoding function.
+type NamingPolicy struct {
+	fn func(string) string
+}
+
+// Predefined naming policies. The examples show the
+// key produced for a field named UserID.
+var (
+	SnakeCase = NewNamingPolicy(toSnakeCase) // user_id
+	CamelCase = NewNamingPolicy(toCamelCase) // userID
+	KebabCase = NewNamingPolicy(toKebabCase) // user-id
+	LowerCase = NewNamingPolicy(toLowerCase) // userid
+)
+
+// NewNamingPolicy returns a new NamingPolicy that
+// converts the names of the fields with fn. If fn
+// returns a name that is not valid as a JSON tag
+// name, the Go name of the field is used instead.
+func NewNamingPolicy(fn func(name string) string) *NamingPolicy {
+	return &NamingPolicy{fn: fn}
+}
+
+// fieldName returns the key of a struct field
+// named name. It is safe to call with a nil
+// receiver, which is the identity policy.
+func (p *NamingPolicy) fieldName(name string) string {
+	if p == nil || p.fn == nil {
+		return name
+	}
+	if s := p.fn(name); isValidFieldName(s) {
+		return s
+	}
+	return name
+}
+
+func toSnakeCase(s string) string { return joinWords(splitWords(s), '_') }
+func toKebabCase(s string) string { return joinWords(splitWords(s), '-') }
+func toLowerCase(s string) string { return strings.ToLower(s) }
+
+func toCamelCase(s string) string {
+	words := splitWords(s)
+	if len(words) == 0 {
+		return s
+	}
+	// Only the first word is lowercased, the others
+	// keep their original case, such as initialisms.
+	words[0] = strings.ToLower(words[0])
+
+	return strings.Join(words, "")
+}
+
+func joinWords(words []string, sep byte) string {
+	var sb strings.Builder
+	for i, w := range words {
+		if i != 0 {
+			sb.WriteByte(sep)
+		}
+		sb.WriteString(strings.ToLower(w))
+	}
+	return sb.String()
+}
+
+// splitWords splits a Go identifier into words.
+// A word starts with an uppercase letter that
+// follows a lowercase letter or a digit, or with
+// the last letter of a sequence of uppercase ones
+// followed by a lowercase letter, which delimits
+// initialisms, like in HTTPServer. A lowercase s
+// that ends a sequence of uppercase letters and is
+// not followed by a lowercase letter is part of the
+// initialism, like in URLs. Underscores are treated
+// as separators.
+func splitWords(s string) []string {
+	var (
+		words []string
+		start = 0
+		prev  rune
+	)
+	for i, r := range s {
+		if r == '_' {
+			if i > start {
+				words = append(words, s[start:i])
+			}
+			start, prev = i+1, 0
+			continue
+		}
+		if i > start && unicode.IsUpper(r) {
+			rest := s[i+utf8.RuneLen(r):]
+			next, n := utf8.DecodeRuneInString(rest)
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) ||
+				(unicode.IsUpper(prev) && unicode.IsLower(next) && !isPluralSuffix(next, rest[n:])) {
+				words = append(words, s[start:i])
+				start = i
+			}
+		}
+		prev = r
+	}
+	if start < len(s) {
+		words = append(words, s[start:])
+	}
+	return words
+}
+
+// isPluralSuffix returns whether the rune r, followed
+// by rest, is the lowercase s that marks the plural
+// of an initialism, such as in IDs or APIsByName.
+func isPluralSuffix(r rune, rest string) bool {
+	if r != 's' {
+		return false
+	}
+	next, _ := utf8.DecodeRuneInString(rest)
+	return !unicode.IsLower(next)
+}
@@ -0,0 +1,171 @@
+package jettison
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitWords(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		words []string
+	}{
+		{"ID", []string{"ID"}},
+		{"Name", []string{"Name"}},
+		{"UserID", []string{"User", "ID"}},
+		{"HTTPServer", []string{"HTTP", "Server"}},
+		{"ServeHTTP", []string{"Serve", "HTTP"}},
+		{"APIKeyV2", []string{"API", "Key", "V2"}},
+		{"V2Beta", []string{"V2", "Beta"}},
+		{"Field1", []string{"Field1"}},
+		{"Created_At", []string{"Created", "At"}},
+		{"A__B_", []string{"A", "B"}},
+		{"ÉtéÀ", []string{"Été", "À"}},
+		{"URLs", []string{"URLs"}},
+		{"IDs", []string{"IDs"}},
+		{"UserIDs", []string{"User", "IDs"}},
+		{"APIsByName", []string{"APIs", "By", "Name"}},
+		{"HTTPSettings", []string{"HTTP", "Settings"}},
+		{"Is", []string{"Is"}},
+	} {
+		if words := splitWords(tt.name); !reflect.DeepEqual(words, tt.words) {
+			t.Errorf("%s: got %q, want %q", tt.name, words, tt.words)
+		}
+	}
+}
+
+type (
+	namingEmbedded struct {
+		EmbeddedField string
+	}
+	namingStruct struct {
+		UserID     int
+		HTTPServer string
+		Tagged     string `json:"MyTag"`
+		Options    bool   `json:",omitempty"`
+		Skipped    string `json:"-"`
+		namingEmbedded
+	}
+)
+
+func TestFieldNaming(t *testing.T) {
+	v := namingStruct{
+		UserID:         42,
+		HTTPServer:     "srv",
+		Tagged:         "tag",
+		Options:        true,
+		namingEmbedded: namingEmbedded{EmbeddedField: "emb"},
+	}
+	for _, tt := range []struct {
+		policy *NamingPolicy
+		want   string
+	}{
+		{nil, `{"UserID":42,"HTTPServer":"srv","MyTag":"tag","Options":true,"EmbeddedField":"emb"}`},
+		{SnakeCase, `{"user_id":42,"http_server":"srv","MyTag":"tag","options":true,"embedded_field":"emb"}`},
+		{CamelCase, `{"userID":42,"httpServer":"srv","MyTag":"tag","options":true,"embeddedField":"emb"}`},
+		{KebabCase, `{"user-id":42,"http-server":"srv","MyTag":"tag","options":true,"embedded-field":"emb"}`},
+		{LowerCase, `{"userid":42,"httpserver":"srv","MyTag":"tag","options":true,"embeddedfield":"emb"}`},
+		{NewNamingPolicy(strings.ToUpper), `{"USERID":42,"HTTPSERVER":"srv","MyTag":"tag","OPTIONS":true,"EMBEDDEDFIELD":"emb"}`},
+	} {
+		// Encode the value several times to
+		// ensure that the cached instructions
+		// are not shared between policies.
+		for i := 0; i < 2; i++ {
+			b, err := MarshalOpts(v, FieldNaming(tt.policy))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s := string(b); s != tt.want {
+				t.Errorf("got %s, want %s", s, tt.want)
+			}
+		}
+	}
+	// The default instructions must
+	// be left unchanged.
+	b, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"UserID":42`) {
+		t.Errorf("unexpected output: %s", b)
+	}
+}
+
+func TestFieldNamingInvalidName(t *testing.T) {
+	np := NewNamingPolicy(func(name string) string {
+		if name == "UserID" {
+			return `"invalid"`
+		}
+		return ""
+	})
+	b, err := MarshalOpts(struct{ UserID, Name int }{1, 2}, FieldNaming(np))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"UserID":1,"Name":2}`; string(b) != want {
+		t.Errorf("got %s, want %s", b, want)
+	}
+}
+
+func TestFieldNamingDuplicates(t *testing.T) {
+	// Two fields that map to the same key at the same
+	// depth annihilate each other, unless one of them
+	// is tagged, like it would with identical Go names.
+	type x struct {
+		FooBar  int
+		Foo_Bar int
+		Baz     int `json:"baz_qux"`
+		BazQux  int
+	}
+	b, err := MarshalOpts(x{1, 2, 3, 4}, FieldNaming(SnakeCase))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"baz_qux":3}`; string(b) != want {
+		t.Errorf("got %s, want %s", b, want)
+	}
+}
+
+func TestFieldNamingEncoder(t *testing.T) {
+	enc, err := NewEncoder(FieldNaming(SnakeCase))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := []namingEmbedded{{"a"}, {"b"}}
+
+	b, err := enc.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[{"embedded_field":"a"},{"embedded_field":"b"}]`; string(b) != want {
+		t.Errorf("got %s, want %s", b, want)
+	}
+	b, err = TypedEncoderOf[[]namingEmbedded](enc).Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[{"embedded_field":"a"},{"embedded_field":"b"}]`; string(b) != want {
+		t.Errorf("typed: got %s, want %s", b, want)
+	}
+	// Reset the policy of the encoder.
+	enc, err = enc.With(FieldNaming(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = enc.Marshal(v[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"EmbeddedField":"a"}`; string(b) != want {
+		t.Errorf("got %s, want %s", b, want)
+	}
+	// The field selection is validated
+	// against the converted names.
+	if _, err := MarshalOpts(v, FieldNaming(KebabCase), Fields("embedded-field")); err != nil {
+		t.Error(err)
+	}
+	if _, err := MarshalOpts(v, FieldNaming(KebabCase), Fields("EmbeddedField")); err == nil {
+		t.Error("expected non-nil error")
+	}
+}
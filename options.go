@@ -37,26 +37,116 @@ const (
 	noUTF8Coercion
 	noCompact
 	noNumberValidation
+	indentOutput
+	bigNumberAsString
+	canonical
+	rejectDuplicateKeys
+
+	// ownConfig is set once the settings have
+	// been copied while the options are applied.
+	ownConfig
 )
 
+// encOpts are the options of an encoding. They are
+// passed by value to each instruction, and hold the
+// state that is scoped to the value being encoded,
+// while the settings, which are only read, are held
+// by the embedded encConfig, shared by the copies.
 type encOpts struct {
+	*encConfig
+
+	flags bitmask
+
+	// allowPaths and denyPaths are the trees of
+	// the paths that apply to the value being
+	// encoded, descended as the encoder goes.
+	allowPaths *pathNode
+	denyPaths  *pathNode
+
+	// depth is the nesting depth of the value
+	// being encoded, incremented each time the
+	// encoder enters a JSON object or array.
+	depth int
+
+	// sizeOff accounts for the bytes already
+	// written when a temporary buffer is used,
+	// and for the bytes of the destination buffer
+	// that precede the output, to compute its size.
+	sizeOff int
+
+	// ptrLevel is the number of pointers, maps
+	// and slices traversed to reach the value
+	// being encoded, and ptrSeen the set of the
+	// ones being encoded, once ptrLevel exceeds
+	// startDetectingCyclesAfter.
+	ptrLevel int
+	ptrSeen  map[visitKey]struct{}
+}
+
+// encConfig holds the settings of the options that
+// are not modified during an encoding.
+type encConfig struct {
 	ctx         context.Context
 	timeLayout  string
 	durationFmt DurationFmt
-	flags       bitmask
 	allowList   stringSet
 	denyList    stringSet
+	selection   *selection
+	prefix      string
+	indent      string
+	naming      *NamingPolicy
+
+	// nonFiniteFmt is the format of the
+	// NaN and infinite float values.
+	nonFiniteFmt NonFiniteFmt
+
+	// ratFmt is the format of the big.Rat values
+	// that have no finite decimal representation.
+	ratFmt RatFmt
+
+	// floatPrec is the number of decimals of the
+	// floats, or -1 for the shortest representation.
+	floatPrec int
+
+	// int64Fmt is the format of the 64-bit
+	// integers, which JavaScript can't represent
+	// exactly above 2^53-1.
+	int64Fmt Int64Fmt
+
+	// iset is the instruction set that matches the
+	// settings of the options that are applied when
+	// the instructions are generated, such as naming.
+	iset *instrSet
+
+	// err records an error that occurred while
+	// applying an option, reported by validate.
+	err error
+
+	// maxDepth and maxBytes are the limits of the
+	// nesting depth and of the size of the output,
+	// if not zero.
+	maxDepth int
+	maxBytes int
+}
+
+var defaultEncConfig = encConfig{
+	ctx:         context.TODO(),
+	timeLayout:  defaultTimeLayout,
+	durationFmt: defaultDurationFmt,
+	floatPrec:   -1,
+	iset:        defaultInstrSet,
 }
 
 func defaultEncOpts() encOpts {
-	return encOpts{
-		ctx:         context.TODO(),
-		timeLayout:  defaultTimeLayout,
-		durationFmt: defaultDurationFmt,
-	}
+	return encOpts{encConfig: &defaultEncConfig}
 }
 
 func (eo *encOpts) apply(opts ...Option) {
+	// The settings of eo may be shared with
+	// other copies of the options, until they
+	// are copied by config.
+	eo.flags &^= ownConfig
+
 	for _, opt := range opts {
 		if opt != nil {
 			opt(eo)
@@ -64,19 +154,101 @@ func (eo *encOpts) apply(opts ...Option) {
 	}
 }
 
+// config returns the settings of eo to be modified by
+// an option. The settings are shared by the copies of
+// the options, and are copied the first time an option
+// modifies them, which spares the options that only set
+// flags, like most of them, an allocation.
+func (eo *encOpts) config() *encConfig {
+	if !eo.flags.has(ownConfig) {
+		cfg := *eo.encConfig
+		eo.encConfig = &cfg
+		eo.flags.set(ownConfig)
+	}
+	return eo.encConfig
+}
+
+// with returns a copy of eo with the given options
+// applied, or an InvalidOptionError if the resulting
+// options are invalid.
+func (eo encOpts) with(opts ...Option) (encOpts, error) {
+	(&eo).apply(opts...)
+	if err := eo.validate(); err != nil {
+		return eo, &InvalidOptionError{err}
+	}
+	key := instrSetKey{
+		naming:    eo.naming,
+		floatPrec: eo.floatPrec,
+		int64Fmt:  eo.int64Fmt,
+		canonical: eo.flags.has(canonical),
+	}
+	// The instruction set is looked up only if
+	// the options changed the settings it matches.
+	if key != eo.iset.instrSetKey {
+		eo.config().iset = loadInstrSet(key)
+	}
+	return eo, nil
+}
+
 func (eo encOpts) validate() error {
 	switch {
+	case eo.err != nil:
+		return eo.err
 	case eo.ctx == nil:
 		return fmt.Errorf("nil context")
 	case eo.timeLayout == "":
 		return fmt.Errorf("empty time layout")
 	case !eo.durationFmt.valid():
 		return fmt.Errorf("unknown duration format")
+	case !eo.nonFiniteFmt.valid():
+		return fmt.Errorf("unknown non-finite format")
+	case !eo.ratFmt.valid():
+		return fmt.Errorf("unknown rat format")
+	case !eo.int64Fmt.valid():
+		return fmt.Errorf("unknown int64 format")
+	case eo.maxDepth < 0:
+		return fmt.Errorf("negative max depth")
+	case eo.maxBytes < 0:
+		return fmt.Errorf("negative max bytes")
+	case eo.floatPrec > 255:
+		return fmt.Errorf("float precision exceeds 255")
+	case eo.flags.has(canonical):
+		return eo.validateCanonical()
 	default:
 		return nil
 	}
 }
 
+// validateCanonical returns an error if one of the
+// options is incompatible with the canonical form.
+func (eo encOpts) validateCanonical() error {
+	for _, o := range []struct {
+		flag bitmask
+		name string
+	}{
+		{unsortedMap, "UnsortedMap"},
+		{noStringEscaping, "NoStringEscaping"},
+		{noUTF8Coercion, "NoUTF8Coercion"},
+		{noCompact, "NoCompact"},
+		{noNumberValidation, "NoNumberValidation"},
+		{indentOutput, "Indent"},
+	} {
+		if eo.flags.has(o.flag) {
+			return fmt.Errorf("%s is incompatible with Canonical", o.name)
+		}
+	}
+	if eo.floatPrec >= 0 {
+		return fmt.Errorf("FloatPrecision is incompatible with Canonical")
+	}
+	// The canonical form has no representation
+	// of the non-finite numbers, which must be
+	// rejected rather than replaced.
+	if eo.nonFiniteFmt != NonFiniteError {
+		return fmt.Errorf("NonFiniteFormat is incompatible with Canonical")
+	}
+	return nil
+}
+
 // isDeniedField returns whether a struct field
 // identified by its name must be skipped during
 // the encoding of a struct.
@@ -96,6 +268,31 @@ func (eo encOpts) isDeniedField(name string) bool {
 	return false
 }
 
+// depthExceeded returns whether the current
+// nesting depth exceeds the configured limit.
+func (eo encOpts) depthExceeded() bool {
+	return eo.maxDepth != 0 && eo.depth > eo.maxDepth
+}
+
+// sizeExceeded returns whether the size of the output,
+// that ends with the buffer dst, exceeds the configured
+// limit.
+func (eo encOpts) sizeExceeded(dst []byte) bool {
+	return eo.maxBytes != 0 && len(dst)+eo.sizeOff > eo.maxBytes
+}
+
+// appendIndent appends to dst a newline, followed
+// by the prefix and one copy of the indent string
+// for each level of the current nesting depth.
+func (eo encOpts) appendIndent(dst []byte) []byte {
+	dst = append(dst, '\n')
+	dst = append(dst, eo.prefix...)
+	for i := 0; i < eo.depth; i++ {
+		dst = append(dst, eo.indent...)
+	}
+	return dst
+}
+
 type stringSet map[string]struct{}
 
 func fieldListToSet(list []string) stringSet {
@@ -182,12 +379,29 @@ func NoCompact() Option {
 	return func(o *encOpts) { o.flags.set(noCompact) }
 }
 
+// Indent configures an encoder to indent the output,
+// similarly to the json.MarshalIndent function. Each
+// element of a JSON object or array begins on a new
+// line starting with prefix, followed by one or more
+// copies of indent according to the nesting depth.
+// The output of MarshalJSON and AppendJSON methods,
+// and the content of json.RawMessage values are also
+// indented, regardless of the NoCompact option.
+func Indent(prefix, indent string) Option {
+	return func(o *encOpts) {
+		o.flags.set(indentOutput)
+		cfg := o.config()
+		cfg.prefix = prefix
+		cfg.indent = indent
+	}
+}
+
 // TimeLayout sets the time layout used to encode
 // time.Time values. The layout must be compatible
 // with the Golang time package specification.
 func TimeLayout(layout string) Option {
 	return func(o *encOpts) {
-		o.timeLayout = layout
+		o.config().timeLayout = layout
 	}
 }
 
@@ -195,7 +409,105 @@ func TimeLayout(layout string) Option {
 // time.Duration values.
 func DurationFormat(format DurationFmt) Option {
 	return func(o *encOpts) {
-		o.durationFmt = format
+		o.config().durationFmt = format
+	}
+}
+
+// NonFiniteFormat sets the format used to encode
+// the NaN and infinite floating-point numbers,
+// including those of float time.Duration formats.
+// By default, an UnsupportedValueError is returned.
+// With NonFiniteOmit, a struct field that holds
+// such a number is omitted, and other values, such
+// as the elements of an array, are encoded as null.
+func NonFiniteFormat(format NonFiniteFmt) Option {
+	return func(o *encOpts) {
+		o.config().nonFiniteFmt = format
+	}
+}
+
+// BigNumberAsString configures an encoder to encode
+// the big.Int, big.Float and big.Rat values as JSON
+// strings instead of numbers, for consumers whose
+// numbers are limited to the 64-bit floats.
+func BigNumberAsString() Option {
+	return func(o *encOpts) { o.flags.set(bigNumberAsString) }
+}
+
+// RatFormat sets the format used to encode the big.Rat
+// values that have no finite decimal representation,
+// such as 1/3. By default, an UnsupportedValueError is
+// returned, since the value can't be encoded exactly.
+func RatFormat(format RatFmt) Option {
+	return func(o *encOpts) {
+		o.config().ratFmt = format
+	}
+}
+
+// Int64Format sets the format used to encode the
+// 64-bit integers, including int and uint on 64-bit
+// platforms, to prevent the loss of precision of the
+// JavaScript consumers, whose numbers can't represent
+// the integers exactly above 2^53-1. The integers can
+// be encoded as strings either always, or only when
+// their magnitude exceeds this limit. The map keys,
+// which are always quoted, and the fields with the
+// string or scale options of their tag are unaffected.
+func Int64Format(format Int64Fmt) Option {
+	return func(o *encOpts) {
+		o.config().int64Fmt = format
+	}
+}
+
+// Canonical configures an encoder to produce the
+// canonical form of the JSON documents defined by
+// RFC 8785, the JSON Canonicalization Scheme, which
+// is suitable for hashing and signing:
+//   - the members of the objects, including the struct
+//     fields, are sorted by the UTF-16 code units of
+//     their names;
+//   - the numbers are serialized like the ECMAScript
+//     numbers, which are 64-bit floating-point numbers,
+//     and the NaN and infinite floats are rejected with
+//     an UnsupportedValueError, like the 64-bit integers
+//     that they can't represent exactly, above 2^53-1,
+//     unless they are quoted with the Int64Format option,
+//     which applies to the big.Int values too;
+//   - the strings are escaped minimally, without HTML
+//     escaping;
+//   - the output of the marshalers and the json.RawMessage
+//     values are canonicalized likewise, and rejected if
+//     they contain duplicate keys.
+//
+// The options that alter the output in other ways, such
+// as Indent, UnsortedMap, NoStringEscaping or
+// NonFiniteFormat, can't be combined with Canonical.
+func Canonical() Option {
+	return func(o *encOpts) { o.flags.set(canonical | noHTMLEscaping) }
+}
+
+// RejectDuplicateKeys configures Reformat to return
+// a SyntaxError when an object of its input has several
+// members with the same key, once unescaped. Otherwise,
+// all the members are kept. The canonical form always
+// rejects duplicate keys.
+func RejectDuplicateKeys() Option {
+	return func(o *encOpts) { o.flags.set(rejectDuplicateKeys) }
+}
+
+// FloatPrecision sets the number of decimals of the
+// float values, which are rounded and encoded without
+// exponent, such as 3.14 or 1.50 with a precision of
+// 2, instead of using the shortest representation.
+// A negative precision restores the default, and the
+// precision option of a struct field's tag has
+// precedence. The precision is limited to 255.
+func FloatPrecision(n int) Option {
+	return func(o *encOpts) {
+		if n < 0 {
+			n = -1
+		}
+		o.config().floatPrec = n
 	}
 }
 
@@ -205,7 +517,7 @@ func DurationFormat(format DurationFmt) Option {
 // implement the AppendMarshalerCtx interface.
 func WithContext(ctx context.Context) Option {
 	return func(o *encOpts) {
-		o.ctx = ctx
+		o.config().ctx = ctx
 	}
 }
 
@@ -213,12 +525,13 @@ func WithContext(ctx context.Context) Option {
 // considered when encoding a struct.
 // The fields are identified by the name that is
 // used in the final JSON payload.
-// See DenyFields documentation for more information
-// regarding joint use with this option.
+// See DenyList documentation for more information
+// regarding joint use with this option, and AllowPaths
+// to select fields of nested objects independently.
 func AllowList(fields []string) Option {
 	m := fieldListToSet(fields)
 	return func(o *encOpts) {
-		o.allowList = m
+		o.config().allowList = m
 	}
 }
 
@@ -229,6 +542,86 @@ func AllowList(fields []string) Option {
 func DenyList(fields []string) Option {
 	m := fieldListToSet(fields)
 	return func(o *encOpts) {
-		o.denyList = m
+		o.config().denyList = m
 	}
 }
+
+// AllowPaths is similar to AllowList, but the fields
+// are identified by their path from the root of the
+// JSON document, rather than by their name alone.
+// A path is a list of keys separated by dots, such as
+// "user.address.city", where a key is either the name
+// of a struct field, or the key of a map entry. The
+// wildcard "*" matches any key, and a backslash can be
+// used to escape a literal dot or star in a key.
+// The elements of arrays and slices do not add a key
+// to the path, meaning that a path applies to every
+// element of the lists it traverses.
+// The members of an object that is on the way of a
+// path, like "user" and "address" above, are encoded
+// only if they are selected by a path themselves.
+func AllowPaths(paths []string) Option {
+	tree, err := newPathTree(paths)
+	return func(o *encOpts) {
+		if err != nil {
+			o.config().err = err
+			return
+		}
+		o.allowPaths = tree
+		if o.selection != nil {
+			o.config().selection = nil
+		}
+	}
+}
+
+// DenyPaths is similar to AllowPaths, but conversely
+// sets the list of paths of the members to omit during
+// encoding. When used in conjunction with AllowPaths,
+// denied paths have precedence over the allowed paths.
+func DenyPaths(paths []string) Option {
+	tree, err := newPathTree(paths)
+	return func(o *encOpts) {
+		if err != nil {
+			o.config().err = err
+			return
+		}
+		o.denyPaths = tree
+		if o.selection != nil {
+			o.config().selection = nil
+		}
+	}
+}
+
+// FieldNaming sets the policy used to derive the JSON
+// keys of the struct fields that have no name in their
+// JSON tag from their Go name, such as SnakeCase. The
+// name of a tag always has precedence over the policy.
+// A nil policy restores the default behavior, which is
+// to use the Go name of the fields as is.
+func FieldNaming(p *NamingPolicy) Option {
+	return func(o *encOpts) { o.config().naming = p }
+}
+
+// MaxDepth sets the maximum nesting depth of the
+// JSON objects and arrays to encode. A DepthError
+// is returned if the value to encode exceeds it,
+// which protects against the stack exhaustion that
+// deeply nested values, such as interfaces received
+// from untrusted sources, may cause. The output of
+// the marshalers isn't accounted for. Zero means
+// no limit, which is the default.
+func MaxDepth(n int) Option {
+	return func(o *encOpts) { o.config().maxDepth = n }
+}
+
+// MaxBytes sets the maximum size, in bytes, of the
+// output of the encoder. The encoding is aborted with
+// a SizeError as soon as the output exceeds it, which
+// is checked after each value is appended, including
+// the output of the marshalers. When the output is
+// appended to an existing buffer, only the appended
+// bytes are counted. Zero means no limit, which is the
+// default.
+func MaxBytes(n int) Option {
+	return func(o *encOpts) { o.config().maxBytes = n }
+}
@@ -0,0 +1,251 @@
+package jettison
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	pathSep      = '.'
+	pathEscape   = '\\'
+	pathWildcard = "*"
+)
+
+// pathNode is a node of a tree that represents a set
+// of paths used to select the members of JSON objects
+// during encoding. The children of a node are indexed
+// by the key of the members they match.
+type pathNode struct {
+	children map[string]*pathNode
+	wildcard *pathNode
+
+	// end indicates that a path ends at this node,
+	// and that all the descendants of the member
+	// it matches are selected.
+	end bool
+}
+
+// child returns the node that matches the given
+// key, or nil if the key is not matched at all.
+func (n *pathNode) child(key string) *pathNode {
+	if c, ok := n.children[key]; ok {
+		return c
+	}
+	return n.wildcard
+}
+
+// childBytes is similar to child, but takes the
+// key as a byte slice, to avoid a conversion.
+func (n *pathNode) childBytes(key []byte) *pathNode {
+	if c, ok := n.children[string(key)]; ok {
+		return c
+	}
+	return n.wildcard
+}
+
+// insert adds the path represented by the list of
+// segments segs to the tree rooted at n.
+func (n *pathNode) insert(segs []pathSegment) {
+	for _, s := range segs {
+		if n.end {
+			// A shorter path already
+			// selects the whole subtree.
+			return
+		}
+		var c *pathNode
+		if s.wildcard {
+			if n.wildcard == nil {
+				n.wildcard = &pathNode{}
+			}
+			c = n.wildcard
+		} else {
+			if n.children == nil {
+				n.children = make(map[string]*pathNode)
+			}
+			if c = n.children[s.key]; c == nil {
+				c = &pathNode{}
+				n.children[s.key] = c
+			}
+		}
+		n = c
+	}
+	n.end = true
+	n.children = nil
+	n.wildcard = nil
+}
+
+// merge adds all the paths of the tree rooted at
+// m to the tree rooted at n.
+func (n *pathNode) merge(m *pathNode) {
+	if n.end {
+		return
+	}
+	if m.end {
+		n.end = true
+		n.children = nil
+		n.wildcard = nil
+		return
+	}
+	for k, mc := range m.children {
+		if n.children == nil {
+			n.children = make(map[string]*pathNode)
+		}
+		c := n.children[k]
+		if c == nil {
+			c = &pathNode{}
+			n.children[k] = c
+		}
+		c.merge(mc)
+	}
+	if m.wildcard != nil {
+		if n.wildcard == nil {
+			n.wildcard = &pathNode{}
+		}
+		n.wildcard.merge(m.wildcard)
+	}
+}
+
+// expandWildcards merges the wildcard subtree of each
+// node into its named children, so that looking up a
+// key only requires to follow a single node.
+func (n *pathNode) expandWildcards() {
+	for _, c := range n.children {
+		if n.wildcard != nil {
+			c.merge(n.wildcard)
+		}
+		c.expandWildcards()
+	}
+	if n.wildcard != nil {
+		n.wildcard.expandWildcards()
+	}
+}
+
+// newPathTree returns a tree built from the given
+// list of paths, or an error if a path is invalid.
+func newPathTree(paths []string) (*pathNode, error) {
+	root := &pathNode{}
+	for _, p := range paths {
+		segs, err := splitPath(p)
+		if err != nil {
+			return nil, err
+		}
+		root.insert(segs)
+	}
+	root.expandWildcards()
+
+	return root, nil
+}
+
+// clone returns a deep copy of the tree rooted at n.
+func (n *pathNode) clone() *pathNode {
+	c := &pathNode{end: n.end}
+	if n.children != nil {
+		c.children = make(map[string]*pathNode, len(n.children))
+		for k, v := range n.children {
+			c.children[k] = v.clone()
+		}
+	}
+	if n.wildcard != nil {
+		c.wildcard = n.wildcard.clone()
+	}
+	return c
+}
+
+// pathSegment is a segment of a path, which is either
+// the key of an object's member, or a wildcard that
+// matches any key.
+type pathSegment struct {
+	key      string
+	wildcard bool
+}
+
+// splitPath splits a dot-separated path into a list of
+// segments. A backslash escapes the character that
+// follows it, which allows keys to contain dots, or
+// to be equal to the wildcard character.
+func splitPath(path string) ([]pathSegment, error) {
+	if path == "" {
+		return nil, errors.New("empty path")
+	}
+	var (
+		segs []pathSegment
+		sb   strings.Builder
+		esc  bool // last char was an escape
+		lit  bool // segment has escaped chars
+	)
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case esc:
+			_ = sb.WriteByte(c)
+			esc = false
+		case c == pathEscape:
+			esc, lit = true, true
+		case c == pathSep:
+			if sb.Len() == 0 {
+				return nil, fmt.Errorf("empty segment in path %q", path)
+			}
+			segs = append(segs, newPathSegment(sb.String(), lit))
+			sb.Reset()
+			lit = false
+		default:
+			_ = sb.WriteByte(c)
+		}
+	}
+	if esc {
+		return nil, fmt.Errorf("unterminated escape sequence in path %q", path)
+	}
+	if sb.Len() == 0 {
+		return nil, fmt.Errorf("empty segment in path %q", path)
+	}
+	return append(segs, newPathSegment(sb.String(), lit)), nil
+}
+
+// newPathSegment returns a segment for the key s,
+// which is a wildcard unless lit is true.
+func newPathSegment(s string, lit bool) pathSegment {
+	return pathSegment{
+		key:      s,
+		wildcard: !lit && s == pathWildcard,
+	}
+}
+
+// selectPath returns the allow and deny trees that
+// apply to the value of the member identified by key,
+// and whether the member must be skipped.
+func selectPath(allow, deny *pathNode, key string) (*pathNode, *pathNode, bool) {
+	if deny != nil {
+		if deny = deny.child(key); deny != nil && deny.end {
+			return nil, nil, true
+		}
+	}
+	if allow != nil {
+		if allow = allow.child(key); allow == nil {
+			return nil, nil, true
+		}
+		if allow.end {
+			allow = nil
+		}
+	}
+	return allow, deny, false
+}
+
+// selectPathBytes is similar to selectPath, but
+// takes the key as a byte slice.
+func selectPathBytes(allow, deny *pathNode, key []byte) (*pathNode, *pathNode, bool) {
+	if deny != nil {
+		if deny = deny.childBytes(key); deny != nil && deny.end {
+			return nil, nil, true
+		}
+	}
+	if allow != nil {
+		if allow = allow.childBytes(key); allow == nil {
+			return nil, nil, true
+		}
+		if allow.end {
+			allow = nil
+		}
+	}
+	return allow, deny, false
+}
@@ -0,0 +1,197 @@
+package jettison
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestSplitPath(t *testing.T) {
+	for _, tt := range []struct {
+		path string
+		segs []pathSegment
+	}{
+		{"a", []pathSegment{{key: "a"}}},
+		{"a.b.c", []pathSegment{{key: "a"}, {key: "b"}, {key: "c"}}},
+		{"a.*.c", []pathSegment{{key: "a"}, {key: "*", wildcard: true}, {key: "c"}}},
+		{`a\.b.c`, []pathSegment{{key: "a.b"}, {key: "c"}}},
+		{`\*.a`, []pathSegment{{key: "*"}, {key: "a"}}},
+		{`a\\`, []pathSegment{{key: `a\`}}},
+		{"**", []pathSegment{{key: "**"}}},
+	} {
+		segs, err := splitPath(tt.path)
+		if err != nil {
+			t.Errorf("%s: %s", tt.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(segs, tt.segs) {
+			t.Errorf("%s: got %v, want %v", tt.path, segs, tt.segs)
+		}
+	}
+	for _, path := range []string{
+		"", ".", "a.", ".a", "a..b", `a\`,
+	} {
+		if _, err := splitPath(path); err == nil {
+			t.Errorf("%q: expected non-nil error", path)
+		}
+	}
+}
+
+func TestInvalidPathOpts(t *testing.T) {
+	for _, opt := range []Option{
+		AllowPaths([]string{"a", "b..c"}),
+		DenyPaths([]string{""}),
+	} {
+		_, err := MarshalOpts(struct{}{}, opt)
+		if _, ok := err.(*InvalidOptionError); !ok {
+			t.Errorf("got %T, want InvalidOptionError", err)
+		}
+	}
+}
+
+type (
+	pathAddress struct {
+		Street string `json:"street"`
+		City   string `json:"city"`
+	}
+	pathUser struct {
+		ID      int          `json:"id"`
+		Name    string       `json:"name"`
+		Address *pathAddress `json:"address"`
+	}
+	pathOrder struct {
+		ID     int                    `json:"id"`
+		User   pathUser               `json:"user"`
+		Items  []pathUser             `json:"items"`
+		Prices map[string]pathAddress `json:"prices"`
+		Any    interface{}            `json:"any"`
+	}
+)
+
+func TestPaths(t *testing.T) {
+	u := pathUser{
+		ID:      1,
+		Name:    "Loreum",
+		Address: &pathAddress{"Ipsum", "Dolor"},
+	}
+	v := pathOrder{
+		ID:    42,
+		User:  u,
+		Items: []pathUser{u, {ID: 2}},
+		Prices: map[string]pathAddress{
+			"a": {"1", "2"},
+			"b": {"3", "4"},
+		},
+		Any: map[string]interface{}{
+			"id": 1, "name": "x",
+		},
+	}
+	for _, tt := range []struct {
+		opts []Option
+		want string
+	}{
+		{
+			[]Option{AllowPaths([]string{"id", "user.name", "user.address.city"})},
+			`{"id":42,"user":{"name":"Loreum","address":{"city":"Dolor"}}}`,
+		},
+		{
+			[]Option{AllowPaths([]string{"user", "user.id"})},
+			`{"user":{"id":1,"name":"Loreum","address":{"street":"Ipsum","city":"Dolor"}}}`,
+		},
+		{
+			[]Option{AllowPaths([]string{"items.id", "prices.*.city", "prices.a"})},
+			`{"items":[{"id":1},{"id":2}],"prices":{"a":{"street":"1","city":"2"},"b":{"city":"4"}}}`,
+		},
+		{
+			[]Option{AllowPaths([]string{"*.id"})},
+			`{"id":42,"user":{"id":1},"items":[{"id":1},{"id":2}],"prices":{},"any":{"id":1}}`,
+		},
+		{
+			[]Option{DenyPaths([]string{"user.id", "items.address", "prices.b", "any.*"})},
+			`{"id":42,"user":{"name":"Loreum","address":{"street":"Ipsum","city":"Dolor"}},` +
+				`"items":[{"id":1,"name":"Loreum"},{"id":2,"name":""}],"prices":{"a":{"street":"1","city":"2"}},"any":{}}`,
+		},
+		{
+			[]Option{
+				AllowPaths([]string{"user"}),
+				DenyPaths([]string{"*.address", "user.id"}),
+			},
+			`{"user":{"name":"Loreum"}}`,
+		},
+		{
+			[]Option{AllowPaths([]string{"prices.a.city"}), UnsortedMap()},
+			`{"prices":{"a":{"city":"2"}}}`,
+		},
+		{
+			[]Option{DenyPaths([]string{"prices.a", "prices.b"}), AllowPaths([]string{"prices"})},
+			`{"prices":{}}`,
+		},
+	} {
+		b, err := MarshalOpts(v, tt.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := string(b); s != tt.want {
+			t.Errorf("got %s, want %s", s, tt.want)
+		}
+	}
+}
+
+func TestPathsMapKeys(t *testing.T) {
+	var sm sync.Map
+	sm.Store("a.b", 1)
+	sm.Store(2, 2)
+	sm.Store("<", 3)
+	sm.Store("c", 4)
+
+	for _, tt := range []struct {
+		v    interface{}
+		want string
+	}{
+		{&sm, `{"2":2,"<":3,"a.b":1}`},
+		{map[string]int{"a.b": 1, "c": 2, "<": 3}, `{"<":3,"a.b":1}`},
+		{map[int]int{1: 1, 2: 2, 3: 3}, `{"2":2}`},
+	} {
+		for _, opt := range []Option{nil, UnsortedMap()} {
+			b, err := MarshalOpts(tt.v, opt,
+				NoHTMLEscaping(),
+				AllowPaths([]string{`a\.b`, "2", "<"}),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opt == nil {
+				if s := string(b); s != tt.want {
+					t.Errorf("got %s, want %s", s, tt.want)
+				}
+				continue
+			}
+			var got, want map[string]int
+			if err := json.Unmarshal(b, &got); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		}
+	}
+}
+
+func TestPathsIndent(t *testing.T) {
+	for _, opt := range []Option{nil, UnsortedMap()} {
+		b, err := MarshalOpts(
+			map[string]map[string]int{"a": {"b": 1}},
+			opt, Indent("", " "), AllowPaths([]string{"a.c"}),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s, want := string(b), "{\n \"a\": {}\n}"; s != want {
+			t.Errorf("got %q, want %q", s, want)
+		}
+	}
+}
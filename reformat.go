@@ -0,0 +1,350 @@
+package jettison
+
+import (
+	"io"
+	"strconv"
+)
+
+// Reformat appends to dst the JSON-encoded src, encoded
+// again as if its values were marshaled with the given
+// options: the objects are encoded like maps with string
+// keys, whose members are sorted by key unless the
+// UnsortedMap option is given, in which case the order of
+// the input is kept, and the strings are escaped like
+// Go strings. The options Indent, Canonical, NoHTMLEscaping,
+// NoStringEscaping, NoUTF8Coercion, AllowPaths, DenyPaths,
+// MaxDepth and MaxBytes apply, as well as RejectDuplicateKeys.
+// The numbers are left as is, unless the Canonical option
+// is given.
+//
+// If src is not a single valid JSON value, a SyntaxError
+// that holds the position of the error is returned, along
+// with dst unchanged, like for the other errors.
+func Reformat(dst, src []byte, opts ...Option) ([]byte, error) {
+	eo, err := formatOpts(opts)
+	if err != nil {
+		return dst, err
+	}
+	r := reformatter{scan: newBytesScanner(src), src: src}
+	start := len(dst)
+
+	// The size of the output is counted
+	// from the start of the root value.
+	eo.sizeOff = -len(dst)
+
+	kind, err := r.next()
+	if err == nil {
+		dst, err = r.value(dst, kind, eo)
+	}
+	if err == nil {
+		if _, ok := r.scan.peek(); ok {
+			err = r.scan.invalidChar("after top-level value")
+		}
+	}
+	if err == nil && eo.sizeExceeded(dst) {
+		err = &SizeError{MaxBytes: eo.maxBytes}
+	}
+	if err != nil {
+		return dst[:start], err
+	}
+	return dst, nil
+}
+
+// A reformatter encodes again the values of a valid
+// JSON input, whose tokens are read from a Scanner.
+type reformatter struct {
+	scan Scanner
+	src  []byte
+}
+
+// next returns the kind of the next token.
+func (r *reformatter) next() (TokenKind, error) {
+	kind, err := r.scan.scan()
+	if err == io.EOF {
+		err = errUnexpectedEnd(r.src)
+	}
+	return kind, err
+}
+
+// value appends to dst the value that starts with
+// the last token scanned, whose kind is kind.
+func (r *reformatter) value(dst []byte, kind TokenKind, opts encOpts) ([]byte, error) {
+	raw := r.src[r.scan.start:r.scan.off]
+
+	switch kind {
+	case TokenObjectStart:
+		return r.object(dst, opts)
+	case TokenArrayStart:
+		return r.array(dst, opts)
+	case TokenString:
+		s, err := r.unquote(raw, opts)
+		if err != nil {
+			return dst, err
+		}
+		dst = append(dst, '"')
+		dst = appendEscapedBytes(dst, s, opts)
+		return append(dst, '"'), nil
+	case TokenNumber:
+		if opts.flags.has(canonical) {
+			return appendCanonicalNumber(dst, raw, opts)
+		}
+	}
+	return append(dst, raw...), nil
+}
+
+// unquote returns the content of the string token
+// raw. The lone surrogates are replaced with the
+// Unicode replacement character, like a Go string
+// decoded by Unmarshal, unless the output is in
+// canonical form, which rejects them.
+func (r *reformatter) unquote(raw []byte, opts encOpts) ([]byte, error) {
+	s, ok := unquoteBytes(raw[1:len(raw)-1], nil)
+	if !ok {
+		if opts.flags.has(canonical) {
+			return nil, newSyntaxError("invalid string literal", r.src, r.scan.start)
+		}
+		s = unquoteInvalid(raw[1:len(raw)-1], nil)
+	}
+	return s, nil
+}
+
+func (r *reformatter) array(dst []byte, opts encOpts) ([]byte, error) {
+	if opts.depth++; opts.depthExceeded() {
+		return dst, &DepthError{MaxDepth: opts.maxDepth}
+	}
+	indent := opts.flags.has(indentOutput)
+	nxt := byte('[')
+
+	for i := 0; ; i++ {
+		kind, err := r.next()
+		if err != nil {
+			return dst, err
+		}
+		if kind == TokenArrayEnd {
+			break
+		}
+		dst = append(dst, nxt)
+		nxt = ','
+		if indent {
+			dst = opts.appendIndent(dst)
+		}
+		if dst, err = r.value(dst, kind, opts); err != nil {
+			return dst, withIndexPath(err, i)
+		}
+		if opts.sizeExceeded(dst) {
+			return dst, withIndexPath(&SizeError{MaxBytes: opts.maxBytes}, i)
+		}
+	}
+	if nxt == '[' {
+		return append(dst, "[]"...), nil
+	}
+	if indent {
+		opts.depth--
+		dst = opts.appendIndent(dst)
+	}
+	return append(dst, ']'), nil
+}
+
+// object appends to dst the object that starts with
+// the last token scanned. Its members are encoded
+// like the entries of a map, in a separate buffer
+// before they are sorted, unless the UnsortedMap
+// option is given.
+func (r *reformatter) object(dst []byte, opts encOpts) ([]byte, error) {
+	if opts.depth++; opts.depthExceeded() {
+		return dst, &DepthError{MaxDepth: opts.maxDepth}
+	}
+	dst = append(dst, '{')
+
+	var (
+		err error
+		off = len(dst)
+	)
+	if opts.flags.has(unsortedMap) {
+		dst, err = r.unsortedMembers(dst, opts)
+	} else {
+		dst, err = r.sortedMembers(dst, opts)
+	}
+	if err != nil {
+		return dst, err
+	}
+	// All the members may have been
+	// skipped by the path selection.
+	if opts.flags.has(indentOutput) && len(dst) != off {
+		opts.depth--
+		dst = opts.appendIndent(dst)
+	}
+	return append(dst, '}'), nil
+}
+
+// unsortedMembers appends the members of an object
+// to dst as comma-separated k/v pairs, in the order
+// of the input.
+func (r *reformatter) unsortedMembers(dst []byte, opts encOpts) ([]byte, error) {
+	indent := opts.flags.has(indentOutput)
+	allow, deny := opts.allowPaths, opts.denyPaths
+	hasPaths := allow != nil || deny != nil
+
+	var keys keySet
+	for n := 0; ; {
+		key, ok, err := r.key(&keys, opts)
+		if err != nil || !ok {
+			return dst, err
+		}
+		if hasPaths {
+			var skip bool
+			opts.allowPaths, opts.denyPaths, skip = selectPathBytes(allow, deny, key)
+			if skip {
+				if _, _, err = r.scan.skipValue(); err != nil {
+					return dst, err
+				}
+				continue
+			}
+		}
+		if n != 0 {
+			dst = append(dst, ',')
+		}
+		if indent {
+			dst = opts.appendIndent(dst)
+		}
+		dst = append(dst, '"')
+		dst = appendEscapedBytes(dst, key, opts)
+		dst = append(dst, '"')
+		dst = appendKeySeparator(dst, indent)
+
+		if dst, err = r.memberValue(dst, opts); err != nil {
+			return dst, withKeyPath(err, string(key))
+		}
+		if opts.sizeExceeded(dst) {
+			return dst, withKeyPath(&SizeError{MaxBytes: opts.maxBytes}, string(key))
+		}
+		n++
+	}
+}
+
+// sortedMembers appends the members of an object
+// to dst as comma-separated k/v pairs, sorted by
+// key in the order corresponding to the options.
+func (r *reformatter) sortedMembers(dst []byte, opts encOpts) ([]byte, error) {
+	var (
+		err error
+		off int
+		buf = cachedBuffer()
+		mel *mapElems
+	)
+	if v := mapElemsPool.Get(); v != nil {
+		mel = v.(*mapElems)
+	} else {
+		mel = &mapElems{}
+	}
+	indent := opts.flags.has(indentOutput)
+	allow, deny := opts.allowPaths, opts.denyPaths
+	hasPaths := allow != nil || deny != nil
+
+	// The members are encoded in a separate buffer
+	// before being sorted, whose size must be added
+	// to the one of dst to check the output's size.
+	opts.sizeOff += len(dst)
+
+	var keys keySet
+	for {
+		key, ok, kerr := r.key(&keys, opts)
+		if err = kerr; err != nil || !ok {
+			break
+		}
+		if hasPaths {
+			var skip bool
+			opts.allowPaths, opts.denyPaths, skip = selectPathBytes(allow, deny, key)
+			if skip {
+				if _, _, err = r.scan.skipValue(); err != nil {
+					break
+				}
+				continue
+			}
+		}
+		buf.B = append(buf.B, '"')
+		buf.B = appendEscapedBytes(buf.B, key, opts)
+		buf.B = append(buf.B, '"')
+
+		// Omit quotes of keys.
+		kv := kv{key: buf.B[off+1 : len(buf.B)-1]}
+
+		buf.B = appendKeySeparator(buf.B, indent)
+
+		if buf.B, err = r.memberValue(buf.B, opts); err == nil && opts.sizeExceeded(buf.B) {
+			err = &SizeError{MaxBytes: opts.maxBytes}
+		}
+		if err != nil {
+			err = withKeyPath(err, string(key))
+			break
+		}
+		kv.keyval = buf.B[off:]
+		mel.s = append(mel.s, kv)
+		off = len(buf.B)
+	}
+	if err == nil {
+		// Sort the members by key in the order
+		// of the options, like a map's entries.
+		sortMapElems(mel, opts)
+
+		for i, kv := range mel.s {
+			if i != 0 {
+				dst = append(dst, ',')
+			}
+			if indent {
+				dst = opts.appendIndent(dst)
+			}
+			dst = append(dst, kv.keyval...)
+		}
+	}
+	releaseMapElems(mel)
+	bufferPool.Put(buf)
+
+	return dst, err
+}
+
+// key returns the unescaped key of the next member
+// of an object, and false at the end of the object.
+// The key is added to the set keys, if the duplicate
+// keys are rejected.
+func (r *reformatter) key(keys *keySet, opts encOpts) ([]byte, bool, error) {
+	kind, err := r.next()
+	if err != nil || kind == TokenObjectEnd {
+		return nil, false, err
+	}
+	key, err := r.unquote(r.src[r.scan.start:r.scan.off], opts)
+	if err != nil {
+		return nil, false, err
+	}
+	if opts.flags.has(rejectDuplicateKeys|canonical) && !keys.add(key) {
+		return nil, false, newSyntaxError("duplicate key "+strconv.Quote(string(key)), r.src, r.scan.start)
+	}
+	return key, true, nil
+}
+
+// memberValue appends to dst the value of
+// the member whose key was just scanned.
+func (r *reformatter) memberValue(dst []byte, opts encOpts) ([]byte, error) {
+	kind, err := r.next()
+	if err != nil {
+		return dst, err
+	}
+	return r.value(dst, kind, opts)
+}
+
+// A keySet records the keys of the
+// members of an object.
+type keySet map[string]struct{}
+
+// add adds key to the set, and reports
+// whether it wasn't already present.
+func (ks *keySet) add(key []byte) bool {
+	if *ks == nil {
+		*ks = make(keySet)
+	}
+	if _, ok := (*ks)[string(key)]; ok {
+		return false
+	}
+	(*ks)[string(key)] = struct{}{}
+	return true
+}
@@ -0,0 +1,162 @@
+package jettison
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+// TestReformat tests that the output of Reformat
+// is the one of MarshalOpts for the values decoded
+// from the same input, with the same options.
+func TestReformat(t *testing.T) {
+	for _, src := range []string{
+		`null`,
+		` true `,
+		`-1.50e+10`,
+		`"<a href=\"x\">&amp;</a>\u2028\ud83d\ude00"`,
+		`[]`,
+		`{}`,
+		`[1, "2", [3, {}], {"b": null, "a": false}]`,
+		`{"z": {"y": [1, 2], "x": {"w": ""}}, "a<b": 1e3, "é": "\u00e9", "": 0, "\u0041": [{}]}`,
+	} {
+		for _, opts := range [][]Option{
+			nil,
+			{NoHTMLEscaping()},
+			{NoStringEscaping()},
+			{Indent(">", "  ")},
+			{Canonical()},
+			{AllowPaths([]string{"z.x", "a<b"})},
+			{DenyPaths([]string{"z.*.w", "é"})},
+		} {
+			dec := json.NewDecoder(bytes.NewReader([]byte(src)))
+			dec.UseNumber()
+
+			var v interface{}
+			if err := dec.Decode(&v); err != nil {
+				t.Fatal(err)
+			}
+			want, err := MarshalOpts(v, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Reformat([]byte("x"), []byte(src), opts...)
+			if err != nil {
+				t.Errorf("%s: %s", src, err)
+				continue
+			}
+			if string(got[1:]) != string(want) {
+				t.Errorf("%s: got %s, want %s", src, got[1:], want)
+			}
+		}
+	}
+}
+
+func TestReformatUnsortedMap(t *testing.T) {
+	const src = `{ "c": 1, "a": {"z": [], "y": "<"}, "b": null }`
+
+	b, err := Reformat(nil, []byte(src), UnsortedMap(), NoHTMLEscaping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, want := string(b), `{"c":1,"a":{"z":[],"y":"<"},"b":null}`; s != want {
+		t.Errorf("got %s, want %s", s, want)
+	}
+}
+
+func TestReformatUTF8Coercion(t *testing.T) {
+	src := []byte("[\"a\xffb\"]")
+
+	for _, tt := range []struct {
+		opts []Option
+		want string
+	}{
+		{nil, `["a\ufffdb"]`},
+		{[]Option{NoUTF8Coercion()}, "[\"a\xffb\"]"},
+	} {
+		b, err := Reformat(nil, src, tt.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tt.want {
+			t.Errorf("got %q, want %q", b, tt.want)
+		}
+	}
+}
+
+func TestReformatLoneSurrogate(t *testing.T) {
+	src := []byte(`["a\ud800b"]`)
+
+	b, err := Reformat(nil, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, want := string(b), "[\"a\ufffdb\"]"; s != want {
+		t.Errorf("got %s, want %s", s, want)
+	}
+	// The canonical form rejects them.
+	_, err = Reformat(nil, src, Canonical())
+	if e, ok := err.(*SyntaxError); !ok || e.Offset != 2 {
+		t.Errorf("got %v, want SyntaxError at offset 2", err)
+	}
+}
+
+func TestReformatDuplicateKeys(t *testing.T) {
+	src := []byte("{\"b\": 1,\n \"a\": 2, \"\\u0062\": 3}")
+
+	b, err := Reformat(nil, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(b); s != `{"a":2,"b":1,"b":3}` && s != `{"a":2,"b":3,"b":1}` {
+		t.Errorf("got %s, want all the members", s)
+	}
+	for _, opt := range []Option{RejectDuplicateKeys(), Canonical()} {
+		_, err = Reformat(nil, src, opt)
+
+		e, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("got %v, want SyntaxError", err)
+			continue
+		}
+		if e.Error() != `duplicate key "b"` {
+			t.Errorf("got message %q", e.Error())
+		}
+		if e.Offset != 19 || e.Line != 2 || e.Column != 10 {
+			t.Errorf("got offset %d, line %d, column %d, want 19, 2, 10", e.Offset, e.Line, e.Column)
+		}
+	}
+	// The keys of different objects are independent.
+	if _, err := Reformat(nil, []byte(`[{"a":1},{"a":{"a":2}}]`), RejectDuplicateKeys()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReformatErrors(t *testing.T) {
+	dst := []byte("x")
+
+	b, err := Reformat(dst, []byte("{\"a\": [1,\n 2,]}"))
+	if e, ok := err.(*SyntaxError); !ok || e.Line != 2 || e.Column != 4 {
+		t.Errorf("got %v, want SyntaxError at line 2, column 4", err)
+	}
+	if string(b) != "x" {
+		t.Errorf("got %q, want dst unchanged", b)
+	}
+	for _, src := range []string{``, `[1] 2`, `{"a":1`} {
+		if _, err := Reformat(nil, []byte(src)); err == nil {
+			t.Errorf("%q: expected syntax error", src)
+		}
+	}
+	_, err = Reformat(nil, []byte(`{"a":[{"b":[]}]}`), MaxDepth(3))
+	if e, ok := err.(*DepthError); !ok || e.Path() != "$.a[0].b" {
+		t.Errorf("got %v, want DepthError at $.a[0].b", err)
+	}
+	_, err = Reformat(dst, []byte(`{"a":{"b":"cccc"},"d":1}`), MaxBytes(12))
+	if e, ok := err.(*SizeError); !ok || e.Path() != "$.a.b" {
+		t.Errorf("got %v, want SizeError at $.a.b", err)
+	}
+	_, err = Reformat(nil, []byte(`1`), MaxDepth(-1))
+	if _, ok := err.(*InvalidOptionError); !ok {
+		t.Errorf("got %T, want InvalidOptionError", err)
+	}
+}
@@ -0,0 +1,103 @@
+package jettison
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+const encoderFunc = "EncoderFunc"
+
+var (
+	encodersMu  sync.Mutex
+	encodersPtr unsafe.Pointer // *typeEncoders
+)
+
+// An EncoderFunc appends the JSON encoding of v to dst
+// and returns the extended buffer. v holds a value of
+// the type the function is registered for, and ctx is
+// the context set with the WithContext option.
+type EncoderFunc func(ctx context.Context, dst []byte, v interface{}) ([]byte, error)
+
+// typeEncoders maps Go types to registered encoders.
+// A map is never modified once stored in encodersPtr.
+type typeEncoders map[reflect.Type]EncoderFunc
+
+// RegisterEncoder registers fn as the function used to
+// encode the values of type t, which has precedence over
+// the methods of the marshaler interfaces implemented by
+// t or *t, and over the default encoding of the type.
+// This allows to customize the encoding of types that
+// are defined in other packages. A nil function removes
+// the encoder registered for t, if any.
+//
+// Nil pointers and interfaces are encoded as the JSON
+// null value without calling fn. The function is not
+// used for map keys, which are encoded according to the
+// rules of the json.Marshal function, since they must be
+// JSON strings. Similarly to the output
+// of an AppendMarshaler, the bytes appended by fn are
+// neither validated nor compacted. If fn returns an
+// error, it is wrapped in a MarshalerError. The value v
+// may point to memory owned by the caller of the encoding
+// function, and must not be retained after fn returns.
+//
+// The instructions generated before a registration are
+// invalidated, and are generated again on their next use,
+// except the instruction of a TypedEncoder, which is
+// resolved once, when the encoder is created.
+// Registering encoders is meant to be done once, during
+// the initialization of a program, since invalidating the
+// instructions is costly.
+func RegisterEncoder(t reflect.Type, fn EncoderFunc) {
+	if t == nil {
+		panic("jettison: RegisterEncoder of nil type")
+	}
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+
+	var curr typeEncoders
+	if p := atomic.LoadPointer(&encodersPtr); p != nil {
+		curr = *(*typeEncoders)(p)
+	}
+	encoders := make(typeEncoders, len(curr)+1)
+
+	// Clone the current encoders and
+	// replace the one of the type.
+	for k, v := range curr {
+		encoders[k] = v
+	}
+	if fn != nil {
+		encoders[t] = fn
+	} else {
+		delete(encoders, t)
+	}
+	// Storing a new pointer, even if the encoders
+	// are unchanged, invalidates the compilers of
+	// all the instruction sets.
+	atomic.StorePointer(&encodersPtr, unsafe.Pointer(&encoders))
+}
+
+// encoder returns the function registered to encode
+// the type t in the snapshot of the compiler, if any.
+func (c *compiler) encoder(t reflect.Type) EncoderFunc {
+	if c.encoders == nil {
+		return nil
+	}
+	return (*(*typeEncoders)(c.encoders))[t]
+}
+
+func newEncoderFuncInstr(t reflect.Type, fn EncoderFunc) instruction {
+	enc := func(i interface{}, dst []byte, opts encOpts, t reflect.Type) ([]byte, error) {
+		dst2, err := fn(opts.ctx, dst, i)
+		if err != nil {
+			return dst, &MarshalerError{Type: t, Err: err, funcName: encoderFunc}
+		}
+		return formatAppended(dst2, len(dst), opts, t, encoderFunc)
+	}
+	return func(p unsafe.Pointer, dst []byte, opts encOpts) ([]byte, error) {
+		return encodeMarshaler(p, dst, opts, t, false, enc)
+	}
+}
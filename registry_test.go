@@ -0,0 +1,208 @@
+package jettison
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+type (
+	regPoint     struct{ X, Y int }
+	regMarshaler struct{ V string }
+	regContainer struct {
+		P   regPoint            `json:"p"`
+		PP  *regPoint           `json:"pp"`
+		I   interface{}         `json:"i"`
+		M   map[string]regPoint `json:"m"`
+		S   []regPoint          `json:"s"`
+		JM  regMarshaler        `json:"jm"`
+		NIL *regPoint           `json:"nil"`
+	}
+)
+
+func (m regMarshaler) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(m.V)), nil
+}
+
+func appendPoint(_ context.Context, dst []byte, v interface{}) ([]byte, error) {
+	p := v.(regPoint)
+	dst = append(dst, '[')
+	dst = strconv.AppendInt(dst, int64(p.X), 10)
+	dst = append(dst, ',')
+	dst = strconv.AppendInt(dst, int64(p.Y), 10)
+	return append(dst, ']'), nil
+}
+
+func register(t *testing.T, typ reflect.Type, fn EncoderFunc) {
+	t.Helper()
+	RegisterEncoder(typ, fn)
+	t.Cleanup(func() { RegisterEncoder(typ, nil) })
+}
+
+func TestRegisterEncoder(t *testing.T) {
+	p := regPoint{1, 2}
+	v := regContainer{
+		P:  p,
+		PP: &p,
+		I:  p,
+		M:  map[string]regPoint{"a": p},
+		S:  []regPoint{p},
+		JM: regMarshaler{"x"},
+	}
+	const (
+		def = `{"p":{"X":1,"Y":2},"pp":{"X":1,"Y":2},"i":{"X":1,"Y":2},"m":{"a":{"X":1,"Y":2}},"s":[{"X":1,"Y":2}],"jm":"x","nil":null}`
+		reg = `{"p":[1,2],"pp":[1,2],"i":[1,2],"m":{"a":[1,2]},"s":[[1,2]],"jm":"x","nil":null}`
+	)
+	enc, err := NewEncoder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(want string) {
+		t.Helper()
+		for _, fn := range []func() ([]byte, error){
+			func() ([]byte, error) { return Marshal(v) },
+			func() ([]byte, error) { return enc.Marshal(v) },
+		} {
+			b, err := fn()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s := string(b); s != want {
+				t.Errorf("got %s, want %s", s, want)
+			}
+		}
+		typed, err := NewTypedEncoder[regContainer]()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := typed.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := string(b); s != want {
+			t.Errorf("got %s, want %s", s, want)
+		}
+	}
+	// Encode the value once before the registration
+	// to ensure that the instructions already generated
+	// are invalidated, and once more after removal.
+	check(def)
+	typed, err := NewTypedEncoder[regPoint]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	register(t, reflect.TypeOf(regPoint{}), appendPoint)
+	check(reg)
+	RegisterEncoder(reflect.TypeOf(regPoint{}), nil)
+	check(def)
+
+	// The instruction of a typed encoder is resolved
+	// at creation, and ignores later registrations.
+	register(t, reflect.TypeOf(regPoint{}), appendPoint)
+	b, err := typed.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"X":1,"Y":2}`; string(b) != want {
+		t.Errorf("got %s, want %s", b, want)
+	}
+}
+
+func TestRegisterEncoderPrecedence(t *testing.T) {
+	register(t, reflect.TypeOf(regMarshaler{}),
+		func(_ context.Context, dst []byte, v interface{}) ([]byte, error) {
+			return append(dst, `{"v":`+strconv.Quote(v.(regMarshaler).V)+`}`...), nil
+		},
+	)
+	b, err := Marshal([]interface{}{regMarshaler{"a"}, &regMarshaler{"b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[{"v":"a"},{"v":"b"}]`; string(b) != want {
+		t.Errorf("got %s, want %s", b, want)
+	}
+}
+
+func TestRegisterEncoderMapKey(t *testing.T) {
+	quote := func(_ context.Context, dst []byte, _ interface{}) ([]byte, error) {
+		return append(dst, `"x"`...), nil
+	}
+	register(t, reflect.TypeOf(mkint(0)), quote)
+	register(t, reflect.TypeOf(mkvintMarshaler(0)), quote)
+
+	// The registered encoders apply to the
+	// values of the maps, but not to the keys.
+	for _, tt := range []struct {
+		v    interface{}
+		want string
+	}{
+		{map[mkint]mkint{1: 2}, `{"1":"x"}`},
+		{map[mkvintMarshaler]mkvintMarshaler{1: 2}, `{"MKVINT":"x"}`},
+	} {
+		b, err := Marshal(tt.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := string(b); s != tt.want {
+			t.Errorf("got %s, want %s", s, tt.want)
+		}
+	}
+}
+
+func TestRegisterEncoderContext(t *testing.T) {
+	type ctxKey struct{}
+
+	register(t, reflect.TypeOf(regPoint{}),
+		func(ctx context.Context, dst []byte, _ interface{}) ([]byte, error) {
+			return strconv.AppendQuote(dst, ctx.Value(ctxKey{}).(string)), nil
+		},
+	)
+	ctx := context.WithValue(context.Background(), ctxKey{}, "value")
+
+	b, err := MarshalOpts(regPoint{}, WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `"value"`; string(b) != want {
+		t.Errorf("got %s, want %s", b, want)
+	}
+}
+
+func TestRegisterEncoderError(t *testing.T) {
+	errPoint := errors.New("invalid point")
+
+	register(t, reflect.TypeOf(regPoint{}),
+		func(_ context.Context, dst []byte, _ interface{}) ([]byte, error) {
+			return dst, errPoint
+		},
+	)
+	_, err := Marshal(regContainer{})
+	if err == nil {
+		t.Fatal("expected non-nil error")
+	}
+	var me *MarshalerError
+	if !errors.As(err, &me) {
+		t.Fatalf("got %T, want MarshalerError", err)
+	}
+	if me.Type != reflect.TypeOf(regPoint{}) {
+		t.Errorf("got type %s, want regPoint", me.Type)
+	}
+	if !errors.Is(err, errPoint) {
+		t.Error("expected error to wrap the encoder error")
+	}
+	const want = "json: error calling EncoderFunc for type jettison.regPoint at $.p: invalid point"
+	if s := err.Error(); s != want {
+		t.Errorf("got %q, want %q", s, want)
+	}
+}
+
+func TestRegisterEncoderNilType(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic")
+		}
+	}()
+	RegisterEncoder(nil, appendPoint)
+}
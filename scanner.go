@@ -0,0 +1,461 @@
+package jettison
+
+import (
+	"bytes"
+	"io"
+)
+
+// A TokenKind is the kind of a JSON token.
+type TokenKind uint8
+
+// Kinds of tokens.
+const (
+	TokenObjectStart TokenKind = iota + 1 // {
+	TokenObjectEnd                        // }
+	TokenArrayStart                       // [
+	TokenArrayEnd                         // ]
+	TokenKey                              // the key of an object member
+	TokenString                           // a string value
+	TokenNumber                           // a number value
+	TokenBool                             // true or false
+	TokenNull                             // null
+)
+
+var tokenKindNames = [...]string{
+	TokenObjectStart: "object start",
+	TokenObjectEnd:   "object end",
+	TokenArrayStart:  "array start",
+	TokenArrayEnd:    "array end",
+	TokenKey:         "key",
+	TokenString:      "string",
+	TokenNumber:      "number",
+	TokenBool:        "bool",
+	TokenNull:        "null",
+}
+
+// String implements the fmt.Stringer interface.
+func (k TokenKind) String() string {
+	if int(k) < len(tokenKindNames) && tokenKindNames[k] != "" {
+		return tokenKindNames[k]
+	}
+	return "invalid"
+}
+
+// A Token is a lexical element of a JSON input: the
+// delimiter of an object or an array, the key of an
+// object member, or a literal value. The colons and
+// commas that separate the elements are validated,
+// but not reported as tokens.
+type Token struct {
+	Kind TokenKind
+
+	// Raw holds the bytes of the token in the input,
+	// including the quotes of the keys and strings.
+	// It is only valid until the next call to Next.
+	Raw []byte
+
+	// Offset is the offset of the first byte of
+	// the token from the start of the input.
+	Offset int64
+}
+
+// AppendUnquoted appends to dst the content of a key
+// or string token, with the escape sequences replaced
+// by the characters they represent, and the invalid
+// UTF-8 sequences by the Unicode replacement character.
+func (t Token) AppendUnquoted(dst []byte) []byte {
+	return unquoteInvalid(t.Raw[1:len(t.Raw)-1], dst)
+}
+
+// scanState represents what a Scanner expects
+// to find next in its input.
+type scanState uint8
+
+const (
+	stateValue      scanState = iota // a value, at the top-level or after a colon or a comma in an array
+	stateValueOrEnd                  // a value or the end of an array, after its start
+	stateKey                         // a key, after a comma in an object
+	stateKeyOrEnd                    // a key or the end of an object, after its start
+	stateColon                       // the colon after a key
+	stateCommaOrEnd                  // a comma or the end of an object or array, after a value
+)
+
+// A Scanner splits a JSON input into tokens, and
+// validates its syntax on the fly. The input is a
+// sequence of JSON values, optionally separated by
+// whitespaces, such as a stream of JSON lines.
+//
+// The SyntaxError errors of a Scanner hold the offset,
+// line and column of the error from the start of the
+// input, and a message that names the invalid character
+// and the context in which it was found, such as
+// "invalid character '}' after object key".
+type Scanner struct {
+	r       io.Reader
+	buf     []byte
+	off     int   // offset of the next byte to scan in buf
+	start   int   // offset of the last token scanned in buf
+	mark    int   // offset of the first byte to keep in buf, or -1
+	scanned int64 // amount of data already discarded from buf
+	lines   int   // number of newlines discarded from buf
+	col     int   // number of bytes discarded since the last newline
+	rerr    error // error returned by the reader, including io.EOF
+	err     error // sticky error of Next
+
+	state scanState
+	depth int
+	stack containerStack
+}
+
+// NewScanner returns a new Scanner that
+// reads the tokens of data.
+func NewScanner(data []byte) *Scanner {
+	s := newBytesScanner(data)
+	return &s
+}
+
+// NewReaderScanner returns a new Scanner that reads
+// the tokens of the input read from r. The Scanner
+// introduces its own buffering, and may read data
+// from r beyond the tokens requested.
+func NewReaderScanner(r io.Reader) *Scanner {
+	s := newReaderScanner(r)
+	return &s
+}
+
+func newBytesScanner(data []byte) Scanner {
+	return Scanner{buf: data, rerr: io.EOF, mark: -1}
+}
+
+func newReaderScanner(r io.Reader) Scanner {
+	return Scanner{r: r, mark: -1}
+}
+
+// Depth returns the nesting depth of the objects
+// and arrays at the current position of the input.
+func (s *Scanner) Depth() int { return s.depth }
+
+// InputOffset returns the offset of the current
+// position from the start of the input.
+func (s *Scanner) InputOffset() int64 {
+	return s.scanned + int64(s.off)
+}
+
+// Next returns the next token of the input. At the
+// end of the input, it returns io.EOF, unless the
+// input ends in the middle of a value, in which case
+// it returns a SyntaxError for a byte slice, or the
+// io.ErrUnexpectedEOF error for a reader, like the
+// Unmarshal function and a Decoder do respectively.
+// The errors are returned by all subsequent calls.
+func (s *Scanner) Next() (Token, error) {
+	kind, err := s.scan()
+	if err != nil {
+		return Token{}, err
+	}
+	return Token{
+		Kind:   kind,
+		Raw:    s.buf[s.start:s.off],
+		Offset: s.scanned + int64(s.start),
+	}, nil
+}
+
+// scan scans the next token of the input, whose
+// bytes are those of the buffer from s.start to
+// s.off, and returns its kind.
+func (s *Scanner) scan() (TokenKind, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	kind, err := s.next()
+	if err != nil {
+		s.err = err
+	}
+	return kind, err
+}
+
+func (s *Scanner) next() (TokenKind, error) {
+	for {
+		c, ok := s.peek()
+		if !ok {
+			switch {
+			case s.rerr != io.EOF:
+				return 0, s.rerr
+			case s.depth == 0 && s.state == stateValue:
+				return 0, io.EOF
+			case s.r != nil:
+				return 0, io.ErrUnexpectedEOF
+			}
+			return 0, s.syntaxError(errUnexpectedEnd(s.buf))
+		}
+		switch s.state {
+		case stateColon:
+			if c != ':' {
+				return 0, s.invalidChar("after object key")
+			}
+			s.off++
+			s.state = stateValue
+		case stateCommaOrEnd:
+			obj := s.stack.top(s.depth)
+			switch {
+			case c == ',':
+				s.off++
+				if obj {
+					s.state = stateKey
+				} else {
+					s.state = stateValue
+				}
+			case c == '}' && obj, c == ']' && !obj:
+				return s.end(c), nil
+			case obj:
+				return 0, s.invalidChar("after object key:value pair")
+			default:
+				return 0, s.invalidChar("after array element")
+			}
+		case stateKey, stateKeyOrEnd:
+			if c == '}' && s.state == stateKeyOrEnd {
+				return s.end(c), nil
+			}
+			if c != '"' {
+				return 0, s.invalidChar("looking for beginning of object key string")
+			}
+			if err := s.literal(c); err != nil {
+				return 0, err
+			}
+			s.state = stateColon
+			return TokenKey, nil
+		default:
+			if c == ']' && s.state == stateValueOrEnd {
+				return s.end(c), nil
+			}
+			return s.value(c)
+		}
+	}
+}
+
+// value scans the value that starts with the
+// byte c, and returns the kind of its token.
+func (s *Scanner) value(c byte) (TokenKind, error) {
+	var kind TokenKind
+	switch {
+	case c == '{' || c == '[':
+		if s.depth == maxNestingDepth {
+			return 0, s.syntaxError(errMaxDepth(s.buf, s.off))
+		}
+		kind, s.state = TokenObjectStart, stateKeyOrEnd
+		if c == '[' {
+			kind, s.state = TokenArrayStart, stateValueOrEnd
+		}
+		s.stack.push(s.depth, c == '{')
+		s.depth++
+		s.start = s.off
+		s.off++
+		return kind, nil
+	case c == '"':
+		kind = TokenString
+	case isNumberStart(c):
+		kind = TokenNumber
+	case c == 't' || c == 'f':
+		kind = TokenBool
+	case c == 'n':
+		kind = TokenNull
+	default:
+		return 0, s.invalidChar("looking for beginning of value")
+	}
+	if err := s.literal(c); err != nil {
+		return 0, err
+	}
+	s.endValue()
+
+	return kind, nil
+}
+
+// end scans the end of the current object or
+// array, c, and returns the kind of its token.
+func (s *Scanner) end(c byte) TokenKind {
+	s.start = s.off
+	s.off++
+	s.depth--
+	s.endValue()
+
+	if c == ']' {
+		return TokenArrayEnd
+	}
+	return TokenObjectEnd
+}
+
+// endValue updates the state after a value.
+func (s *Scanner) endValue() {
+	if s.depth == 0 {
+		s.state = stateValue
+	} else {
+		s.state = stateCommaOrEnd
+	}
+}
+
+// literal scans the literal that starts with
+// the byte c at the current offset.
+func (s *Scanner) literal(c byte) error {
+	for {
+		var (
+			end int
+			err error
+		)
+		switch c {
+		case '"':
+			end, err = scanString(s.buf, s.off)
+		case 't':
+			end, err = scanLiteral(s.buf, s.off, "true")
+		case 'f':
+			end, err = scanLiteral(s.buf, s.off, "false")
+		case 'n':
+			end, err = scanLiteral(s.buf, s.off, "null")
+		default:
+			end, err = scanNumber(s.buf, s.off)
+		}
+		if end == len(s.buf) && (err != nil || isNumberStart(c)) && s.r != nil {
+			// The literal may continue with
+			// the data not read yet.
+			if s.rerr == nil {
+				s.refill()
+				continue
+			}
+			if err != nil {
+				if s.rerr != io.EOF {
+					return s.rerr
+				}
+				return io.ErrUnexpectedEOF
+			}
+		}
+		if err != nil {
+			return s.syntaxError(err)
+		}
+		s.start = s.off
+		s.off = end
+
+		return nil
+	}
+}
+
+// peek returns the byte at the current offset
+// after the whitespaces, and false at the end of
+// the input.
+func (s *Scanner) peek() (byte, bool) {
+	for {
+		if s.off = skipSpace(s.buf, s.off); s.off < len(s.buf) {
+			return s.buf[s.off], true
+		}
+		if s.rerr != nil {
+			return 0, false
+		}
+		s.refill()
+	}
+}
+
+// skipValue scans the tokens of the next value of
+// the input, and returns its bytes, which are only
+// valid until the next call, and its offset.
+func (s *Scanner) skipValue() ([]byte, int64, error) {
+	depth := s.depth
+	if _, err := s.scan(); err != nil {
+		return nil, 0, err
+	}
+	s.mark = s.start
+
+	for s.depth != depth {
+		if _, err := s.scan(); err != nil {
+			s.mark = -1
+			return nil, 0, err
+		}
+	}
+	start := s.mark
+	s.mark = -1
+
+	return s.buf[start:s.off], s.scanned + int64(start), nil
+}
+
+// refill discards the data already scanned from the
+// buffer, except from the mark, and reads more data
+// into it from the reader.
+func (s *Scanner) refill() {
+	keep := s.off
+	if s.mark >= 0 && s.mark < keep {
+		keep = s.mark
+	}
+	if keep > 0 {
+		discarded := s.buf[:keep]
+		if n := bytes.Count(discarded, []byte{'\n'}); n != 0 {
+			s.lines += n
+			s.col = len(discarded) - bytes.LastIndexByte(discarded, '\n') - 1
+		} else {
+			s.col += len(discarded)
+		}
+		s.scanned += int64(keep)
+		n := copy(s.buf, s.buf[keep:])
+		s.buf = s.buf[:n]
+		s.off -= keep
+		if s.mark >= 0 {
+			s.mark -= keep
+		}
+	}
+	const minRead = 512
+	if cap(s.buf)-len(s.buf) < minRead {
+		b := make([]byte, len(s.buf), 2*cap(s.buf)+minRead)
+		copy(b, s.buf)
+		s.buf = b
+	}
+	n, err := s.r.Read(s.buf[len(s.buf):cap(s.buf)])
+	s.buf = s.buf[:len(s.buf)+n]
+	s.rerr = err
+}
+
+// invalidChar returns the SyntaxError of the
+// invalid character at the current offset.
+func (s *Scanner) invalidChar(context string) error {
+	return s.syntaxError(errInvalidChar(s.buf, s.off, context))
+}
+
+// syntaxError makes the position of the SyntaxError
+// err, found in the buffer, relative to the start
+// of the input.
+func (s *Scanner) syntaxError(err error) error {
+	e := err.(*SyntaxError)
+	e.Offset += s.scanned
+	if e.Line == 1 {
+		e.Column += s.col
+	}
+	e.Line += s.lines
+
+	return e
+}
+
+// A containerStack records whether the objects
+// and arrays opened at each nesting depth are
+// objects, with the first levels held in a bit
+// set to avoid allocations.
+type containerStack struct {
+	bits uint64
+	deep []bool
+}
+
+func (cs *containerStack) push(depth int, obj bool) {
+	if depth < 64 {
+		if obj {
+			cs.bits |= 1 << uint(depth)
+		} else {
+			cs.bits &^= 1 << uint(depth)
+		}
+		return
+	}
+	cs.deep = append(cs.deep[:depth-64], obj)
+}
+
+// top returns whether the innermost container
+// at the given depth, which is not 0, is an
+// object.
+func (cs *containerStack) top(depth int) bool {
+	if depth <= 64 {
+		return cs.bits&(1<<uint(depth-1)) != 0
+	}
+	return cs.deep[depth-65]
+}
@@ -0,0 +1,251 @@
+package jettison
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+type scannedToken struct {
+	kind   TokenKind
+	raw    string
+	offset int64
+	depth  int
+}
+
+func scanAll(s *Scanner) ([]scannedToken, error) {
+	var toks []scannedToken
+	for {
+		tok, err := s.Next()
+		if err != nil {
+			return toks, err
+		}
+		toks = append(toks, scannedToken{tok.Kind, string(tok.Raw), tok.Offset, s.Depth()})
+	}
+}
+
+func TestScanner(t *testing.T) {
+	const input = ` {"a": [1, -2.5e3, "x\"y"], "b" : {"c":true,"d":null},
+	"e": [], "f": {}} 42 "s"`
+
+	want := []scannedToken{
+		{TokenObjectStart, `{`, 1, 1},
+		{TokenKey, `"a"`, 2, 1},
+		{TokenArrayStart, `[`, 7, 2},
+		{TokenNumber, `1`, 8, 2},
+		{TokenNumber, `-2.5e3`, 11, 2},
+		{TokenString, `"x\"y"`, 19, 2},
+		{TokenArrayEnd, `]`, 25, 1},
+		{TokenKey, `"b"`, 28, 1},
+		{TokenObjectStart, `{`, 34, 2},
+		{TokenKey, `"c"`, 35, 2},
+		{TokenBool, `true`, 39, 2},
+		{TokenKey, `"d"`, 44, 2},
+		{TokenNull, `null`, 48, 2},
+		{TokenObjectEnd, `}`, 52, 1},
+		{TokenKey, `"e"`, 56, 1},
+		{TokenArrayStart, `[`, 61, 2},
+		{TokenArrayEnd, `]`, 62, 1},
+		{TokenKey, `"f"`, 65, 1},
+		{TokenObjectStart, `{`, 70, 2},
+		{TokenObjectEnd, `}`, 71, 1},
+		{TokenObjectEnd, `}`, 72, 0},
+		{TokenNumber, `42`, 74, 0},
+		{TokenString, `"s"`, 77, 0},
+	}
+	for name, s := range map[string]*Scanner{
+		"bytes":  NewScanner([]byte(input)),
+		"reader": NewReaderScanner(iotest.OneByteReader(strings.NewReader(input))),
+	} {
+		toks, err := scanAll(s)
+		if err != io.EOF {
+			t.Errorf("%s: got error %v, want EOF", name, err)
+		}
+		if len(toks) != len(want) {
+			t.Fatalf("%s: got %d tokens, want %d", name, len(toks), len(want))
+		}
+		for i, tok := range toks {
+			if tok != want[i] {
+				t.Errorf("%s: token %d: got %+v, want %+v", name, i, tok, want[i])
+			}
+		}
+		if off := s.InputOffset(); off != int64(len(input)) {
+			t.Errorf("%s: got input offset %d, want %d", name, off, len(input))
+		}
+	}
+}
+
+func TestTokenAppendUnquoted(t *testing.T) {
+	s := NewScanner([]byte(`{"kéy":"a\tb😀"}`))
+	for _, want := range []string{"", "kéy", "a\tb😀"} {
+		tok, err := s.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want == "" {
+			continue
+		}
+		if got := string(tok.AppendUnquoted(nil)); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestScannerErrors(t *testing.T) {
+	for _, tt := range []struct {
+		data   string
+		msg    string
+		offset int64
+	}{
+		{`{"a":1,}`, "invalid character '}' looking for beginning of object key string", 8},
+		{`[1,]`, "invalid character ']' looking for beginning of value", 4},
+		{`{"a" 1}`, "invalid character '1' after object key", 6},
+		{`{1:1}`, "invalid character '1' looking for beginning of object key string", 2},
+		{`[1 2]`, "invalid character '2' after array element", 4},
+		{`{"a":1 "b":2}`, "invalid character '\"' after object key:value pair", 8},
+		{`[01]`, "invalid character '1' after array element", 3},
+		{`"\x"`, "invalid character 'x' in string escape code", 3},
+		{"\"\x01\"", "invalid character '\\x01' in string literal", 2},
+		{`"abc`, "unexpected end of JSON input", 4},
+		{`nul`, "unexpected end of JSON input", 3},
+		{`tru e`, "invalid character ' ' in literal true (expecting 'e')", 4},
+		{`]`, "invalid character ']' looking for beginning of value", 1},
+		{`-`, "unexpected end of JSON input", 1},
+		{`[1.]`, "invalid character ']' after decimal point in numeric literal", 4},
+		{`{"a":[`, "unexpected end of JSON input", 6},
+		{strings.Repeat(`[`, maxNestingDepth+1), "exceeded max nesting depth of 10000", maxNestingDepth + 1},
+	} {
+		_, err := scanAll(NewScanner([]byte(tt.data)))
+
+		e, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("%.20q: got %v, want SyntaxError", tt.data, err)
+			continue
+		}
+		if e.Error() != tt.msg {
+			t.Errorf("%.20q: got message %q, want %q", tt.data, e.Error(), tt.msg)
+		}
+		if e.Offset != tt.offset {
+			t.Errorf("%.20q: got offset %d, want %d", tt.data, e.Offset, tt.offset)
+		}
+	}
+	// An input read from a reader that ends in the
+	// middle of a value is reported like a Decoder.
+	for _, data := range []string{`{"a":`, `[1, `, `"ab`, `tr`, `-`} {
+		_, err := scanAll(NewReaderScanner(strings.NewReader(data)))
+		if err != io.ErrUnexpectedEOF {
+			t.Errorf("%q: got %v, want ErrUnexpectedEOF", data, err)
+		}
+	}
+	readErr := errors.New("read error")
+	s := NewReaderScanner(io.MultiReader(strings.NewReader(`[1,`), iotest.ErrReader(readErr)))
+	if _, err := scanAll(s); err != readErr {
+		t.Errorf("got %v, want %v", err, readErr)
+	}
+	// The errors are sticky.
+	if _, err := s.Next(); err != readErr {
+		t.Errorf("got %v, want %v", err, readErr)
+	}
+}
+
+func TestSyntaxErrorPosition(t *testing.T) {
+	for _, tt := range []struct {
+		data   string
+		offset int64
+		line   int
+		column int
+	}{
+		{`[1,]`, 4, 1, 4},
+		{"{\n  \"a\": 1,\n  \"b\" 2\n}", 19, 3, 7},
+		{"[\n\ttrue,\n\tfalsy\n]", 15, 3, 6},
+		{"[1,\n2,\n", 7, 3, 1},
+		{"{\"a\":\n\"\x01\"}", 8, 2, 2},
+	} {
+		for name, s := range map[string]*Scanner{
+			"bytes":  NewScanner([]byte(tt.data)),
+			"reader": NewReaderScanner(iotest.OneByteReader(strings.NewReader(tt.data))),
+		} {
+			_, err := scanAll(s)
+			if name == "reader" && strings.HasSuffix(tt.data, "\n") {
+				// The end of the input of a reader is
+				// reported as an unexpected EOF.
+				if err != io.ErrUnexpectedEOF {
+					t.Errorf("%s: %q: got %v, want ErrUnexpectedEOF", name, tt.data, err)
+				}
+				continue
+			}
+			e, ok := err.(*SyntaxError)
+			if !ok {
+				t.Errorf("%s: %q: got %v, want SyntaxError", name, tt.data, err)
+				continue
+			}
+			if e.Offset != tt.offset || e.Line != tt.line || e.Column != tt.column {
+				t.Errorf("%s: %q: got offset %d, line %d, column %d, want %d, %d, %d",
+					name, tt.data, e.Offset, e.Line, e.Column, tt.offset, tt.line, tt.column,
+				)
+			}
+		}
+	}
+	// The position of the errors of Unmarshal.
+	err := Unmarshal([]byte("{\n  \"a\": tru\n}"), new(interface{}))
+	if e, ok := err.(*SyntaxError); !ok || e.Line != 2 || e.Column != 11 {
+		t.Errorf("got %v, want SyntaxError at line 2, column 11", err)
+	}
+}
+
+type rawMarshaler string
+
+func (m rawMarshaler) MarshalJSON() ([]byte, error) { return []byte(m), nil }
+
+func TestMarshalerSyntaxError(t *testing.T) {
+	for _, tt := range []struct {
+		out string
+		msg string
+	}{
+		{``, "unexpected end of JSON input"},
+		{`{"a":1,}`, "invalid character '}' looking for beginning of object key string"},
+		{`[1 2]`, "invalid character '2' after array element"},
+		{`"a`, "unexpected end of JSON input"},
+		{`1 2`, "invalid character '2' after top-level value"},
+		{`{"a":` + "\n" + `x}`, "invalid character 'x' looking for beginning of value"},
+		{`nul`, "unexpected end of JSON input"},
+	} {
+		_, err := Marshal(rawMarshaler(tt.out))
+
+		var e *SyntaxError
+		if !errors.As(err, &e) {
+			t.Errorf("%q: got %v, want SyntaxError", tt.out, err)
+			continue
+		}
+		if _, ok := err.(*MarshalerError); !ok {
+			t.Errorf("%q: got %T, want MarshalerError", tt.out, err)
+		}
+		if e.Error() != tt.msg {
+			t.Errorf("%q: got message %q, want %q", tt.out, e.Error(), tt.msg)
+		}
+	}
+	// Unlike the encoding/json package, which
+	// reports an offset of 0, the position of the
+	// error in the output is reported.
+	_, err := Marshal(rawMarshaler(`{"a":` + "\n" + `x}`))
+
+	var e *SyntaxError
+	if !errors.As(err, &e) || e.Offset != 7 || e.Line != 2 || e.Column != 1 {
+		t.Errorf("got %v, want SyntaxError at offset 7, line 2, column 1", err)
+	}
+	// The output of a valid marshaler is compacted
+	// like the encoding/json package does.
+	out := rawMarshaler("{ \"a<\" : [ 1 ,\n\t\"\u2028&\" ] , \"b\":{ } }")
+
+	b1, err1 := Marshal(out)
+	b2, err2 := json.Marshal(out)
+	if err1 != nil || err2 != nil {
+		t.Fatal(err1, err2)
+	}
+	if string(b1) != string(b2) {
+		t.Errorf("got %s, want %s", b1, b2)
+	}
+}
@@ -0,0 +1,324 @@
+package jettison
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A SelectionSyntaxError describes a malformed field
+// selection expression given to the Fields option.
+type SelectionSyntaxError struct {
+	Expr   string // expression
+	Offset int    // byte offset of the error in Expr
+	msg    string
+}
+
+// Error implements the builtin error interface.
+func (e *SelectionSyntaxError) Error() string {
+	return fmt.Sprintf("invalid field selection %q: %s at offset %d",
+		e.Expr, e.msg, e.Offset)
+}
+
+// A SelectionError describes a field selection that
+// references a field which does not exist in the type
+// of the value to encode.
+type SelectionError struct {
+	Type reflect.Type // type of the root value
+	Path string       // dot-separated path of the field
+}
+
+// Error implements the builtin error interface.
+func (e *SelectionError) Error() string {
+	return fmt.Sprintf("json: unknown field %q selected for type %s",
+		e.Path, e.Type)
+}
+
+// selection represents a parsed field selection
+// expression. The tree used to validate the
+// selection against a type keeps the wildcards
+// apart from the named keys, unlike the tree used
+// during encoding.
+type selection struct {
+	tree *pathNode
+	raw  *pathNode
+
+	// checks caches the result of the validation
+	// of the selection against the types encoded.
+	checks sync.Map // map[reflect.Type]*selectionCheck
+}
+
+// selectionCheck is the result of the validation of a
+// selection against a type, made with the compiler c.
+// The result depends on the encoders registered, and
+// must be computed again if the compiler changed.
+type selectionCheck struct {
+	c   *compiler
+	err error
+}
+
+// Fields sets the fields to encode with a selection
+// expression, as commonly used in the query string of
+// web APIs to request partial responses, for example:
+//
+//	id,author(name,email),tags
+//
+// The expression is a comma-separated list of keys,
+// where each key can be followed by a parenthesized
+// selection that applies to the members of the object
+// it identifies. The wildcard "*" matches any key,
+// and a backslash escapes the character that follows
+// it. Like AllowPaths, which this option replaces,
+// the elements of arrays and slices are transparent,
+// and the selection applies to map keys too.
+// A SelectionError is returned if the selection
+// references a struct field that does not exist in
+// the type of the value to encode, unless an option
+// AllowPaths or DenyPaths is applied after this one.
+func Fields(expr string) Option {
+	sel, err := parseSelection(expr)
+	return func(o *encOpts) {
+		if err != nil {
+			o.config().err = err
+			return
+		}
+		o.allowPaths = sel.tree
+		o.config().selection = sel
+	}
+}
+
+// parseSelection parses a field selection expression.
+func parseSelection(expr string) (*selection, error) {
+	p := selectionParser{expr: expr}
+	raw := &pathNode{}
+
+	if err := p.parseList(raw, nil, false); err != nil {
+		return nil, err
+	}
+	tree := raw.clone()
+	tree.expandWildcards()
+
+	return &selection{tree: tree, raw: raw}, nil
+}
+
+type selectionParser struct {
+	expr string
+	pos  int
+}
+
+func (p *selectionParser) errorf(format string, args ...interface{}) error {
+	return &SelectionSyntaxError{
+		Expr:   p.expr,
+		Offset: p.pos,
+		msg:    fmt.Sprintf(format, args...),
+	}
+}
+
+func (p *selectionParser) skipSpaces() {
+	for p.pos < len(p.expr) && p.expr[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// parseList parses a comma-separated list of keys,
+// and inserts the corresponding paths, prefixed with
+// the segments of the parent, in the tree rooted at n.
+// nested indicates whether the list is enclosed with
+// parentheses.
+func (p *selectionParser) parseList(n *pathNode, parent []pathSegment, nested bool) error {
+	for {
+		p.skipSpaces()
+		seg, err := p.parseKey()
+		if err != nil {
+			return err
+		}
+		path := append(parent[:len(parent):len(parent)], seg)
+
+		p.skipSpaces()
+		if p.pos < len(p.expr) && p.expr[p.pos] == '(' {
+			p.pos++
+			if err := p.parseList(n, path, true); err != nil {
+				return err
+			}
+			p.skipSpaces()
+		} else {
+			n.insert(path)
+		}
+		if p.pos == len(p.expr) {
+			if nested {
+				return p.errorf("missing closing parenthesis")
+			}
+			return nil
+		}
+		switch c := p.expr[p.pos]; c {
+		case ',':
+			p.pos++
+		case ')':
+			if !nested {
+				return p.errorf("unexpected closing parenthesis")
+			}
+			p.pos++
+			return nil
+		default:
+			return p.errorf("unexpected character %q", c)
+		}
+	}
+}
+
+// parseKey parses a key, which ends with the first
+// unescaped comma, parenthesis, or space character.
+func (p *selectionParser) parseKey() (pathSegment, error) {
+	var (
+		sb  strings.Builder
+		lit bool
+	)
+loop:
+	for ; p.pos < len(p.expr); p.pos++ {
+		switch c := p.expr[p.pos]; c {
+		case ',', '(', ')', ' ':
+			break loop
+		case pathEscape:
+			if p.pos+1 == len(p.expr) {
+				return pathSegment{}, p.errorf("unterminated escape sequence")
+			}
+			p.pos++
+			lit = true
+			_ = sb.WriteByte(p.expr[p.pos])
+		default:
+			_ = sb.WriteByte(c)
+		}
+	}
+	if sb.Len() == 0 {
+		if p.pos == len(p.expr) {
+			return pathSegment{}, p.errorf("unexpected end of expression")
+		}
+		return pathSegment{}, p.errorf("expected key, found %q", p.expr[p.pos])
+	}
+	return newPathSegment(sb.String(), lit), nil
+}
+
+// validate returns a SelectionError if the selection
+// references a struct field that does not exist in t.
+// The fields of the structs are looked up in is. The
+// result is cached per type, since the validation
+// walks the type tree.
+func (s *selection) validate(t reflect.Type, is *instrSet) error {
+	if t == nil {
+		return nil
+	}
+	c := is.compiler()
+
+	if v, ok := s.checks.Load(t); ok {
+		if sc := v.(*selectionCheck); sc.c == c {
+			return sc.err
+		}
+	}
+	sc := &selectionCheck{c: c}
+
+	if path, ok := validateSelection(s.raw, t, nil, c); !ok {
+		sc.err = &SelectionError{
+			Type: t,
+			Path: strings.Join(path, "."),
+		}
+	}
+	s.checks.Store(t, sc)
+
+	return sc.err
+}
+
+// validateSelection checks the keys selected by the
+// tree rooted at n against the type t. It returns the
+// path of the first unknown field found, and false,
+// if any.
+func validateSelection(n *pathNode, t reflect.Type, path []string, c *compiler) ([]string, bool) {
+	if n.end {
+		return nil, true
+	}
+	// Array and slice elements are transparent,
+	// similarly to pointers.
+	for {
+		k := t.Kind()
+		if k != reflect.Ptr && k != reflect.Slice && k != reflect.Array {
+			break
+		}
+		t = t.Elem()
+	}
+	if isOpaqueType(t) || c.encoder(t) != nil {
+		// The members of values that are encoded by
+		// a marshaler or a registered encoder, or the
+		// values whose type is only known at runtime,
+		// can't be validated.
+		return nil, true
+	}
+	if t.Kind() != reflect.Struct {
+		// All the keys are unknown for a type
+		// that is not encoded as an object.
+		if keys := sortedKeys(n); len(keys) != 0 {
+			return append(path, keys[0]), false
+		}
+		// Only a wildcard remains, which can't
+		// match anything, but is not an error.
+		return nil, true
+	}
+	flds := c.cachedFields(t)
+
+	for _, k := range sortedKeys(n) {
+		var f *field
+		for i := range flds {
+			if flds[i].name == k {
+				f = &flds[i]
+				break
+			}
+		}
+		if f == nil {
+			if hasInlineField(flds) {
+				// The key may be the one of an
+				// entry of the inline map.
+				continue
+			}
+			return append(path, k), false
+		}
+		if p, ok := validateSelection(n.children[k], f.typ, append(path, k), c); !ok {
+			return p, false
+		}
+	}
+	return nil, true
+}
+
+// sortedKeys returns the keys of the children of n
+// in increasing order.
+func sortedKeys(n *pathNode) []string {
+	keys := make([]string, 0, len(n.children))
+	for k := range n.children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// isOpaqueType returns whether the members of the
+// values of type t can be selected without being
+// known beforehand.
+func isOpaqueType(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Map, reflect.Interface:
+		return true
+	}
+	if newGoTypeInstr(t) != nil {
+		return true
+	}
+	for _, mt := range []reflect.Type{
+		appendMarshalerCtxType,
+		appendMarshalerType,
+		jsonMarshalerType,
+		textMarshalerType,
+	} {
+		if t.Implements(mt) || reflect.PtrTo(t).Implements(mt) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,184 @@
+package jettison
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type (
+	selAuthor struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+		Age   int    `json:"age"`
+	}
+	selArticle struct {
+		ID      int                    `json:"id"`
+		Title   string                 `json:"title"`
+		Author  *selAuthor             `json:"author"`
+		Authors []selAuthor            `json:"authors"`
+		Tags    []string               `json:"tags"`
+		Meta    map[string]interface{} `json:"meta"`
+		Date    time.Time              `json:"date"`
+	}
+)
+
+func TestFields(t *testing.T) {
+	a := selAuthor{"Loreum", "loreum@ipsum.com", 42}
+	v := selArticle{
+		ID:      1,
+		Title:   "Dolor",
+		Author:  &a,
+		Authors: []selAuthor{a, a},
+		Tags:    []string{"a", "b"},
+		Meta: map[string]interface{}{
+			"x": map[string]int{"y": 1, "z": 2},
+			"w": 3,
+		},
+	}
+	for _, tt := range []struct {
+		expr string
+		want string
+	}{
+		{"id", `{"id":1}`},
+		{"id,author(name,email),tags", `{"id":1,"author":{"name":"Loreum","email":"loreum@ipsum.com"},"tags":["a","b"]}`},
+		{" id , author ( name ) ", `{"id":1,"author":{"name":"Loreum"}}`},
+		{"authors(age)", `{"authors":[{"age":42},{"age":42}]}`},
+		{"meta(x(z))", `{"meta":{"x":{"z":2}}}`},
+		{"meta(*(y),w)", `{"meta":{"w":3,"x":{"y":1}}}`},
+		{"author(name),author", `{"author":{"name":"Loreum","email":"loreum@ipsum.com","age":42}}`},
+		{"*(name)", `{"id":1,"title":"Dolor","author":{"name":"Loreum"},"authors":[{"name":"Loreum"},{"name":"Loreum"}],"tags":["a","b"],"meta":{},"date":"0001-01-01T00:00:00Z"}`},
+	} {
+		b, err := MarshalOpts(v, Fields(tt.expr))
+		if err != nil {
+			t.Errorf("%s: %s", tt.expr, err)
+			continue
+		}
+		if s := string(b); s != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.expr, s, tt.want)
+		}
+	}
+}
+
+func TestFieldsValidationCache(t *testing.T) {
+	sel, err := parseSelection("id,author(nope)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	is := defaultEncOpts().iset
+	typ := reflect.TypeOf(selArticle{})
+
+	err1 := sel.validate(typ, is)
+	if _, ok := err1.(*SelectionError); !ok {
+		t.Fatalf("got %T, want SelectionError", err1)
+	}
+	if err2 := sel.validate(typ, is); err2 != err1 {
+		t.Error("expected the validation result to be cached")
+	}
+	// The author field is opaque once an encoder is
+	// registered for its type, which invalidates the
+	// cached result.
+	register(t, reflect.TypeOf(selAuthor{}), appendPoint)
+	if err := sel.validate(typ, is); err != nil {
+		t.Errorf("got %v, want nil", err)
+	}
+	n := testing.AllocsPerRun(10, func() {
+		_ = sel.validate(typ, is)
+	})
+	if n != 0 {
+		t.Errorf("got %v allocs, want 0", n)
+	}
+}
+
+func TestFieldsSyntaxError(t *testing.T) {
+	for _, tt := range []struct {
+		expr   string
+		offset int
+	}{
+		{"", 0},
+		{"a,", 2},
+		{"a,,b", 2},
+		{"a(b", 3},
+		{"a()", 2},
+		{"a)", 1},
+		{"a(b))", 4},
+		{"a b", 2},
+		{`a\`, 1},
+	} {
+		_, err := MarshalOpts(selArticle{}, Fields(tt.expr))
+		if err == nil {
+			t.Errorf("%q: expected non-nil error", tt.expr)
+			continue
+		}
+		ioe, ok := err.(*InvalidOptionError)
+		if !ok {
+			t.Errorf("%q: got %T, want InvalidOptionError", tt.expr, err)
+			continue
+		}
+		var se *SelectionSyntaxError
+		if !errors.As(ioe.Err, &se) {
+			t.Errorf("%q: got %T, want SelectionSyntaxError", tt.expr, ioe.Err)
+			continue
+		}
+		if se.Offset != tt.offset {
+			t.Errorf("%q: got offset %d, want %d", tt.expr, se.Offset, tt.offset)
+		}
+	}
+}
+
+func TestFieldsUnknown(t *testing.T) {
+	for _, tt := range []struct {
+		expr string
+		path string
+	}{
+		{"nope", "nope"},
+		{"id,author(name,nope)", "author.nope"},
+		{"authors(name(first))", "authors.name.first"},
+		{"tags(a)", "tags.a"},
+		{"id(a,b)", "id.a"},
+	} {
+		for _, v := range []interface{}{selArticle{}, &selArticle{}, []selArticle{}} {
+			_, err := MarshalOpts(v, Fields(tt.expr))
+			se, ok := err.(*SelectionError)
+			if !ok {
+				t.Errorf("%s: got %T, want SelectionError", tt.expr, err)
+				continue
+			}
+			if se.Path != tt.path {
+				t.Errorf("%s: got path %s, want %s", tt.expr, se.Path, tt.path)
+			}
+		}
+	}
+	// Opaque types, and wildcards are not validated.
+	for _, expr := range []string{
+		"meta(a(b))", "date(a)", "*(a)",
+	} {
+		if _, err := MarshalOpts(selArticle{}, Fields(expr)); err != nil {
+			t.Errorf("%s: %s", expr, err)
+		}
+	}
+	// The paths set after the selection replace it,
+	// and disable its validation.
+	for _, opt := range []Option{
+		AllowPaths([]string{"id"}),
+		DenyPaths([]string{"title"}),
+	} {
+		if _, err := MarshalOpts(selArticle{}, Fields("id,nope"), opt); err != nil {
+			t.Error(err)
+		}
+	}
+	// Typed encoders validate the selection once.
+	_, err := NewTypedEncoder[selArticle](Fields("id,nope"))
+	if _, ok := err.(*SelectionError); !ok {
+		t.Errorf("got %T, want SelectionError", err)
+	}
+	e, err := NewEncoder(Fields("id,nope"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = TypedEncoderOf[selArticle](e).Marshal(selArticle{})
+	if _, ok := err.(*SelectionError); !ok {
+		t.Errorf("got %T, want SelectionError", err)
+	}
+}
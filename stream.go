@@ -0,0 +1,178 @@
+package jettison
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// WriteError is the error returned by a StreamEncoder
+// when the JSON encoding of a value, which succeeded,
+// could not be written entirely to the underlying
+// writer. The Err field is io.ErrShortWrite if the
+// writer accepted fewer bytes than given without
+// returning an error of its own.
+type WriteError struct {
+	Written int // number of bytes written
+	Err     error
+}
+
+// Error implements the builtin error interface.
+func (e *WriteError) Error() string {
+	return fmt.Sprintf("json: write error after %d bytes: %s",
+		e.Written, e.Err.Error())
+}
+
+// Unwrap returns the error wrapped by e.
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
+
+// A StreamEncoder writes the JSON encoding of values
+// to an output stream, one value per call to Encode.
+// Unlike an Encoder, a StreamEncoder is not safe for
+// concurrent use by multiple goroutines.
+type StreamEncoder struct {
+	w       io.Writer
+	opts    encOpts
+	newline bool
+}
+
+// NewStreamEncoder returns a new StreamEncoder that
+// writes to w, configured with the given options.
+// An InvalidOptionError is returned if one of the
+// options is invalid.
+func NewStreamEncoder(w io.Writer, opts ...Option) (*StreamEncoder, error) {
+	eo, err := defaultEncOpts().with(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return newStreamEncoder(w, eo), nil
+}
+
+// NewStreamEncoder returns a new StreamEncoder that
+// writes to w, using the options of the encoder.
+func (e *Encoder) NewStreamEncoder(w io.Writer) *StreamEncoder {
+	return newStreamEncoder(w, e.opts)
+}
+
+func newStreamEncoder(w io.Writer, opts encOpts) *StreamEncoder {
+	return &StreamEncoder{
+		w:       w,
+		opts:    opts,
+		newline: true,
+	}
+}
+
+// SetTrailingNewline configures whether a newline
+// character is written after each encoded value.
+// This is enabled by default, which mirrors the
+// behavior of the json.Encoder type.
+func (e *StreamEncoder) SetTrailingNewline(on bool) {
+	e.newline = on
+}
+
+// Encode writes the JSON encoding of v to the stream.
+// Nothing is written if the encoding fails, and the
+// error is returned as is. Errors returned by the
+// underlying writer, or short writes, are reported
+// with a WriteError.
+func (e *StreamEncoder) Encode(v interface{}) error {
+	var (
+		err error
+		buf = cachedBuffer()
+	)
+	if v == nil {
+		buf.B = append(buf.B, "null"...)
+	} else {
+		buf.B, err = appendJSON(buf.B, v, e.opts)
+	}
+	if err == nil {
+		if e.newline {
+			buf.B = append(buf.B, '\n')
+		}
+		n, werr := e.w.Write(buf.B)
+		if werr == nil && n < len(buf.B) {
+			werr = io.ErrShortWrite
+		}
+		if werr != nil {
+			err = &WriteError{Written: n, Err: werr}
+		}
+	}
+	bufferPool.Put(buf)
+
+	return err
+}
+
+// A Decoder reads and decodes JSON values from an
+// input stream, with the same rules as Unmarshal.
+type Decoder struct {
+	scan Scanner
+	opts encOpts
+
+	useNumber             bool
+	disallowUnknownFields bool
+}
+
+// NewDecoder returns a new Decoder that reads from r.
+// The Decoder introduces its own buffering and may read
+// data from r beyond the JSON values requested.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{scan: newReaderScanner(r), opts: defaultEncOpts()}
+}
+
+// Options sets the options of the Decoder, which replace
+// the ones set previously, with the same rules as the
+// UnmarshalOpts function. If the options are invalid,
+// an InvalidOptionError is returned, and the options
+// of the Decoder are left unchanged.
+func (dec *Decoder) Options(opts ...Option) error {
+	eo, err := decodeOpts(opts)
+	if err != nil {
+		return err
+	}
+	dec.opts = eo
+	return nil
+}
+
+// UseNumber causes the Decoder to unmarshal a number
+// into an empty interface as a json.Number instead of
+// as a float64.
+func (dec *Decoder) UseNumber() { dec.useNumber = true }
+
+// DisallowUnknownFields causes the Decoder to return an
+// error when the destination is a struct and the input
+// contains object keys which match no field of the struct
+// and the struct has no inline map to store them.
+func (dec *Decoder) DisallowUnknownFields() { dec.disallowUnknownFields = true }
+
+// Buffered returns a reader of the data remaining in
+// the buffer of the Decoder. The reader is valid until
+// the next call to Decode.
+func (dec *Decoder) Buffered() io.Reader {
+	return bytes.NewReader(dec.scan.buf[dec.scan.off:])
+}
+
+// Decode reads the next JSON value from its input
+// and stores it in the value pointed to by v. The
+// offsets of the errors are relative to the start of
+// the input. The io.EOF error is returned when the
+// input has no more values, and a syntax or read error
+// is returned by all subsequent calls.
+func (dec *Decoder) Decode(v interface{}) error {
+	data, start, err := dec.scan.skipValue()
+	if err != nil {
+		return err
+	}
+	d := newDecodeState(data, dec.opts)
+	d.useNumber = dec.useNumber
+	d.disallowUnknownFields = dec.disallowUnknownFields
+
+	err = d.unmarshal(v)
+	d.release()
+
+	if e, ok := err.(*UnmarshalTypeError); ok {
+		e.Offset += start
+	}
+	return err
+}
@@ -0,0 +1,278 @@
+package jettison
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestStreamEncoder(t *testing.T) {
+	var (
+		b1 bytes.Buffer
+		b2 bytes.Buffer
+	)
+	enc, err := NewStreamEncoder(&b1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	std := json.NewEncoder(&b2)
+
+	for _, v := range []interface{}{
+		nil, xx, &xx, "Loreum", 42, []int{1, 2},
+	} {
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+		if err := std.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(b1.Bytes(), b2.Bytes()) {
+		t.Errorf("non-equal outputs:\n%s\n%s", b1.String(), b2.String())
+	}
+}
+
+func TestStreamEncoderNoNewline(t *testing.T) {
+	var buf bytes.Buffer
+
+	e, err := NewEncoder(NoHTMLEscaping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := e.NewStreamEncoder(&buf)
+	enc.SetTrailingNewline(false)
+
+	for _, v := range []interface{}{"<", 1, nil} {
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s, want := buf.String(), `"<"1null`; s != want {
+		t.Errorf("got %s, want %s", s, want)
+	}
+}
+
+func TestInvalidStreamEncoderOpts(t *testing.T) {
+	_, err := NewStreamEncoder(io.Discard, TimeLayout(""))
+	if err == nil {
+		t.Fatal("expected non-nil error")
+	}
+	if _, ok := err.(*InvalidOptionError); !ok {
+		t.Errorf("got %T, want InvalidOptionError", err)
+	}
+}
+
+type (
+	shortWriter struct{}
+	errWriter   struct{}
+)
+
+var errWrite = errors.New("write error")
+
+func (shortWriter) Write(b []byte) (int, error) { return len(b) / 2, nil }
+func (errWriter) Write(b []byte) (int, error)   { return 1, errWrite }
+
+func TestStreamEncoderErrors(t *testing.T) {
+	for _, tt := range []struct {
+		w       io.Writer
+		err     error
+		written int
+	}{
+		{shortWriter{}, io.ErrShortWrite, 3},
+		{errWriter{}, errWrite, 1},
+	} {
+		enc, err := NewStreamEncoder(tt.w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = enc.Encode("abcd")
+		if err == nil {
+			t.Fatal("expected non-nil error")
+		}
+		we, ok := err.(*WriteError)
+		if !ok {
+			t.Fatalf("got %T, want WriteError", err)
+		}
+		if !errors.Is(err, tt.err) {
+			t.Errorf("got %v, want %v", we.Err, tt.err)
+		}
+		if we.Written != tt.written {
+			t.Errorf("got %d written bytes, want %d", we.Written, tt.written)
+		}
+	}
+	// Encoding errors must be returned as is, and
+	// nothing should be written to the stream.
+	var buf bytes.Buffer
+
+	enc, err := NewStreamEncoder(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = enc.Encode(math.NaN())
+	if _, ok := err.(*UnsupportedValueError); !ok {
+		t.Errorf("got %T, want UnsupportedValueError", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected empty output, got %s", buf.String())
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	const input = ` {"a":1} [true] "s" 42 null
+	-1.5e3{}`
+
+	for _, r := range []io.Reader{
+		strings.NewReader(input),
+		iotest.OneByteReader(strings.NewReader(input)),
+		iotest.DataErrReader(strings.NewReader(input)),
+	} {
+		var (
+			dec  = NewDecoder(r)
+			std  = json.NewDecoder(strings.NewReader(input))
+			v1   []interface{}
+			v2   []interface{}
+			err1 error
+			err2 error
+		)
+		for err1 == nil {
+			var v interface{}
+			if err1 = dec.Decode(&v); err1 == nil {
+				v1 = append(v1, v)
+			}
+		}
+		for err2 == nil {
+			var v interface{}
+			if err2 = std.Decode(&v); err2 == nil {
+				v2 = append(v2, v)
+			}
+		}
+		if err1 != io.EOF {
+			t.Errorf("got %v, want EOF", err1)
+		}
+		if !reflect.DeepEqual(v1, v2) {
+			t.Errorf("got %v, want %v", v1, v2)
+		}
+		// The error is sticky.
+		if err := dec.Decode(new(interface{})); err != io.EOF {
+			t.Errorf("got %v, want EOF", err)
+		}
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	for _, tt := range []struct {
+		input string
+		err   error
+	}{
+		{``, io.EOF},
+		{`  `, io.EOF},
+		{`{"a":`, io.ErrUnexpectedEOF},
+		{`[1, `, io.ErrUnexpectedEOF},
+		{`-`, io.ErrUnexpectedEOF},
+	} {
+		err := NewDecoder(strings.NewReader(tt.input)).Decode(new(interface{}))
+		if err != tt.err {
+			t.Errorf("%q: got %v, want %v", tt.input, err, tt.err)
+		}
+	}
+	// The offsets are relative to the start
+	// of the input, not of the value.
+	dec := NewDecoder(strings.NewReader(`[1] {"a" 1}`))
+	if err := dec.Decode(new(interface{})); err != nil {
+		t.Fatal(err)
+	}
+	err := dec.Decode(new(interface{}))
+	if e, ok := err.(*SyntaxError); !ok || e.Offset != 10 {
+		t.Errorf("got %v, want SyntaxError at offset 10", err)
+	}
+	dec = NewDecoder(strings.NewReader(`1 "a"`))
+	if err := dec.Decode(new(int)); err != nil {
+		t.Fatal(err)
+	}
+	err = dec.Decode(new(int))
+	if e, ok := err.(*UnmarshalTypeError); !ok || e.Offset != 5 {
+		t.Errorf("got %v, want UnmarshalTypeError at offset 5", err)
+	}
+	readErr := errors.New("read error")
+	dec = NewDecoder(io.MultiReader(strings.NewReader(`[1,`), iotest.ErrReader(readErr)))
+	if err := dec.Decode(new(interface{})); err != readErr {
+		t.Errorf("got %v, want %v", err, readErr)
+	}
+}
+
+func TestDecoderOptions(t *testing.T) {
+	type x struct {
+		A int `json:"a"`
+	}
+	dec := NewDecoder(strings.NewReader(`{"a":1,"b":2}`))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(&x{})
+	if err == nil || err.Error() != `json: unknown field "b"` {
+		t.Errorf("got %v, want unknown field error", err)
+	}
+	dec = NewDecoder(strings.NewReader(`[1.50, 1e400]`))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{json.Number("1.50"), json.Number("1e400")}; !reflect.DeepEqual(v, want) {
+		t.Errorf("got %v, want %v", v, want)
+	}
+}
+
+func TestDecoderSetOptions(t *testing.T) {
+	type x struct {
+		UserID  int64
+		Elapsed time.Duration
+	}
+	dec := NewDecoder(strings.NewReader(`{"user_id":"7","elapsed":1.5} {"UserID":8}`))
+	if err := dec.Options(FieldNaming(SnakeCase), Int64Format(Int64String), DurationFormat(DurationSeconds)); err != nil {
+		t.Fatal(err)
+	}
+	var v x
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if want := (x{UserID: 7, Elapsed: 1500 * time.Millisecond}); v != want {
+		t.Errorf("got %+v, want %+v", v, want)
+	}
+	// The options replace the previous ones,
+	// and are left unchanged if invalid.
+	if err := dec.Options(MaxDepth(-1)); err == nil {
+		t.Error("expected non-nil error")
+	}
+	if err := dec.Options(); err != nil {
+		t.Fatal(err)
+	}
+	v = x{}
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v.UserID != 8 {
+		t.Errorf("got %d, want 8", v.UserID)
+	}
+}
+
+func TestDecoderBuffered(t *testing.T) {
+	dec := NewDecoder(strings.NewReader(`{"a":1} rest`))
+	if err := dec.Decode(new(interface{})); err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(dec.Buffered())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, want := string(b), " rest"; s != want {
+		t.Errorf("got %q, want %q", s, want)
+	}
+}
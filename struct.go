@@ -6,14 +6,11 @@ import (
 	"reflect"
 	"sort"
 	"strings"
-	"sync"
 	"unicode"
 )
 
 const validChars = "!#$%&()*+-./:<=>?@[]^_{|}~ "
 
-var fieldsCache sync.Map // map[reflect.Type][]field
-
 type seq struct {
 	offset uintptr
 	indir  bool
@@ -29,17 +26,48 @@ type field struct {
 	quoted            bool
 	omitEmpty         bool
 	omitNil           bool
+	omitZero          bool
 	omitNullMarshaler bool
 	instr             instruction
 	empty             emptyFunc
+	zero              emptyFunc
+
+	// precision is the number of decimals of a float
+	// field, and scale the number of decimals of the
+	// number represented by an integer field, set by
+	// the tag's options, or -1 if not set.
+	precision int
+	scale     int
+
+	// tagErr records an invalid option of the tag.
+	tagErr error
+
+	// inline indicates whether the field is a map
+	// whose entries are encoded as members of the
+	// enclosing object, with the inline tag option.
+	inline      bool
+	inlineInstr inlineInstr
 
 	// embedSeq represents the sequence of offsets
 	// and indirections to follow to reach the field
 	// through one or more anonymous fields.
 	embedSeq []seq
+
+	// prefix is prepended to the names of the fields
+	// of a flattened struct, set by the tag's option
+	// of the field and those of its parents.
+	prefix string
 }
 
-type typeCount map[reflect.Type]int
+// scanKey identifies a struct to scan for fields.
+// The same struct can be flattened several times
+// with different prefixes.
+type scanKey struct {
+	typ    reflect.Type
+	prefix string
+}
+
+type typeCount map[scanKey]int
 
 // byIndex sorts a list of fields by index sequence.
 type byIndex []field
@@ -61,11 +89,11 @@ func (x byIndex) Less(i, j int) bool {
 
 // cachedFields is similar to structFields, but uses a
 // cache to avoid repeated work.
-func cachedFields(t reflect.Type) []field {
-	if f, ok := fieldsCache.Load(t); ok {
+func (c *compiler) cachedFields(t reflect.Type) []field {
+	if f, ok := c.fieldsCache.Load(t); ok {
 		return f.([]field)
 	}
-	f, _ := fieldsCache.LoadOrStore(t, structFields(t))
+	f, _ := c.fieldsCache.LoadOrStore(t, structFields(t, c.naming))
 	return f.([]field)
 }
 
@@ -73,26 +101,30 @@ func cachedFields(t reflect.Type) []field {
 // encoded for the given struct type. The algorithm is
 // breadth-first search over the set of structs to include,
 // the top one and then any reachable anonymous structs.
-func structFields(t reflect.Type) []field {
+// The names of the fields that have no name in their JSON
+// tag are derived from their Go name with the policy np,
+// if not nil.
+func structFields(t reflect.Type, np *NamingPolicy) []field {
 	var (
 		flds []field
 		ccnt typeCount
 		curr = []field{}
 		next = []field{{typ: t}}
 		ncnt = make(typeCount)
-		seen = make(map[reflect.Type]bool)
+		seen = make(map[scanKey]bool)
 	)
 	for len(next) > 0 {
 		curr, next = next, curr[:0]
-		ccnt, ncnt = ncnt, make(map[reflect.Type]int)
+		ccnt, ncnt = ncnt, make(typeCount)
 
 		for _, f := range curr {
-			if seen[f.typ] {
+			k := scanKey{f.typ, f.prefix}
+			if seen[k] {
 				continue
 			}
-			seen[f.typ] = true
+			seen[k] = true
 			// Scan the type for fields to encode.
-			flds, next = scanFields(f, flds, next, ccnt, ncnt)
+			flds, next = scanFields(t, f, flds, next, ccnt, ncnt, np)
 		}
 	}
 	sortFields(flds)
@@ -148,6 +180,49 @@ func shouldEncodeField(sf reflect.StructField) bool {
 	return true
 }
 
+// fieldsTagError returns the error of the first
+// field of the list whose tag has an invalid option.
+func fieldsTagError(flds []field) error {
+	for i := range flds {
+		if flds[i].tagErr != nil {
+			return flds[i].tagErr
+		}
+	}
+	return nil
+}
+
+// numberTagOptions returns the values of the precision
+// and scale options of the tag of the struct field sf,
+// declared by the struct type t, or -1 for the options
+// that are not set. A TagError is returned if a value
+// is not an integer between 0 and 255, or if an option
+// doesn't apply to the kind of the field, the precision
+// applying to the floats and the scale to the integers,
+// or to the pointers to them.
+func numberTagOptions(t reflect.Type, sf reflect.StructField, opts tagOptions) (prec, scale int, err error) {
+	et := sf.Type
+	for et.Kind() == reflect.Ptr {
+		et = et.Elem()
+	}
+	prec, ok := opts.Uint("precision")
+	if !ok || prec >= 0 && !isFloatingPoint(et) {
+		return -1, -1, invalidTagOption(t, sf, opts, "precision")
+	}
+	scale, ok = opts.Uint("scale")
+	if !ok || scale >= 0 && !isInteger(et) {
+		return -1, -1, invalidTagOption(t, sf, opts, "scale")
+	}
+	return prec, scale, nil
+}
+
+// invalidTagOption returns a TagError for the option
+// name of the tag of the struct field sf, declared
+// by the struct type t.
+func invalidTagOption(t reflect.Type, sf reflect.StructField, opts tagOptions, name string) error {
+	v, _ := opts.Value(name)
+	return &TagError{Type: t, Field: sf.Name, Option: name + "=" + v}
+}
+
 // isValidFieldName returns whether s is a valid
 // name and can be used as a JSON key to encode
 // a struct field.
@@ -227,9 +302,28 @@ func dominantField(fields []field) (field, bool) {
 	return fields[0], true
 }
 
-func scanFields(f field, fields, next []field, cnt, ncnt typeCount) ([]field, []field) {
+// isCyclic returns whether the struct type typ is the
+// type of the struct t, or of one of the structs that
+// are traversed to reach the field of t at index, whose
+// type is typ.
+func isCyclic(t, typ reflect.Type, index []int) bool {
+	for i := 0; i < len(index); i++ {
+		pt := typeByIndex(t, index[:i])
+		if pt.Kind() == reflect.Ptr {
+			pt = pt.Elem()
+		}
+		if pt == typ {
+			return true
+		}
+	}
+	return false
+}
+
+func scanFields(t reflect.Type, f field, fields, next []field, cnt, ncnt typeCount, np *NamingPolicy) ([]field, []field) {
 	var escBuf bytes.Buffer
 
+	fcnt := cnt[scanKey{f.typ, f.prefix}]
+
 	for i := 0; i < f.typ.NumField(); i++ {
 		sf := f.typ.Field(i)
 
@@ -255,21 +349,57 @@ func scanFields(f field, fields, next []field, cnt, ncnt typeCount) ([]field, []
 		if typ.Name() == "" && isPtr {
 			typ = typ.Elem()
 		}
+		if opts.Contains("inline") && isInlineMapType(sf.Type) {
+			// The inline maps have no name, and are
+			// recorded with an empty one, so that the
+			// shallowest dominates the others, like
+			// the fields with the same name.
+			fields = append(fields, field{
+				typ:      typ,
+				tag:      true,
+				index:    index,
+				inline:   true,
+				embedSeq: append(append(f.embedSeq[:0:0], f.embedSeq...), seq{sf.Offset, false}),
+			})
+			if fcnt > 1 {
+				fields = append(fields, fields[len(fields)-1])
+			}
+			continue
+		}
+		// The unnamed embedded structs are flattened,
+		// and so are the struct fields with the inline
+		// option, along with an optional key prefix.
+		// A struct that contains itself, directly or not,
+		// can't be flattened with a prefix, which would
+		// have no end.
+		flatten := typ.Kind() == reflect.Struct && (name == "" && sf.Anonymous || opts.Contains("inline"))
+		prefix := f.prefix
+		if p, ok := opts.Value("prefix"); ok && flatten && isValidFieldName(p) {
+			prefix += p
+		}
+		if flatten && prefix != "" && isCyclic(t, typ, index) {
+			flatten = false
+		}
 		// If the field is a named embedded struct or a
 		// simple field, record it and its index sequence.
-		if name != "" || !sf.Anonymous || typ.Kind() != reflect.Struct {
+		if !flatten {
 			tagged := name != ""
 			// If a name is not present in the tag,
-			// use the struct field's name instead.
+			// use the struct field's name instead,
+			// converted with the naming policy.
 			if name == "" {
-				name = sf.Name
+				name = np.fieldName(sf.Name)
 			}
+			name = f.prefix + name
 			// Build HTML escaped field key.
 			escBuf.Reset()
 			_, _ = escBuf.WriteString(`"`)
 			json.HTMLEscape(&escBuf, []byte(name))
 			_, _ = escBuf.WriteString(`":`)
 
+			// The invalid number options are reported
+			// when the struct is encoded or decoded.
+			prec, scale, tagErr := numberTagOptions(f.typ, sf, opts)
 			nf := field{
 				typ:        typ,
 				name:       name,
@@ -277,7 +407,11 @@ func scanFields(f field, fields, next []field, cnt, ncnt typeCount) ([]field, []
 				index:      index,
 				omitEmpty:  opts.Contains("omitempty"),
 				omitNil:    opts.Contains("omitnil"),
+				omitZero:   opts.Contains("omitzero"),
 				quoted:     opts.Contains("string") && isBasicType(typ),
+				precision:  prec,
+				scale:      scale,
+				tagErr:     tagErr,
 				keyNonEsc:  []byte(`"` + name + `":`),
 				keyEscHTML: append([]byte(nil), escBuf.Bytes()...),  // copy
 				embedSeq:   append(f.embedSeq[:0:0], f.embedSeq...), // clone
@@ -286,7 +420,7 @@ func scanFields(f field, fields, next []field, cnt, ncnt typeCount) ([]field, []
 			nf.embedSeq = append(nf.embedSeq, seq{sf.Offset, false})
 			fields = append(fields, nf)
 
-			if cnt[f.typ] > 1 {
+			if fcnt > 1 {
 				// If there were multiple instances, add a
 				// second, so that the annihilation code will
 				// see a duplicate. It only cares about the
@@ -298,12 +432,14 @@ func scanFields(f field, fields, next []field, cnt, ncnt typeCount) ([]field, []
 		}
 		// Record unnamed embedded struct
 		// to be scanned in the next round.
-		ncnt[typ]++
-		if ncnt[typ] == 1 {
+		k := scanKey{typ, prefix}
+		ncnt[k]++
+		if ncnt[k] == 1 {
 			next = append(next, field{
 				typ:      typ,
 				name:     typ.Name(),
 				index:    index,
+				prefix:   prefix,
 				embedSeq: append(f.embedSeq, seq{sf.Offset, isPtr}),
 			})
 		}
@@ -1,6 +1,9 @@
 package jettison
 
-import "strings"
+import (
+	"strconv"
+	"strings"
+)
 
 // tagOptions represents the arguments following
 // a comma in a struct field's tag.
@@ -28,3 +31,30 @@ func (opts tagOptions) Contains(name string) bool {
 	}
 	return false
 }
+
+// Value returns the value of an option of the
+// form name=value, and whether it was found.
+func (opts tagOptions) Value(name string) (string, bool) {
+	for _, o := range opts {
+		if len(o) > len(name) && o[len(name)] == '=' && o[:len(name)] == name {
+			return o[len(name)+1:], true
+		}
+	}
+	return "", false
+}
+
+// Uint returns the value of an option of the form
+// name=N, where N is an integer between 0 and 255,
+// or -1 if the option is not found. It also returns
+// whether the value of the option is valid.
+func (opts tagOptions) Uint(name string) (int, bool) {
+	v, ok := opts.Value(name)
+	if !ok {
+		return -1, true
+	}
+	n, err := strconv.ParseUint(v, 10, 8)
+	if err != nil {
+		return -1, false
+	}
+	return int(n), true
+}
@@ -31,3 +31,99 @@ func TestParseTag(t *testing.T) {
 		}
 	}
 }
+
+func TestTagOptionValue(t *testing.T) {
+	_, opts := parseTag("a,omitempty,precision=2,scale=x,scale2=3,precision=4,size=")
+	for _, tt := range []struct {
+		name string
+		val  string
+		ok   bool
+	}{
+		{"precision", "2", true},
+		{"scale", "x", true},
+		{"scale2", "3", true},
+		{"size", "", true},
+		{"omitempty", "", false},
+		{"prec", "", false},
+	} {
+		v, ok := opts.Value(tt.name)
+		if v != tt.val || ok != tt.ok {
+			t.Errorf("%s: got (%q, %t), want (%q, %t)", tt.name, v, ok, tt.val, tt.ok)
+		}
+	}
+	for _, tt := range []struct {
+		name string
+		n    int
+		ok   bool
+	}{
+		{"precision", 2, true},
+		{"scale", -1, false},
+		{"scale2", 3, true},
+		{"size", -1, false},
+		{"omitempty", -1, true},
+	} {
+		if n, ok := opts.Uint(tt.name); n != tt.n || ok != tt.ok {
+			t.Errorf("%s: got (%d, %t), want (%d, %t)", tt.name, n, ok, tt.n, tt.ok)
+		}
+	}
+	_, opts = parseTag(",precision=-1,scale=256")
+	if _, ok := opts.Uint("precision"); ok {
+		t.Error("expected invalid precision")
+	}
+	if _, ok := opts.Uint("scale"); ok {
+		t.Error("expected invalid scale")
+	}
+}
+
+func TestInvalidTagOption(t *testing.T) {
+	type (
+		prec struct {
+			F float64 `json:"f,precision=abc"`
+		}
+		scale struct {
+			A int `json:"a"`
+			B int `json:"b,scale=300"`
+		}
+		precInt struct {
+			I int `json:"i,precision=2"`
+		}
+		scaleFloat struct {
+			F float64 `json:"f,scale=2"`
+		}
+		precSlice struct {
+			S []float64 `json:"s,precision=2"`
+		}
+		scalePtr struct {
+			P **string `json:"p,scale=1"`
+		}
+	)
+	for _, tt := range []struct {
+		v    interface{}
+		want string
+	}{
+		{prec{}, `json: invalid option "precision=abc" in the tag of field F of type jettison.prec`},
+		{&scale{}, `json: invalid option "scale=300" in the tag of field B of type jettison.scale`},
+		{[]interface{}{scale{}}, `json: invalid option "scale=300" in the tag of field B of type jettison.scale`},
+		// The options apply to a single kind.
+		{precInt{}, `json: invalid option "precision=2" in the tag of field I of type jettison.precInt`},
+		{scaleFloat{}, `json: invalid option "scale=2" in the tag of field F of type jettison.scaleFloat`},
+		{precSlice{}, `json: invalid option "precision=2" in the tag of field S of type jettison.precSlice`},
+		{scalePtr{}, `json: invalid option "scale=1" in the tag of field P of type jettison.scalePtr`},
+	} {
+		_, err := Marshal(tt.v)
+		if _, ok := err.(*TagError); !ok {
+			t.Errorf("%T: got %T, want TagError", tt.v, err)
+			continue
+		}
+		if s := err.Error(); s != tt.want {
+			t.Errorf("got %q, want %q", s, tt.want)
+		}
+	}
+	var v scale
+	err := Unmarshal([]byte(`{"a":1,"b":2}`), &v)
+	if te, ok := err.(*TagError); !ok {
+		t.Errorf("got %T, want TagError", err)
+	} else if te.Field != "B" || te.Option != "scale=300" {
+		t.Errorf("got field %s and option %s, want B and scale=300", te.Field, te.Option)
+	}
+}
@@ -0,0 +1,123 @@
+package jettison
+
+import (
+	"reflect"
+	"unsafe"
+)
+
+// A TypedEncoder encodes values of type T. Unlike an
+// Encoder, the instruction used to encode the values
+// is resolved once, when the encoder is created, and
+// the values are not converted to an interface, which
+// saves a cache lookup and a type assertion per call.
+// As a consequence, the type encoders registered after
+// the creation of a TypedEncoder do not apply to it.
+// A TypedEncoder is safe for concurrent use by multiple
+// goroutines.
+type TypedEncoder[T any] struct {
+	ins  instruction
+	opts encOpts
+	err  error // selection error
+}
+
+// NewTypedEncoder returns a new TypedEncoder for the
+// type T, configured with the given options.
+// An InvalidOptionError is returned if one of the
+// options is invalid, and a SelectionError if the
+// Fields option selects an unknown field of T.
+func NewTypedEncoder[T any](opts ...Option) (*TypedEncoder[T], error) {
+	eo, err := defaultEncOpts().with(opts...)
+	if err != nil {
+		return nil, err
+	}
+	e := newTypedEncoder[T](eo)
+	if e.err != nil {
+		return nil, e.err
+	}
+	return e, nil
+}
+
+// TypedEncoderOf returns a new TypedEncoder for the
+// type T that uses the options of the encoder e. If
+// the Fields option of e selects an unknown field of
+// T, the methods of the encoder return a SelectionError.
+func TypedEncoderOf[T any](e *Encoder) *TypedEncoder[T] {
+	return newTypedEncoder[T](e.opts)
+}
+
+func newTypedEncoder[T any](opts encOpts) *TypedEncoder[T] {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	e := &TypedEncoder[T]{opts: opts}
+
+	// The field selection only depends on the
+	// type, and is validated once.
+	if opts.selection != nil {
+		if e.err = opts.selection.validate(t, opts.iset); e.err != nil {
+			return e
+		}
+	}
+	// The instruction is not wrapped for inlined
+	// types like cachedInstr does, because it is
+	// always given a pointer to the value, rather
+	// than the data word of an interface.
+	// Addressability is the same as for values
+	// given to Marshal, to produce equal outputs.
+	e.ins = opts.iset.compiler().newInstruction(t, t.Kind() == reflect.Ptr, false)
+
+	return e
+}
+
+// Marshal returns the JSON encoding of v.
+// The value is moved to the heap, since it is passed
+// by pointer to the marshalers and registered encoders
+// that it may hold, which can retain it. Append doesn't
+// need to copy the value it is given.
+func (e *TypedEncoder[T]) Marshal(v T) ([]byte, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	buf := cachedBuffer()
+
+	var err error
+	buf.B, err = e.append(buf.B, unsafe.Pointer(&v))
+
+	var b []byte
+	if err == nil {
+		// Make a copy of the buffer's content
+		// before its returned to the pool.
+		b = make([]byte, len(buf.B))
+		copy(b, buf.B)
+	}
+	bufferPool.Put(buf)
+
+	return b, err
+}
+
+// Append appends the JSON representation of the value
+// pointed by v to dst and returns the extended buffer.
+// A nil pointer is encoded as the JSON null value.
+func (e *TypedEncoder[T]) Append(dst []byte, v *T) ([]byte, error) {
+	if e.err != nil {
+		return dst, e.err
+	}
+	if v == nil {
+		return append(dst, "null"...), nil
+	}
+	return e.append(dst, unsafe.Pointer(v))
+}
+
+// append appends the JSON representation
+// of the value pointed by p to dst.
+func (e *TypedEncoder[T]) append(dst []byte, p unsafe.Pointer) ([]byte, error) {
+	opts := e.opts
+	opts.sizeOff = -len(dst)
+
+	dst, err := e.ins(p, dst, opts)
+	if err != nil {
+		dst, err = nullIfOmitted(dst, err)
+	}
+	if err == nil && opts.sizeExceeded(dst) {
+		err = &SizeError{MaxBytes: opts.maxBytes}
+	}
+	return dst, err
+}
@@ -0,0 +1,129 @@
+package jettison
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func testTypedEncoder[T any](t *testing.T, v T, opts ...Option) {
+	enc, err := NewTypedEncoder[T](opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := MarshalOpts(v, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b1, err := enc.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b1, want) {
+		t.Errorf("Marshal: got %s, want %s", b1, want)
+	}
+	b2, err := enc.Append([]byte("x"), &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b2, append([]byte("x"), want...)) {
+		t.Errorf("Append: got %s, want x%s", b2, want)
+	}
+}
+
+func TestTypedEncoder(t *testing.T) {
+	var (
+		jmref = jmr("jmr")
+		jmval = jmv([]string{"a", "b", "c"})
+	)
+	testTypedEncoder(t, xx)
+	testTypedEncoder(t, &xx)
+	testTypedEncoder(t, (*x)(nil))
+	testTypedEncoder(t, "Loreum")
+	testTypedEncoder(t, math.MaxInt64)
+	testTypedEncoder(t, []int{1, 2, 3})
+	testTypedEncoder(t, [2]bool{true, false})
+	testTypedEncoder(t, map[string]int{"a": 1, "b": 2})
+	testTypedEncoder(t, map[string]int(nil), NilMapEmpty())
+	testTypedEncoder(t, struct{ M map[int]string }{})
+	testTypedEncoder(t, jmref)
+	testTypedEncoder(t, &jmref)
+	testTypedEncoder(t, jmval)
+	testTypedEncoder[interface{}](t, nil)
+	testTypedEncoder[interface{}](t, 42)
+	testTypedEncoder[json.Marshaler](t, jmval)
+	testTypedEncoder[json.Marshaler](t, nil)
+}
+
+func TestTypedEncoderNilPointer(t *testing.T) {
+	enc, err := NewTypedEncoder[int]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := enc.Append(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(b); s != "null" {
+		t.Errorf("got %s, want null", s)
+	}
+}
+
+func TestTypedEncoderAllocs(t *testing.T) {
+	enc, err := NewTypedEncoder[simplePayload]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := *newSimplePayload()
+	if _, err := enc.Marshal(v); err != nil { // warm up
+		t.Fatal(err)
+	}
+	// The copy of the value, which escapes, and
+	// the copy of the output.
+	n := testing.AllocsPerRun(100, func() {
+		_, _ = enc.Marshal(v)
+	})
+	if n != 2 {
+		t.Errorf("got %v allocs, want 2", n)
+	}
+	// Append takes a pointer to the value, and
+	// appends to the buffer it is given.
+	var buf []byte
+	n = testing.AllocsPerRun(100, func() {
+		buf, _ = enc.Append(buf[:0], &v)
+	})
+	if n != 0 {
+		t.Errorf("got %v allocs, want 0", n)
+	}
+}
+
+func TestTypedEncoderOf(t *testing.T) {
+	e, err := NewEncoder(UnixTime(), NoHTMLEscaping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := TypedEncoderOf[x](e)
+
+	want, err := e.Marshal(xx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := enc.Marshal(xx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, want) {
+		t.Errorf("got %s, want %s", b, want)
+	}
+}
+
+func TestInvalidTypedEncoderOpts(t *testing.T) {
+	_, err := NewTypedEncoder[int](TimeLayout(""))
+	if err == nil {
+		t.Fatal("expected non-nil error")
+	}
+	if _, ok := err.(*InvalidOptionError); !ok {
+		t.Errorf("got %T, want InvalidOptionError", err)
+	}
+}
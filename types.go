@@ -3,6 +3,8 @@ package jettison
 import (
 	"encoding"
 	"encoding/json"
+	"math"
+	"math/big"
 	"reflect"
 	"sync"
 	"time"
@@ -15,13 +17,29 @@ var (
 	syncMapType            = reflect.TypeOf((*sync.Map)(nil)).Elem()
 	jsonNumberType         = reflect.TypeOf(json.Number(""))
 	jsonRawMessageType     = reflect.TypeOf(json.RawMessage(nil))
+	bigIntType             = reflect.TypeOf(big.Int{})
+	bigFloatType           = reflect.TypeOf(big.Float{})
+	bigRatType             = reflect.TypeOf(big.Rat{})
 	jsonMarshalerType      = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
 	textMarshalerType      = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	jsonUnmarshalerType    = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType    = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
 	appendMarshalerType    = reflect.TypeOf((*AppendMarshaler)(nil)).Elem()
 	appendMarshalerCtxType = reflect.TypeOf((*AppendMarshalerCtx)(nil)).Elem()
+	isZeroerType           = reflect.TypeOf((*isZeroer)(nil)).Elem()
 )
 
-var emptyFnCache sync.Map // map[reflect.Type]emptyFunc
+var (
+	emptyFnCache sync.Map // map[reflect.Type]emptyFunc
+	zeroFnCache  sync.Map // map[reflect.Type]emptyFunc
+)
+
+// isZeroer is the interface implemented by types
+// that can report whether they represent a zero
+// value, such as time.Time.
+type isZeroer interface {
+	IsZero() bool
+}
 
 // emptyFunc is a function that returns whether a
 // value pointed by an unsafe.Pointer represents the
@@ -183,3 +201,152 @@ func emptyFuncOf(t reflect.Type) emptyFunc {
 	}
 	return func(unsafe.Pointer) bool { return false }
 }
+
+// cachedZeroFuncOf is similar to zeroFuncOf, but
+// returns a cached function, to avoid duplicates.
+func cachedZeroFuncOf(t reflect.Type) emptyFunc {
+	if fn, ok := zeroFnCache.Load(t); ok {
+		return fn.(emptyFunc)
+	}
+	fn, _ := zeroFnCache.LoadOrStore(t, zeroFuncOf(t))
+	return fn.(emptyFunc)
+}
+
+// zeroFuncOf returns a function that can be used to
+// determine if a value pointed by an unsafe.Pointer
+// is zero. The IsZero method of type t is used if it
+// implements the isZeroer interface, either with a
+// value or a pointer receiver, otherwise the value
+// is compared to the zero-value of t.
+func zeroFuncOf(t reflect.Type) emptyFunc {
+	switch {
+	case t.Kind() == reflect.Ptr && t.Implements(isZeroerType):
+		return func(p unsafe.Pointer) bool {
+			// Avoid a panic calling the method
+			// with a nil pointer, which is zero.
+			if *(*unsafe.Pointer)(p) == nil {
+				return true
+			}
+			return packEface(p, t, true).(isZeroer).IsZero()
+		}
+	case t.Kind() == reflect.Interface && t.Implements(isZeroerType):
+		return func(p unsafe.Pointer) bool {
+			// The layout of all the interfaces with
+			// methods is the same, see encodeMarshaler.
+			var i interface{} = *(*interface{ M() })(p)
+			return i == nil || i.(isZeroer).IsZero()
+		}
+	case t.Implements(isZeroerType):
+		return func(p unsafe.Pointer) bool {
+			return packEface(p, t, isInlined(t)).(isZeroer).IsZero()
+		}
+	case reflect.PtrTo(t).Implements(isZeroerType):
+		pt := reflect.PtrTo(t)
+		return func(p unsafe.Pointer) bool {
+			return packEface(p, pt, false).(isZeroer).IsZero()
+		}
+	}
+	return zeroValueFuncOf(t)
+}
+
+// zeroValueFuncOf returns a function that can be used
+// to determine if a value pointed by an unsafe.Pointer
+// is equal to the zero-value of type t, similarly to
+// the IsZero method of reflect.Value.
+func zeroValueFuncOf(t reflect.Type) emptyFunc {
+	switch t.Kind() {
+	case reflect.Float32:
+		// Negative zero is not the zero-value.
+		return func(p unsafe.Pointer) bool {
+			return math.Float32bits(*(*float32)(p)) == 0
+		}
+	case reflect.Float64:
+		return func(p unsafe.Pointer) bool {
+			return math.Float64bits(*(*float64)(p)) == 0
+		}
+	case reflect.Complex64:
+		return func(p unsafe.Pointer) bool {
+			return *(*uint64)(p) == 0
+		}
+	case reflect.Complex128:
+		return func(p unsafe.Pointer) bool {
+			return *(*[2]uint64)(p) == [2]uint64{}
+		}
+	case reflect.Map, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		// An empty map is not the zero-value.
+		return func(p unsafe.Pointer) bool {
+			return *(*unsafe.Pointer)(p) == nil
+		}
+	case reflect.Slice:
+		// Neither is an empty non-nil slice.
+		return func(p unsafe.Pointer) bool {
+			return (*sliceHeader)(p).Data == nil
+		}
+	case reflect.Array:
+		return newArrayZeroFunc(t)
+	case reflect.Struct:
+		return newStructZeroFunc(t)
+	}
+	// The zero-value of the remaining kinds is
+	// the same as their empty value.
+	return emptyFuncOf(t)
+}
+
+func newArrayZeroFunc(t reflect.Type) emptyFunc {
+	var (
+		size = t.Elem().Size()
+		alen = uintptr(t.Len())
+	)
+	if alen == 0 {
+		return func(unsafe.Pointer) bool { return true }
+	}
+	if t.Elem().Kind() == reflect.Uint8 {
+		// Fast path for byte arrays, such as UUIDs.
+		return func(p unsafe.Pointer) bool {
+			for i := uintptr(0); i < alen; i++ {
+				if *(*byte)(unsafe.Pointer(uintptr(p) + i)) != 0 {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	efn := zeroValueFuncOf(t.Elem())
+
+	return func(p unsafe.Pointer) bool {
+		for i := uintptr(0); i < alen; i++ {
+			if !efn(unsafe.Pointer(uintptr(p) + i*size)) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func newStructZeroFunc(t reflect.Type) emptyFunc {
+	type fieldZero struct {
+		offset uintptr
+		fn     emptyFunc
+	}
+	var flds []fieldZero
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Name == "_" {
+			// Blank fields are ignored.
+			continue
+		}
+		flds = append(flds, fieldZero{
+			offset: sf.Offset,
+			fn:     zeroValueFuncOf(sf.Type),
+		})
+	}
+	return func(p unsafe.Pointer) bool {
+		for _, f := range flds {
+			if !f.fn(unsafe.Pointer(uintptr(p) + f.offset)) {
+				return false
+			}
+		}
+		return true
+	}
+}